@@ -0,0 +1,106 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"sort"
+	"strings"
+)
+
+// AbbreviationMode decides how long options of the command line abbreviate
+// tokens
+type AbbreviationMode int
+
+const (
+	// ABBREV_SHORTEST takes the argument of the shortest token the option
+	// is a prefix of, the default
+	ABBREV_SHORTEST AbbreviationMode = iota
+	// ABBREV_UNAMBIGUOUS takes the argument the option is a prefix of the
+	// tokens of only, like getopt_long does, e.g. --bool-def for
+	// --bool-default-true.  Prefixes of tokens of more than one argument
+	// are reported as AmbiguousArgumentError
+	ABBREV_UNAMBIGUOUS
+	// ABBREV_NONE requires options to be the tokens exactly
+	ABBREV_NONE
+)
+
+// SetAbbreviationMode sets how long options abbreviate tokens, the default
+// is ABBREV_SHORTEST.  The mode is also applied to the parsers of
+// subcommands
+func (this *ArgumentParser) SetAbbreviationMode(mode AbbreviationMode) {
+	this.abbreviation = mode
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetAbbreviationMode(mode)
+		}
+	}
+}
+
+// matchOptionalArgument finds the argument of long option token of the
+// command line, without leading dashes, by the abbreviation mode
+func (this *ArgumentParser) matchOptionalArgument(token string) (Argument, bool, error) {
+	switch this.abbreviation {
+	case ABBREV_NONE:
+		arg, nega := this.findOptionalArgument(token, true)
+		return arg, nega, nil
+	case ABBREV_UNAMBIGUOUS:
+		return this.findUnambiguousArgument(token)
+	}
+	arg, nega := this.findOptionalArgument(token, false)
+	return arg, nega, nil
+}
+
+// findUnambiguousArgument finds the only argument with tokens token is a
+// prefix of, whichever of its tokens they are.  The negative token is taken
+// only if none of the others matches
+func (this *ArgumentParser) findUnambiguousArgument(token string) (Argument, bool, error) {
+	if arg, nega := this.findOptionalArgument(token, true); arg != nil {
+		return arg, nega, nil
+	}
+	var match Argument
+	negative := false
+	for _, arg := range this.optArgs {
+		positive := false
+		matched := false
+		for _, tk := range longTokens(arg) {
+			if strings.HasPrefix(tk, token) {
+				matched = true
+				positive = positive || tk != arg.NegativeToken()
+			}
+		}
+		if !matched {
+			continue
+		}
+		if match != nil {
+			return nil, false, this.ambiguousError(token)
+		}
+		match, negative = arg, !positive
+	}
+	return match, negative, nil
+}
+
+// ambiguousError lists the tokens of all arguments token is a prefix of
+func (this *ArgumentParser) ambiguousError(token string) error {
+	var cands []string
+	for _, arg := range this.optArgs {
+		for _, tk := range longTokens(arg) {
+			if strings.HasPrefix(tk, token) {
+				cands = append(cands, "--"+tk)
+			}
+		}
+	}
+	sort.Strings(cands)
+	return &AmbiguousArgumentError{Argument: "--" + token, Candidates: cands}
+}
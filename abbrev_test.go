@@ -0,0 +1,82 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nyl1001/pkg/errors"
+)
+
+func TestAbbreviationMode(t *testing.T) {
+	type Options struct {
+		BoolDefaultTrue  bool `negative:"no-bool-default-true"`
+		BoolDefaultFalse bool
+		Verbose          bool `negative:"verbose-off"`
+		Region           string
+		RegionId         string `alias:"zone"`
+	}
+	cases := []struct {
+		name string
+		mode AbbreviationMode
+		args []string
+		want Options
+		// candidates of the ambiguous error, nil if no error
+		cands []string
+		err   bool
+	}{
+		{"shortest", ABBREV_SHORTEST, []string{"--bool-def", "--reg", "r"}, Options{BoolDefaultTrue: true, Region: "r"}, nil, false},
+		{"unambiguous", ABBREV_UNAMBIGUOUS, []string{"--bool-default-t", "--region-", "r", "--zo", "z"}, Options{BoolDefaultTrue: true, RegionId: "z"}, nil, false},
+		{"exact over prefix", ABBREV_UNAMBIGUOUS, []string{"--region", "r"}, Options{Region: "r"}, nil, false},
+		{"positive over negative", ABBREV_UNAMBIGUOUS, []string{"--verb"}, Options{Verbose: true}, nil, false},
+		{"negative", ABBREV_UNAMBIGUOUS, []string{"--verbose", "--verbose-o"}, Options{}, nil, false},
+		{"ambiguous", ABBREV_UNAMBIGUOUS, []string{"--bool-def"}, Options{}, []string{"--bool-default-false", "--bool-default-true"}, true},
+		{"ambiguous alias", ABBREV_UNAMBIGUOUS, []string{"--re", "r"}, Options{}, []string{"--region", "--region-id"}, true},
+		{"none", ABBREV_NONE, []string{"--bool-default-true"}, Options{BoolDefaultTrue: true}, nil, false},
+		{"none prefix", ABBREV_NONE, []string{"--bool-default-t"}, Options{}, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Options{}
+			p, err := NewArgumentParserWithOptions(s, WithAbbreviationMode(c.mode))
+			if err != nil {
+				t.Fatalf("NewArgumentParserWithOptions: %v", err)
+			}
+			err = p.ParseArgs(c.args, false)
+			if c.err {
+				if err == nil {
+					t.Fatalf("expecting error")
+				}
+				if c.cands != nil {
+					aerr, ok := errors.Cause(err).(*AmbiguousArgumentError)
+					if !ok {
+						t.Fatalf("expecting AmbiguousArgumentError, got %v", err)
+					}
+					if !reflect.DeepEqual(aerr.Candidates, c.cands) {
+						t.Errorf("candidates: want %v, got %v", c.cands, aerr.Candidates)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if *s != c.want {
+				t.Errorf("want %#v, got %#v", c.want, *s)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// benchmarkOptions returns a target of n string options named Opt0 and so
+// on, with every fourth one boolean, and the command line setting them all
+func benchmarkOptions(n int) (interface{}, []string) {
+	fields := make([]reflect.StructField, 0, n)
+	args := make([]string, 0, 2*n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("Opt%d", i)
+		token := fmt.Sprintf("--opt%d", i)
+		if i%4 == 0 {
+			fields = append(fields, reflect.StructField{Name: name, Type: reflect.TypeOf(false)})
+			args = append(args, token)
+		} else {
+			fields = append(fields, reflect.StructField{Name: name, Type: reflect.TypeOf(""), Tag: `help:"option"`})
+			args = append(args, token, "value")
+		}
+	}
+	return reflect.New(reflect.StructOf(fields)).Interface(), args
+}
+
+func benchmarkParseArgs(b *testing.B, n int) {
+	target, args := benchmarkOptions(n)
+	p, err := NewArgumentParser(target, "prog", "", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.ParseArgs(args, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseArgs20(b *testing.B) {
+	benchmarkParseArgs(b, 20)
+}
+
+func BenchmarkParseArgs500(b *testing.B) {
+	benchmarkParseArgs(b, 500)
+}
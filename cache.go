@@ -0,0 +1,200 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/nyl1001/pkg/util/reflectutils"
+)
+
+// sParserTemplate is the parser of a struct type built once.  Parsers of
+// other targets of the type clone its arguments, binding them to their
+// fields, instead of analysing fields and tags again
+type sParserTemplate struct {
+	parser *ArgumentParser
+	// indexes of the fields of the arguments in the order of
+	// walkStructFields, -1 if there is no rest field
+	posFields []int
+	optFields []int
+	restField int
+	numFields int
+}
+
+var (
+	parserTemplates     = make(map[reflect.Type]*sParserTemplate)
+	parserTemplatesLock sync.RWMutex
+)
+
+func purgeParserTemplates() {
+	parserTemplatesLock.Lock()
+	defer parserTemplatesLock.Unlock()
+	parserTemplates = make(map[reflect.Type]*sParserTemplate)
+}
+
+// newCachedArgumentParser returns a parser of target from the template of
+// its type, building the template first if necessary.  It returns nil if
+// the type cannot be cached, leaving it to buildArgumentParser, which also
+// reports the errors
+func newCachedArgumentParser(target interface{}, prog, desc, epilog string) *ArgumentParser {
+	tp := reflect.TypeOf(target).Elem()
+	if tp.Kind() != reflect.Struct || !cacheableType(tp) {
+		return nil
+	}
+	parserTemplatesLock.RLock()
+	tmpl, ok := parserTemplates[tp]
+	parserTemplatesLock.RUnlock()
+	if !ok {
+		tmpl = newParserTemplate(tp)
+		parserTemplatesLock.Lock()
+		parserTemplates[tp] = tmpl
+		parserTemplatesLock.Unlock()
+	}
+	if tmpl == nil {
+		return nil
+	}
+	return tmpl.newParser(target, prog, desc, epilog)
+}
+
+// cacheableType tells whether the arguments of struct tp depend on the type
+// only, not the case with embedded interfaces walked by their values
+func cacheableType(tp reflect.Type) bool {
+	return cacheableStruct(tp, nil)
+}
+
+// cacheableStruct checks struct tp nested in the structs of path
+func cacheableStruct(tp reflect.Type, path []reflect.Type) bool {
+	for _, t := range path {
+		if t == tp {
+			// pointers to the structs walking are not walked into
+			return true
+		}
+	}
+	path = append(path, tp)
+	for i := 0; i < tp.NumField(); i++ {
+		sf := tp.Field(i)
+		ft := sf.Type
+		if sf.Anonymous && ft.Kind() == reflect.Interface {
+			return false
+		}
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !cacheableStruct(ft, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// newParserTemplate builds the template of struct tp, nil if the arguments
+// cannot be cached
+func newParserTemplate(tp reflect.Type) *sParserTemplate {
+	target := reflect.New(tp)
+	parser := &ArgumentParser{target: target.Interface()}
+	fields := make(map[Argument]int)
+	restField := -1
+	numFields := 0
+	_, err := walkStructFields("", "", target.Elem(), func(prefix string, group string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error {
+		hasRest := parser.restField.IsValid()
+		if err := parser.addArgument(prefix, group, fv, info); err != nil {
+			return err
+		}
+		for _, args := range [][]Argument{parser.posArgs, parser.optArgs} {
+			for _, arg := range args {
+				if _, ok := fields[arg]; !ok {
+					fields[arg] = numFields
+				}
+			}
+		}
+		if !hasRest && parser.restField.IsValid() {
+			restField = numFields
+		}
+		numFields++
+		return nil
+	})
+	if err != nil || parser.uncacheable || parser.checkReferences() != nil {
+		return nil
+	}
+	tmpl := &sParserTemplate{parser: parser, restField: restField, numFields: numFields}
+	for _, arg := range parser.posArgs {
+		tmpl.posFields = append(tmpl.posFields, fields[arg])
+	}
+	for _, arg := range parser.optArgs {
+		tmpl.optFields = append(tmpl.optFields, fields[arg])
+	}
+	return tmpl
+}
+
+// newParser returns a parser of target cloned from the template
+func (tmpl *sParserTemplate) newParser(target interface{}, prog, desc, epilog string) *ArgumentParser {
+	parser := &ArgumentParser{prog: prog, description: desc,
+		epilog: epilog, target: target}
+	values := make([]reflect.Value, 0, tmpl.numFields)
+	walker, _ := walkStructFields("", "", reflect.ValueOf(target).Elem(), func(prefix string, group string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error {
+		values = append(values, fv)
+		return nil
+	})
+	if len(values) != tmpl.numFields {
+		return nil
+	}
+	parser.sections, parser.structPrefixes = walker.sections, walker.prefixes
+	var ok bool
+	if parser.posArgs, ok = tmpl.bindArguments(parser, tmpl.parser.posArgs, tmpl.posFields, values); !ok {
+		return nil
+	}
+	if parser.optArgs, ok = tmpl.bindArguments(parser, tmpl.parser.optArgs, tmpl.optFields, values); !ok {
+		return nil
+	}
+	if tmpl.restField >= 0 {
+		parser.restField = values[tmpl.restField]
+	}
+	// always add a help argument --help
+	parser.AddArgument(&sHelpArg{})
+	return parser
+}
+
+func (tmpl *sParserTemplate) bindArguments(parser *ArgumentParser, args []Argument, fields []int, values []reflect.Value) ([]Argument, bool) {
+	bound := make([]Argument, 0, len(args)+1)
+	for i, arg := range args {
+		targ, ok := arg.(templateArgument)
+		if !ok {
+			return nil, false
+		}
+		value := values[fields[i]]
+		// the value of the target before parsing, restored by Reset
+		ovalue := reflect.New(value.Type()).Elem()
+		ovalue.Set(value)
+		clone, err := targ.cloneArgument(parser, value)
+		if err != nil {
+			return nil, false
+		}
+		clone.(templateArgument).setOriginalValue(ovalue)
+		bound = append(bound, clone)
+	}
+	return bound, true
+}
+
+// templateArgument is implemented by arguments of parser templates
+type templateArgument interface {
+	cloneableArgument
+	setOriginalValue(ovalue reflect.Value)
+}
+
+func (this *SingleArgument) setOriginalValue(ovalue reflect.Value) {
+	this.ovalue = ovalue
+	this.value.Set(ovalue)
+}
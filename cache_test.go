@@ -0,0 +1,118 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"os"
+	"testing"
+)
+
+type cacheTestOptions struct {
+	Debug    bool   `help:"Show debug information"`
+	Region   string `default:"r1" help:"Region name"`
+	Zone     string `help:"Zone name" choices:"z1|z2|z3"`
+	Port     int    `default:"8080" min:"1" max:"65535"`
+	Tags     []string
+	Key      string `pattern:"^[a-z]+$"`
+	Timeout  string `default:"30s" help:"Timeout of requests"`
+	Endpoint struct {
+		Url  string `help:"Endpoint url"`
+		Type string `default:"public" choices:"public|internal|admin"`
+	}
+	NAME string `help:"Name of the resource"`
+}
+
+func TestParserTemplate(t *testing.T) {
+	t.Run("independent", func(t *testing.T) {
+		s1 := &cacheTestOptions{Zone: "z2"}
+		p1 := mustNewParser(t, s1)
+		s2 := &cacheTestOptions{}
+		p2 := mustNewParser(t, s2)
+		if err := p1.ParseArgs([]string{"--tags", "t1", "n1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p2.ParseArgs([]string{"--zone", "z3", "--endpoint-type", "admin", "n2"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s1.Zone != "z2" || s1.NAME != "n1" || len(s1.Tags) != 1 || s1.Endpoint.Type != "public" || s1.Region != "r1" {
+			t.Errorf("unexpected options %#v", s1)
+		}
+		if s2.Zone != "z3" || s2.NAME != "n2" || len(s2.Tags) != 0 || s2.Endpoint.Type != "admin" {
+			t.Errorf("unexpected options %#v", s2)
+		}
+		if p1.HelpString() != p2.HelpString() {
+			t.Errorf("help differs:\n%s\n%s", p1.HelpString(), p2.HelpString())
+		}
+	})
+	t.Run("env default", func(t *testing.T) {
+		type Options struct {
+			Region string `default:"$STRUCTARG_TEST_TEMPLATE_REGION|r1"`
+		}
+		for _, want := range []string{"r2", "r3"} {
+			os.Setenv("STRUCTARG_TEST_TEMPLATE_REGION", want)
+			s := &Options{}
+			p := mustNewParser(t, s)
+			if err := p.ParseArgs(nil, false); err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if s.Region != want {
+				t.Errorf("want %s, got %s", want, s.Region)
+			}
+		}
+		os.Unsetenv("STRUCTARG_TEST_TEMPLATE_REGION")
+	})
+	t.Run("shared defaults", func(t *testing.T) {
+		type Options struct {
+			Tags  []string `default:"a,b"`
+			Ports []int    `default:"80,443"`
+		}
+		s1 := &Options{}
+		p1 := mustNewParser(t, s1)
+		if err := p1.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		s1.Tags[0] = "MUT"
+		s1.Ports[0] = 0
+		if err := p1.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		s2 := &Options{}
+		p2 := mustNewParser(t, s2)
+		if err := p2.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		for _, s := range []*Options{s1, s2} {
+			if len(s.Tags) != 2 || s.Tags[0] != "a" || len(s.Ports) != 2 || s.Ports[0] != 80 {
+				t.Errorf("default is changed: %#v", s)
+			}
+		}
+	})
+}
+
+func BenchmarkNewArgumentParser(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := NewArgumentParser(&cacheTestOptions{}, "prog", "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewArgumentParserUncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := buildArgumentParser(&cacheTestOptions{}, "prog", "", ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// SetChoicesFunc makes choices the source of the valid values of the
+// argument of token name, replacing those of the choices tag, e.g. zones
+// fetched from a live catalog.  choices is called whenever values are
+// checked, suggested or completed, an empty result accepts any value.
+// Help keeps showing the choices of the tag, if any, as it is not worth a
+// call of choices
+func (this *ArgumentParser) SetChoicesFunc(name string, choices func() []string) error {
+	return this.SetChoicesFuncContext(name, func(ctx context.Context) []string {
+		return choices()
+	})
+}
+
+// SetChoicesFuncContext is SetChoicesFunc of choices taking the context of
+// the parse, see ParseArgsContext.  Outside of parses, e.g. completion, it is
+// context.Background()
+func (this *ArgumentParser) SetChoicesFuncContext(name string, choices func(ctx context.Context) []string) error {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	if arg == nil {
+		return fmt.Errorf("No such argument %s", name)
+	}
+	carg, ok := arg.(choicesFuncArgument)
+	if !ok || arg.IsSubcommand() || !arg.NeedData() {
+		return fmt.Errorf("Argument %s takes no choices", name)
+	}
+	if isEnumArgument(arg) {
+		return fmt.Errorf("Argument %s takes names of enum", name)
+	}
+	carg.setChoicesFunc(choices)
+	return nil
+}
+
+// choicesFuncArgument is implemented by arguments supporting SetChoicesFunc
+type choicesFuncArgument interface {
+	setChoicesFunc(choices func(ctx context.Context) []string)
+	hasChoicesFunc() bool
+}
+
+func (this *SingleArgument) setChoicesFunc(choices func(ctx context.Context) []string) {
+	this.choicesFunc = choices
+}
+
+func (this *SingleArgument) hasChoicesFunc() bool {
+	return this.choicesFunc != nil
+}
+
+// SetChoicesIgnoreCase makes choices of all arguments match regardless of
+// case as with TAG_CHOICES_IGNORECASE.  The setting is also applied to the
+// parsers of subcommands
+func (this *ArgumentParser) SetChoicesIgnoreCase(ignore bool) {
+	this.choicesIgnoreCase = ignore
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetChoicesIgnoreCase(ignore)
+		}
+	}
+}
+
+// hasChoicesFunc tells whether choices of any argument of the parser are
+// known at runtime only
+func (this *ArgumentParser) hasChoicesFunc() bool {
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if carg, ok := arg.(choicesFuncArgument); ok && carg.hasChoicesFunc() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// choiceType is the type of values choices are converted to, i.e. elements
+// of slices and pointers
+func (this *SingleArgument) choiceType() reflect.Type {
+	tp := this.value.Type()
+	if tp.Kind() == reflect.Slice && !this.isScalar(tp) {
+		tp = tp.Elem()
+	}
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return tp
+}
+
+// typedChoices tells whether choices are compared as values instead of
+// strings, e.g. `choices:"80|443"` of int accepts 0443 as 443
+func (this *SingleArgument) typedChoices() bool {
+	return this.value.IsValid() && this.choiceType().Kind() != reflect.String
+}
+
+// parseChoices converts the choices of the tag, which must be valid values
+// of typed choices
+func (this *SingleArgument) parseChoices() error {
+	if len(this.choices) == 0 || this.enum || !this.typedChoices() {
+		return nil
+	}
+	tp := this.choiceType()
+	this.choiceValues = make([]reflect.Value, len(this.choices))
+	for i, choice := range this.choices {
+		rv, err := this.parseValue(choice, tp)
+		if err != nil {
+			return fmt.Errorf("invalid choice %q: %v", choice, err)
+		}
+		this.choiceValues[i] = rv
+	}
+	return nil
+}
+
+// matchTypedChoice returns the choice of the same value as val.  Those of
+// SetChoicesFunc are converted here, skipping invalid ones
+func (this *SingleArgument) matchTypedChoice(val string, choices []string) (string, bool) {
+	tp := this.choiceType()
+	rv, err := this.parseValue(val, tp)
+	if err != nil {
+		return val, false
+	}
+	for i, choice := range choices {
+		var cv reflect.Value
+		if this.choicesFunc == nil {
+			cv = this.choiceValues[i]
+		} else if cv, err = this.parseValue(choice, tp); err != nil {
+			continue
+		}
+		if reflect.DeepEqual(rv.Interface(), cv.Interface()) {
+			return choice, true
+		}
+	}
+	return val, false
+}
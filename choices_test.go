@@ -0,0 +1,178 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/errors"
+)
+
+func TestSetChoicesFunc(t *testing.T) {
+	s := &struct {
+		Zone  string `choices:"z1"`
+		Zones []string
+		Debug bool
+	}{}
+	p := mustNewParser(t, s)
+	catalog := []string{"zone-a", "zone-b"}
+	calls := 0
+	zones := func() []string {
+		calls++
+		return catalog
+	}
+	for _, name := range []string{"zone", "zones"} {
+		if err := p.SetChoicesFunc(name, zones); err != nil {
+			t.Fatalf("SetChoicesFunc %s: %v", name, err)
+		}
+	}
+	if err := p.SetChoicesFunc("debug", zones); err == nil {
+		t.Errorf("expecting error for flag")
+	}
+	if err := p.SetChoicesFunc("nonexist", zones); err == nil {
+		t.Errorf("expecting error for unknown argument")
+	}
+	if calls != 0 {
+		t.Errorf("choices called before parsing")
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		if err := p.ParseArgs([]string{"--zone", "zone-a", "--zones", "zone-b"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Zone != "zone-a" || len(s.Zones) != 1 || s.Zones[0] != "zone-b" {
+			t.Errorf("wrong values %#v", s)
+		}
+		catalog = append(catalog, "zone-c")
+		if err := p.ParseArgs([]string{"--zone", "zone-c"}, false); err != nil {
+			t.Errorf("choices not updated: %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := p.ParseArgs([]string{"--zone", "z1"}, false)
+		e, ok := errors.Cause(err).(*InvalidChoiceError)
+		if !ok {
+			t.Fatalf("expecting InvalidChoiceError, got %v", err)
+		}
+		if len(e.Choices) != 3 || e.Choices[0] != "zone-a" {
+			t.Errorf("wrong choices %v", e.Choices)
+		}
+		err = p.ParseArgs([]string{"--zones", "zone-d"}, false)
+		e, ok = errors.Cause(err).(*InvalidChoiceError)
+		if !ok || len(e.Suggestions) == 0 {
+			t.Errorf("expecting suggestions, got %v", err)
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		got := p.Complete([]string{"--zone", "zone-"})
+		if strings.Join(got, " ") != "zone-a zone-b zone-c" {
+			t.Errorf("wrong candidates %q", got)
+		}
+		var buf bytes.Buffer
+		if err := p.GenerateBashCompletion(&buf); err != nil {
+			t.Fatalf("GenerateBashCompletion: %v", err)
+		}
+		if !strings.Contains(buf.String(), COMPLETE_COMMAND) {
+			t.Errorf("script does not delegate to %s:\n%s", COMPLETE_COMMAND, buf.String())
+		}
+	})
+}
+
+func TestChoicesIgnoreCase(t *testing.T) {
+	s := &struct {
+		Protocol string   `choices:"tcp|udp" choices-ignorecase:"true"`
+		Formats  []string `choices:"json|yaml" choices-ignorecase:"true"`
+		Level    string   `choices:"Debug|Info"`
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--protocol", "TCP", "--formats", "Json", "--formats", "YAML"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if s.Protocol != "tcp" || strings.Join(s.Formats, ",") != "json,yaml" {
+		t.Errorf("values not normalized: %#v", s)
+	}
+	if err := p.ParseArgs([]string{"--level", "debug"}, false); err == nil {
+		t.Errorf("expecting case sensitive level")
+	}
+
+	t.Run("parser", func(t *testing.T) {
+		p, err := NewArgumentParserWithOptions(s, WithChoicesIgnoreCase())
+		if err != nil {
+			t.Fatalf("NewArgumentParserWithOptions: %v", err)
+		}
+		if err := p.ParseArgs([]string{"--level", "INFO"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Level != "Info" {
+			t.Errorf("want Info, got %s", s.Level)
+		}
+		err = p.ParseArgs([]string{"--level", "warn"}, false)
+		if _, ok := errors.Cause(err).(*InvalidChoiceError); !ok {
+			t.Errorf("expecting InvalidChoiceError, got %v", err)
+		}
+	})
+}
+
+type testChoiceLevel int
+
+func TestTypedChoices(t *testing.T) {
+	s := &struct {
+		Port  int             `choices:"80|443|8080"`
+		Ports []uint16        `choices:"80|443"`
+		Ratio *float64        `choices:"0.5|1"`
+		Size  int64           `unit:"bytes" choices:"1M|1G"`
+		Level testChoiceLevel `choices:"1|2|3"`
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--port", "0443", "--ports", "80", "--ports", "443", "--ratio", "0.50", "--size", "1024K", "--level", "2"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if s.Port != 443 || len(s.Ports) != 2 || s.Ports[1] != 443 || s.Ratio == nil || *s.Ratio != 0.5 || s.Size != 1<<20 || s.Level != 2 {
+		t.Errorf("wrong values %#v", s)
+	}
+	for _, args := range [][]string{
+		{"--port", "22"},
+		{"--port", "http"},
+		{"--ports", "8080"},
+		{"--level", "4"},
+	} {
+		err := p.ParseArgs(args, false)
+		if _, ok := errors.Cause(err).(*InvalidChoiceError); !ok {
+			t.Errorf("%v: expecting InvalidChoiceError, got %v", args, err)
+		}
+	}
+
+	t.Run("func", func(t *testing.T) {
+		if err := p.SetChoicesFunc("port", func() []string { return []string{"x", "8443"} }); err != nil {
+			t.Fatalf("SetChoicesFunc: %v", err)
+		}
+		if err := p.ParseArgs([]string{"--port", "08443"}, false); err != nil {
+			t.Errorf("ParseArgs: %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewArgumentParser(&struct {
+			Port int `choices:"80|http"`
+		}{}, "", "", "")
+		if err == nil {
+			t.Errorf("expecting error of invalid choice")
+		}
+	})
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Clone returns an independent parser of the same arguments bound to a copy
+// of the target.  Changes made to the parser after construction, e.g. by
+// SetArgumentDefault, AddArgumentSpec and AddSubParser, are cloned as well,
+// while values parsed so far are not, the arguments of the clone are reset.
+// Subcommands are cloned along with their targets
+func (this *ArgumentParser) Clone() (*ArgumentParser, error) {
+	rv := reflect.ValueOf(this.target).Elem()
+	target := reflect.New(rv.Type())
+	target.Elem().Set(rv)
+	return this.cloneInto(target.Interface())
+}
+
+// cloneInto clones the parser bound to target of the same type
+func (this *ArgumentParser) cloneInto(target interface{}) (*ArgumentParser, error) {
+	parser, err := newArgumentParser(target, this.prog, this.description, this.epilog)
+	if err != nil {
+		return nil, err
+	}
+	fresh := make(map[string]Argument)
+	for _, args := range [][]Argument{parser.posArgs, parser.optArgs} {
+		for _, arg := range args {
+			fresh[arg.Token()] = arg
+		}
+	}
+	parser.lenient = this.lenient
+	parser.choicesIgnoreCase = this.choicesIgnoreCase
+	parser.naming = this.naming
+	parser.abbreviation = this.abbreviation
+	parser.optionsFirst = this.optionsFirst
+	parser.helpCommand = this.helpCommand
+	parser.envPrefix = this.envPrefix
+	if this.completers != nil {
+		parser.completers = make(map[string]func(prefix string) []string, len(this.completers))
+		for name, completer := range this.completers {
+			parser.completers[name] = completer
+		}
+	}
+	parser.warnWriter = this.warnWriter
+	parser.responseFiles = this.responseFiles
+	parser.collectErrors = this.collectErrors
+	parser.noHelpDecorations = this.noHelpDecorations
+	parser.setHelpTemplates(this.usageTemplate, this.helpTemplate)
+	parser.strictConfig = this.strictConfig
+	parser.unknownKeyHandler = this.unknownKeyHandler
+	parser.expandEnv = this.expandEnv
+	parser.output = this.output
+	parser.errorHandling = this.errorHandling
+	parser.noHelp = this.noHelp
+	parser.interactive = this.interactive
+	parser.promptIn, parser.promptOut = this.promptIn, this.promptOut
+	if len(parser.sections) == len(this.sections) {
+		// the pointers of the target copied are those allocated by this
+		for i, section := range parser.sections {
+			section.ofield = this.sections[i].ofield
+			section.field.Set(section.ofield)
+		}
+	}
+	parser.posArgs, err = cloneArguments(this.posArgs, parser, fresh)
+	if err != nil {
+		return nil, err
+	}
+	parser.optArgs, err = cloneArguments(this.optArgs, parser, fresh)
+	if err != nil {
+		return nil, err
+	}
+	parser.invalidateTokens()
+	parser.disabledArgs, err = cloneArguments(this.disabledArgs, parser, fresh)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+// cloneableArgument is implemented by arguments supporting Clone.  value is
+// the field of the cloned target, or invalid for arguments of
+// AddArgumentSpec
+type cloneableArgument interface {
+	cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error)
+}
+
+func cloneArguments(args []Argument, parser *ArgumentParser, fresh map[string]Argument) ([]Argument, error) {
+	clones := make([]Argument, 0, len(args))
+	for _, arg := range args {
+		if harg, ok := arg.(*sHelpArg); ok {
+			clone := *harg
+			clones = append(clones, &clone)
+			continue
+		}
+		carg, ok := arg.(cloneableArgument)
+		if !ok {
+			return nil, fmt.Errorf("Cannot clone argument %s of %T", arg.Token(), arg)
+		}
+		var value reflect.Value
+		if varg, ok := fresh[arg.Token()].(valueArgument); ok {
+			value = varg.getValue()
+		}
+		clone, err := carg.cloneArgument(parser, value)
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, clone)
+	}
+	return clones, nil
+}
+
+func (this *SingleArgument) cloneSingleArgument(parser *ArgumentParser, value reflect.Value) SingleArgument {
+	clone := *this
+	if !value.IsValid() {
+		value = reflect.New(this.value.Type()).Elem()
+	}
+	clone.value = value
+	clone.parser = parser
+	clone.Reset()
+	return clone
+}
+
+func (this *SingleArgument) cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error) {
+	clone := this.cloneSingleArgument(parser, value)
+	return &clone, nil
+}
+
+func (this *MultiArgument) cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error) {
+	clone := *this
+	clone.SingleArgument = this.cloneSingleArgument(parser, value)
+	clone.items, clone.itemBase = nil, 0
+	return &clone, nil
+}
+
+func (this *SubcommandArgument) cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error) {
+	clone := *this
+	clone.SingleArgument = this.cloneSingleArgument(parser, value)
+	clone.subcommands = make(map[string]SubcommandArgumentData, len(this.subcommands))
+	for name, data := range this.subcommands {
+		subparser, err := data.parser.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("subcommand %s: %v", name, err)
+		}
+		data.parser = subparser
+		clone.subcommands[name] = data
+	}
+	clone.aliases = make(map[string]string, len(this.aliases))
+	for alias, name := range this.aliases {
+		clone.aliases[alias] = name
+	}
+	return &clone, nil
+}
+
+// Merge adds the arguments of other to the parser, e.g. options shared by a
+// family of commands.  It fails without changes if any token duplicates.
+// Values of the merged arguments are kept by the target of other, which
+// should not be used for parsing afterwards
+func (this *ArgumentParser) Merge(other *ArgumentParser) error {
+	posArgs := append([]Argument{}, this.posArgs...)
+	optArgs := append([]Argument{}, this.optArgs...)
+	var merged []Argument
+	for _, args := range [][]Argument{other.posArgs, other.optArgs} {
+		for _, arg := range args {
+			if _, ok := arg.(*sHelpArg); ok {
+				continue
+			}
+			if err := this.AddArgument(arg); err != nil {
+				this.posArgs, this.optArgs = posArgs, optArgs
+				this.invalidateTokens()
+				return err
+			}
+			merged = append(merged, arg)
+		}
+	}
+	for _, arg := range other.disabledArgs {
+		if this.findArgumentByToken(arg.Token()) != nil || this.isDisabledToken(arg.Token()) {
+			this.posArgs, this.optArgs = posArgs, optArgs
+			this.invalidateTokens()
+			return fmt.Errorf("%s: Duplicate argument %s", this.targetName(), arg.Token())
+		}
+	}
+	this.disabledArgs = append(this.disabledArgs, other.disabledArgs...)
+	this.sections = append(this.sections, other.sections...)
+	this.structPrefixes = append(this.structPrefixes, other.structPrefixes...)
+	for _, args := range [][]Argument{merged, other.disabledArgs} {
+		for _, arg := range args {
+			if parg, ok := arg.(parserArgument); ok {
+				parg.setParser(this)
+			}
+		}
+	}
+	for name, completer := range other.completers {
+		if _, ok := this.completers[name]; !ok {
+			this.SetCompleter(name, completer)
+		}
+	}
+	return nil
+}
+
+// parserArgument is implemented by arguments referring to their parser
+type parserArgument interface {
+	setParser(parser *ArgumentParser)
+}
+
+func (this *SingleArgument) setParser(parser *ArgumentParser) {
+	this.parser = parser
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	type Options struct {
+		Region string `default:"region-1"`
+		Zones  []string
+		Debug  bool
+
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	type CreateOptions struct {
+		NAME string
+	}
+	s := &Options{Zones: []string{"z0"}}
+	p := mustNewParser(t, s)
+	create := &CreateOptions{}
+	if _, err := p.AddSubParser(create, "create", "create", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := p.SetArgumentDefault("region", "region-2"); err != nil {
+		t.Fatalf("SetArgumentDefault: %v", err)
+	}
+	if err := p.AddArgumentSpec(ArgumentSpec{Token: "plugin"}); err != nil {
+		t.Fatalf("AddArgumentSpec: %v", err)
+	}
+	if err := p.DisableArgument("debug"); err != nil {
+		t.Fatalf("DisableArgument: %v", err)
+	}
+	if err := p.SetVersion("v1.0"); err != nil {
+		t.Fatalf("SetVersion: %v", err)
+	}
+	if err := p.ParseArgs([]string{"--zones", "z1", "--plugin", "p1", "create", "host1"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+
+	c, err := p.Clone()
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	cs := c.Options().(*Options)
+	if cs == s {
+		t.Fatalf("clone shares the target")
+	}
+	if err := c.ParseArgs([]string{"--plugin", "p2", "create", "host2"}, false); err != nil {
+		t.Fatalf("ParseArgs of clone: %v", err)
+	}
+	want := &Options{Region: "region-2", Zones: []string{"z0"}, SUBCOMMAND: "create"}
+	if !reflect.DeepEqual(cs, want) {
+		t.Errorf("clone: want %#v, got %#v", want, cs)
+	}
+	if got := c.GetValue("plugin"); got != "p2" {
+		t.Errorf("clone: want plugin p2, got %v", got)
+	}
+	if got := c.GetSubcommand().GetSubParser().Options().(*CreateOptions).NAME; got != "host2" {
+		t.Errorf("clone: want name host2, got %s", got)
+	}
+	if err := c.ParseArgs([]string{"--debug", "create", "host2"}, false); err == nil {
+		t.Errorf("clone: want error of disabled argument")
+	}
+
+	want = &Options{Region: "region-2", Zones: []string{"z0", "z1"}, SUBCOMMAND: "create"}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("original: want %#v, got %#v", want, s)
+	}
+	if got := p.GetValue("plugin"); got != "p1" {
+		t.Errorf("original: want plugin p1, got %v", got)
+	}
+	if create.NAME != "host1" {
+		t.Errorf("original: want name host1, got %s", create.NAME)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	type Common struct {
+		Debug  bool
+		Region string
+	}
+	type Options struct {
+		Zone string
+	}
+	t.Run("merge", func(t *testing.T) {
+		common := &Common{}
+		cp := mustNewParser(t, common)
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.Merge(cp); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+		if err := p.ParseArgs([]string{"--debug", "--zone", "z1", "--region", "r1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !common.Debug || common.Region != "r1" || s.Zone != "z1" {
+			t.Errorf("unexpected %#v %#v", common, s)
+		}
+		if got := p.Source("region"); got != SOURCE_COMMAND_LINE {
+			t.Errorf("want source command line, got %q", got)
+		}
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		p := mustNewParser(t, &Common{})
+		if err := p.Merge(mustNewParser(t, &Options{})); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+		n := len(p.optArgs)
+		if err := p.Merge(mustNewParser(t, &struct {
+			Verbose bool
+			Zone    string
+		}{})); err == nil {
+			t.Errorf("want error of duplicate zone")
+		}
+		if len(p.optArgs) != n {
+			t.Errorf("failed merge changed arguments")
+		}
+	})
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GenerateBashCompletion writes a bash completion script of the parser to w.
+// The script completes optional tokens, subcommand names and static choices
+// of the parser and its subcommands, while values of SetCompleter and
+// SetChoicesFunc are asked for from the program at runtime.  The generated
+// script is meant to be sourced by bash or installed into the
+// bash-completion directory
+func (this *ArgumentParser) GenerateBashCompletion(w io.Writer) error {
+	prog := strings.Fields(this.prog)
+	if len(prog) == 0 {
+		return fmt.Errorf("Empty prog name")
+	}
+	fn := "_" + bashIdentifier(prog[0]) + "_complete"
+
+	var walk, comp bytes.Buffer
+	this.bashCompletion("", &walk, &comp)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# bash completion for %s\n", prog[0])
+	fmt.Fprintf(&buf, "%s() {\n", fn)
+	buf.WriteString("    local cur prev path w i\n")
+	buf.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	buf.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	buf.WriteString("    path=\"\"\n")
+	buf.WriteString("    for ((i=1; i<COMP_CWORD; i++)); do\n")
+	buf.WriteString("        w=\"${COMP_WORDS[i]}\"\n")
+	buf.WriteString("        case \"$path\" in\n")
+	buf.Write(walk.Bytes())
+	buf.WriteString("        esac\n")
+	buf.WriteString("    done\n")
+	buf.WriteString("    case \"$path\" in\n")
+	buf.Write(comp.Bytes())
+	buf.WriteString("    esac\n")
+	buf.WriteString("}\n")
+	fmt.Fprintf(&buf, "complete -F %s %s\n", fn, prog[0])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// bashCompletion writes the case branches of this parser, identified by
+// path, the space separated canonical subcommand names leading to it
+func (this *ArgumentParser) bashCompletion(path string, walk, comp *bytes.Buffer) {
+	var words []string
+	var choices bytes.Buffer
+	for _, arg := range this.optArgs {
+		if isHiddenArgument(arg) {
+			continue
+		}
+		tokens := argumentTokens(arg)
+		words = append(words, tokens...)
+		if !arg.NeedData() {
+			continue
+		}
+		if cands := argumentChoices(arg); len(cands) > 0 {
+			fmt.Fprintf(&choices, "        %s) COMPREPLY=($(compgen -W %s -- \"$cur\")); return;;\n",
+				strings.Join(tokens, "|"), bashQuote(strings.Join(cands, " ")))
+		}
+	}
+	for _, arg := range this.posArgs {
+		words = append(words, argumentChoices(arg)...)
+	}
+	fmt.Fprintf(comp, "    %s)\n", bashQuote(path))
+	if len(this.completers) > 0 || this.hasChoicesFunc() {
+		// values are only known at runtime, ask the program itself
+		fmt.Fprintf(comp, "        COMPREPLY=($(\"${COMP_WORDS[0]}\" %s \"${COMP_WORDS[@]:1:COMP_CWORD}\" 2>/dev/null))\n", COMPLETE_COMMAND)
+	} else {
+		if choices.Len() > 0 {
+			comp.WriteString("        case \"$prev\" in\n")
+			comp.Write(choices.Bytes())
+			comp.WriteString("        esac\n")
+		}
+		fmt.Fprintf(comp, "        COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", bashQuote(strings.Join(words, " ")))
+	}
+	comp.WriteString("        ;;\n")
+
+	subcmd := this.GetSubcommand()
+	if subcmd == nil {
+		return
+	}
+	fmt.Fprintf(walk, "        %s) case \"$w\" in\n", bashQuote(path))
+	for _, name := range subcmd.subcommandNames() {
+		data := subcmd.subcommands[name]
+		subpath := strings.TrimSpace(path + " " + name)
+		names := append([]string{name}, data.aliases...)
+		fmt.Fprintf(walk, "            %s) path=%s;;\n", strings.Join(names, "|"), bashQuote(subpath))
+	}
+	walk.WriteString("            esac;;\n")
+	for _, name := range subcmd.subcommandNames() {
+		subpath := strings.TrimSpace(path + " " + name)
+		subcmd.subcommands[name].parser.bashCompletion(subpath, walk, comp)
+	}
+}
+
+// argumentTokens returns all command line tokens of an optional argument
+func argumentTokens(arg Argument) []string {
+	tokens := []string{"--" + arg.Token()}
+	for _, alias := range argumentAliasTokens(arg) {
+		tokens = append(tokens, "--"+alias)
+	}
+	if len(arg.ShortToken()) > 0 {
+		tokens = append(tokens, "-"+arg.ShortToken())
+	}
+	if len(arg.NegativeToken()) > 0 {
+		tokens = append(tokens, "--"+arg.NegativeToken())
+	}
+	return tokens
+}
+
+func argumentChoices(arg Argument) []string {
+	if arg.IsSubcommand() {
+		subcmd := arg.(*SubcommandArgument)
+		var names []string
+		for _, name := range subcmd.subcommandNames() {
+			names = append(names, name)
+			names = append(names, subcmd.subcommands[name].aliases...)
+		}
+		return names
+	}
+	if sarg, ok := arg.(interface{ Choices() []string }); ok {
+		return sarg.Choices()
+	}
+	return nil
+}
+
+func bashIdentifier(name string) string {
+	var buf bytes.Buffer
+	for _, c := range name {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			buf.WriteRune(c)
+		} else {
+			buf.WriteByte('_')
+		}
+	}
+	return buf.String()
+}
+
+func bashQuote(str string) string {
+	return "'" + strings.Replace(str, "'", "'\\''", -1) + "'"
+}
+
+// COMPLETE_COMMAND starts the hidden completion mode of ParseArgs2, e.g.
+// "prog __complete server --network ne" prints the candidates of the last
+// word, one per line.  IsHelpSet reports true afterwards so that callers exit
+// as they do for --help
+const COMPLETE_COMMAND = "__complete"
+
+// SetCompleter registers a function which returns candidate values of the
+// argument of token name starting with prefix, e.g. names of instances
+// fetched from the server.  Completers take precedence over static choices
+func (this *ArgumentParser) SetCompleter(name string, completer func(prefix string) []string) error {
+	arg := this.findArgumentByToken(name)
+	if arg == nil {
+		return fmt.Errorf("No such argument %s", name)
+	}
+	if this.completers == nil {
+		this.completers = make(map[string]func(prefix string) []string)
+	}
+	this.completers[kebabToken(arg)] = completer
+	return nil
+}
+
+// Complete returns completion candidates of the last element of args, the
+// word being completed, given the preceding words
+func (this *ArgumentParser) Complete(args []string) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	parser := this
+	posIdx := 0
+	endOfOptions := false
+	var pending Argument
+	words, cur := args[:len(args)-1], args[len(args)-1]
+	for _, word := range words {
+		if pending != nil {
+			pending = nil
+			continue
+		}
+		if !endOfOptions && word == "--" {
+			endOfOptions = true
+			continue
+		}
+		if !endOfOptions && strings.HasPrefix(word, "-") {
+			if strings.Contains(word, "=") {
+				continue
+			}
+			if arg := parser.completionOptionalArgument(word); arg != nil && arg.NeedData() {
+				pending = arg
+			}
+			continue
+		}
+		if posIdx >= len(parser.posArgs) {
+			continue
+		}
+		arg := parser.posArgs[posIdx]
+		if arg.IsSubcommand() {
+			subcmd := arg.(*SubcommandArgument)
+			data, ok := subcmd.subcommands[subcmd.canonical(word)]
+			if !ok {
+				return nil
+			}
+			parser = data.parser
+			posIdx = 0
+			continue
+		}
+		if !arg.IsMulti() || posIdx < len(parser.posArgs)-1 {
+			posIdx++
+		}
+	}
+	if pending != nil {
+		return parser.completeValue(pending, "", cur)
+	}
+	if !endOfOptions && strings.HasPrefix(cur, "-") {
+		if pos := strings.IndexByte(cur, '='); pos > 0 {
+			arg := parser.completionOptionalArgument(cur[:pos])
+			if arg == nil {
+				return nil
+			}
+			return parser.completeValue(arg, cur[:pos+1], cur[pos+1:])
+		}
+		var cands []string
+		for _, arg := range parser.optArgs {
+			if isHiddenArgument(arg) {
+				continue
+			}
+			for _, token := range argumentTokens(arg) {
+				if strings.HasPrefix(token, cur) {
+					cands = append(cands, token)
+				}
+			}
+		}
+		return cands
+	}
+	if posIdx < len(parser.posArgs) {
+		return parser.completeValue(parser.posArgs[posIdx], "", cur)
+	}
+	return nil
+}
+
+func (this *ArgumentParser) completionOptionalArgument(word string) Argument {
+	if !strings.HasPrefix(word, "--") {
+		if arg := this.findShortArgument(word[1:]); arg != nil {
+			return arg
+		}
+	}
+	arg, _ := this.findOptionalArgument(strings.TrimLeft(word, "-"), false)
+	return arg
+}
+
+// completeValue returns candidates of arg starting with prefix, each
+// prepended with lead
+func (this *ArgumentParser) completeValue(arg Argument, lead string, prefix string) []string {
+	var values []string
+	if completer, ok := this.completers[kebabToken(arg)]; ok {
+		values = completer(prefix)
+	} else {
+		values = argumentChoices(arg)
+	}
+	var cands []string
+	for _, val := range values {
+		if strings.HasPrefix(val, prefix) {
+			cands = append(cands, lead+val)
+		}
+	}
+	return cands
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateBashCompletion(t *testing.T) {
+	type CreateOptions struct {
+		NAME   string
+		Flavor string `choices:"small|large"`
+	}
+	s := &struct {
+		Format              string `short-token:"f" choices:"json|yaml"`
+		BoolPtrDefaultFalse *bool
+		SUBCOMMAND          string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	if _, err := subcmd.AddSubParser(&CreateOptions{}, "create", "Create", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := subcmd.AddAliases("create", "new"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.GenerateBashCompletion(&buf); err != nil {
+		t.Fatalf("GenerateBashCompletion: %v", err)
+	}
+	script := buf.String()
+	for _, want := range []string{
+		"complete -F _prog_complete prog\n",
+		"--format -f",
+		"--bool-ptr-default-false",
+		"create new",
+		"--format|-f) COMPREPLY=($(compgen -W 'json yaml' -- \"$cur\")); return;;",
+		"create|new) path='create';;",
+		"--flavor) COMPREPLY=($(compgen -W 'small large' -- \"$cur\")); return;;",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	type CreateOptions struct {
+		NAME    string
+		Network string
+		Flavor  string `choices:"small|large"`
+	}
+	s := &struct {
+		Format     string `short-token:"f" choices:"json|yaml"`
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	create, err := subcmd.AddSubParser(&CreateOptions{}, "create", "Create", nil)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := subcmd.AddAliases("create", "new"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	networks := func(prefix string) []string {
+		return []string{"net1", "net2", "vpc1"}
+	}
+	if err := create.SetCompleter("network", networks); err != nil {
+		t.Fatalf("SetCompleter: %v", err)
+	}
+	if err := create.SetCompleter("name", func(prefix string) []string { return []string{prefix + "-vm", "other"} }); err != nil {
+		t.Fatalf("SetCompleter: %v", err)
+	}
+	if err := create.SetCompleter("nonexist", networks); err == nil {
+		t.Errorf("expecting error for unknown argument")
+	}
+	cases := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{""}, []string{"create", "new"}},
+		{[]string{"--fo"}, []string{"--format"}},
+		{[]string{"-f", "y"}, []string{"yaml"}},
+		{[]string{"--format=j"}, []string{"--format=json"}},
+		{[]string{"new", "--network", "ne"}, []string{"net1", "net2"}},
+		{[]string{"-f", "json", "create", "--flavor", ""}, []string{"small", "large"}},
+		{[]string{"create", "--network", "net1", "x"}, []string{"x-vm"}},
+		{[]string{"create", "--fl"}, []string{"--flavor"}},
+		{[]string{"delete", ""}, nil},
+	}
+	for _, c := range cases {
+		got := p.Complete(c.args)
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("Complete(%q): want %q, got %q", c.args, c.want, got)
+		}
+	}
+	t.Run("complete mode", func(t *testing.T) {
+		if err := p.ParseArgs([]string{COMPLETE_COMMAND, "create", "--network", "v"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !p.IsHelpSet() {
+			t.Errorf("IsHelpSet should be true in completion mode")
+		}
+	})
+	t.Run("script", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := p.GenerateBashCompletion(&buf); err != nil {
+			t.Fatalf("GenerateBashCompletion: %v", err)
+		}
+		if !strings.Contains(buf.String(), `"${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}"`) {
+			t.Errorf("script does not delegate to __complete:\n%s", buf.String())
+		}
+	})
+	t.Run("naming", func(t *testing.T) {
+		urls := func(prefix string) []string { return []string{"http://a"} }
+		for _, before := range []bool{true, false} {
+			p := mustNewParser(t, &struct{ AuthURL string }{})
+			if before {
+				if err := p.SetNamingStrategy(SnakeCase); err != nil {
+					t.Fatalf("SetNamingStrategy: %v", err)
+				}
+			}
+			if err := p.SetCompleter("auth-url", urls); err != nil {
+				t.Fatalf("SetCompleter: %v", err)
+			}
+			if !before {
+				if err := p.SetNamingStrategy(SnakeCase); err != nil {
+					t.Fatalf("SetNamingStrategy: %v", err)
+				}
+			}
+			if got := p.Complete([]string{"--auth_url", ""}); !reflect.DeepEqual(got, []string{"http://a"}) {
+				t.Errorf("naming set before completer %v: got %q", before, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,485 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/jsonutils"
+	"github.com/nyl1001/pkg/utils"
+	"yunion.io/x/log"
+)
+
+// GenerateConfigTemplate writes a sample configuration file of the parser
+// in the format of ParseTornadoFile to w.  Every visible optional argument
+// is documented with its help, default and choices, followed by a commented
+// out assignment of the default value, so the file parses to the defaults
+// as is.  Groups are separated by comment headers
+func (this *ArgumentParser) GenerateConfigTemplate(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Configuration of %s\n", this.prog)
+	if len(this.description) > 0 {
+		buf.WriteString("#\n")
+		writeConfigComment(&buf, this.description)
+	}
+	data := this.helpData()
+	writeConfigOptions(&buf, data.Optionals)
+	for _, group := range data.Groups {
+		fmt.Fprintf(&buf, "\n#\n# %s\n#\n", group.Name)
+		writeConfigOptions(&buf, group.Arguments)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeConfigOptions(buf *bytes.Buffer, args []Argument) {
+	for _, arg := range args {
+		// --help and --version, which cannot be configured
+		darg, ok := arg.(documentedArgument)
+		if !ok {
+			continue
+		}
+		buf.WriteByte('\n')
+		if help := darg.plainHelp(); len(help) > 0 {
+			writeConfigComment(buf, help)
+		}
+		defval := darg.defaultString()
+		if len(defval) > 0 {
+			fmt.Fprintf(buf, "# default: %s\n", defval)
+		}
+		if choices := argumentChoices(arg); len(choices) > 0 {
+			fmt.Fprintf(buf, "# choices: %s\n", strings.Join(choices, "|"))
+		}
+		if isSecretArgument(arg) {
+			// leave the secret to be filled in
+			defval = ""
+		}
+		if arg.IsMulti() && len(defval) > 0 {
+			defval = "[" + strings.Join(utils.FindWords([]byte(defval), 0), ", ") + "]"
+		}
+		fmt.Fprintf(buf, "%s\n", strings.TrimRight(fmt.Sprintf("#%s = %s", configKey(arg), defval), " "))
+	}
+}
+
+func writeConfigComment(buf *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if len(line) == 0 {
+			buf.WriteString("#\n")
+			continue
+		}
+		fmt.Fprintf(buf, "# %s\n", line)
+	}
+}
+
+// configKey returns the key of arg in configuration files, the snake_case
+// form of its token
+func configKey(arg Argument) string {
+	return strings.Replace(arg.Token(), "-", "_", -1)
+}
+
+// WriteConfig writes the current values of optional arguments to w in the
+// format of ParseTornadoFile, so that parsing the output restores them.
+// With explicitOnly, only the arguments given explicitly are written,
+// otherwise all but nil and empty values are, as with JSONDict.  Keys of
+// secret arguments are written commented out without values, to be filled
+// in by hand, see WriteConfigUnredacted
+func (this *ArgumentParser) WriteConfig(w io.Writer, explicitOnly bool) error {
+	return this.writeConfig(w, explicitOnly, true)
+}
+
+// WriteConfigUnredacted writes values as WriteConfig does, but with the real
+// values of secret arguments, e.g. to persist credentials entered.  The
+// output must not be logged
+func (this *ArgumentParser) WriteConfigUnredacted(w io.Writer, explicitOnly bool) error {
+	return this.writeConfig(w, explicitOnly, false)
+}
+
+func (this *ArgumentParser) writeConfig(w io.Writer, explicitOnly bool, redact bool) error {
+	var buf bytes.Buffer
+	for _, arg := range this.optArgs {
+		if explicitOnly && !arg.IsSet() {
+			continue
+		}
+		varg, ok := arg.(valueArgument)
+		if !ok {
+			continue
+		}
+		values, ok := configValues(arg, varg.getValue())
+		if !ok {
+			continue
+		}
+		if redact && isSecretArgument(arg) {
+			fmt.Fprintf(&buf, "#%s =\n", configKey(arg))
+			continue
+		}
+		for i, val := range values {
+			values[i] = configQuote(val)
+		}
+		if arg.IsMulti() {
+			fmt.Fprintf(&buf, "%s = [%s]\n", configKey(arg), strings.Join(values, ", "))
+		} else {
+			fmt.Fprintf(&buf, "%s = %s\n", configKey(arg), values[0])
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// SaveConfigFile writes the current values of optional arguments to the
+// file of path as WriteConfig does.  The file is replaced by renaming a
+// temporary file written in the same directory, only when all values are
+// written successfully.  The mode of the file replaced is kept, new files
+// are created with mode 0600 as they may hold secrets
+func (this *ArgumentParser) SaveConfigFile(path string, explicitOnly bool) error {
+	return this.saveConfigFile(path, explicitOnly, true)
+}
+
+// SaveConfigFileUnredacted writes the file of path as SaveConfigFile does,
+// with the real values of secret arguments as WriteConfigUnredacted does
+func (this *ArgumentParser) SaveConfigFileUnredacted(path string, explicitOnly bool) error {
+	return this.saveConfigFile(path, explicitOnly, false)
+}
+
+func (this *ArgumentParser) saveConfigFile(path string, explicitOnly bool, redact bool) error {
+	var buf bytes.Buffer
+	if err := this.writeConfig(&buf, explicitOnly, redact); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("write file %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file of path with data, keeping its mode
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// configValues returns the string forms of value accepted by SetValue of
+// arg, one per element for slices and maps.  It returns false for nil and
+// empty values
+func configValues(arg Argument, value reflect.Value) ([]string, bool) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil, false
+		}
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return nil, false
+		}
+	}
+	if value.Kind() == reflect.Map {
+		separator := "="
+		if marg, ok := arg.(*MultiArgument); ok {
+			separator = marg.separator
+		}
+		var values []string
+		for _, key := range value.MapKeys() {
+			values = append(values, exportString(key)+separator+exportString(value.MapIndex(key)))
+		}
+		sort.Strings(values)
+		return values, true
+	}
+	obj := exportArgumentValue(arg, value)
+	if obj, ok := obj.(*jsonutils.JSONArray); ok {
+		elems, _ := obj.GetArray()
+		var values []string
+		for _, elem := range elems {
+			str, _ := elem.GetString()
+			values = append(values, str)
+		}
+		return values, true
+	}
+	str, _ := obj.GetString()
+	return []string{str}, true
+}
+
+// exportString returns value in the string form of exportValue
+func exportString(value reflect.Value) string {
+	str, _ := exportValue(value).GetString()
+	return str
+}
+
+// configQuote quotes str for config files unless it consists of characters
+// never special to the parser
+func configQuote(str string) string {
+	safe := len(str) > 0
+	for _, c := range str {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.ContainsRune("-_./@+=%~", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return str
+	}
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r", "\t", "\\t")
+	return "\"" + replacer.Replace(str) + "\""
+}
+
+// maxIncludeDepth limits nested include directives of .conf files
+const maxIncludeDepth = 16
+
+// includeDirective returns the pattern of "include PATTERN" lines
+func includeDirective(line string) (string, bool) {
+	const directive = "include"
+	if !strings.HasPrefix(line, directive) {
+		return "", false
+	}
+	rest := line[len(directive):]
+	if len(rest) == 0 || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) == 0 || rest[0] == '=' {
+		// key named include
+		return "", false
+	}
+	return utils.Unquote(rest), true
+}
+
+func (this *ArgumentParser) parseConfFile(path string) error {
+	for _, file := range this.confFiles {
+		if file == path {
+			return fmt.Errorf("include %s: recursive include", path)
+		}
+	}
+	if len(this.confFiles) >= maxIncludeDepth {
+		return fmt.Errorf("include %s: too many levels of includes", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	this.confFiles = append(this.confFiles, path)
+	defer func() {
+		this.confFiles = this.confFiles[:len(this.confFiles)-1]
+	}()
+	if this.confSources != nil {
+		this.confSources[path] = true
+	}
+	defer this.setSource(path)()
+	return this.parseReader(file)
+}
+
+// parseInclude parses files matching pattern in lexical order.  A pattern
+// without meta characters must match an existing file
+func (this *ArgumentParser) parseInclude(pattern string) error {
+	if !filepath.IsAbs(pattern) && len(this.confFiles) > 0 {
+		pattern = filepath.Join(filepath.Dir(this.confFiles[len(this.confFiles)-1]), pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("include %s: %v", pattern, err)
+	}
+	if len(paths) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		return fmt.Errorf("include %s: no such file", pattern)
+	}
+	for _, path := range paths {
+		if err := this.parseConfFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStrictConfig controls whether keys of configuration files matching no
+// argument are errors instead of being ignored with a warning.  It applies
+// to all formats, while JSON files are strict unless the parser is lenient
+func (this *ArgumentParser) SetStrictConfig(strict bool) {
+	this.strictConfig = strict
+}
+
+// SetUnknownKeyHandler sets the function called with the source, e.g. path
+// of the file, and the key of configuration matching no argument, in place
+// of the warning or the error of strict mode.  An error returned by the
+// handler fails the parsing, nil ignores the key
+func (this *ArgumentParser) SetUnknownKeyHandler(handler func(source, key string) error) {
+	this.unknownKeyHandler = handler
+}
+
+// unknownConfigKey reports key of configuration matching no argument.  Keys
+// of arguments of DisableArgument are ignored
+func (this *ArgumentParser) unknownConfigKey(key string, strict bool) error {
+	if this.isDisabledToken(this.keyToken(key)) {
+		return nil
+	}
+	if this.unknownKeyHandler != nil {
+		return this.unknownKeyHandler(this.source, key)
+	}
+	if strict || this.strictConfig {
+		err := &UnknownArgumentError{Argument: key, Suggestions: this.suggestTokens(key)}
+		if len(this.source) > 0 {
+			return errors.Wrap(err, this.source)
+		}
+		return err
+	}
+	log.Warningf("Cannot find argument %s", key)
+	return nil
+}
+
+// SetConfigEnvExpansion controls whether references to environment
+// variables in values of configuration files, ${VAR} and $VAR, are replaced
+// with their values, empty if unset.  $$ stands for a literal $.  Keys and
+// updates by UpdateJSONDict are never expanded
+func (this *ArgumentParser) SetConfigEnvExpansion(expand bool) {
+	this.expandEnv = expand
+}
+
+func (this *ArgumentParser) expandConfigEnv(str string) string {
+	if !this.expandEnv {
+		return str
+	}
+	return expandEnv(str)
+}
+
+// expandEnv replaces ${VAR} and $VAR in str with values of the environment
+// variables, $$ with $
+func expandEnv(str string) string {
+	return os.Expand(str, func(name string) string {
+		if len(name) == 1 && !isEnvNameChar(name[0]) {
+			// $$ escapes $, other special shell parameters, e.g. $1,
+			// are kept as is
+			if name == "$" {
+				return "$"
+			}
+			return "$" + name
+		}
+		return os.Getenv(name)
+	})
+}
+
+func isEnvNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// defaultCopier is implemented by arguments whose default values can be
+// appended to
+type defaultCopier interface {
+	copyDefault()
+}
+
+// copyDefault sets the value, not set yet, to a copy of the default, so
+// that appending to it leaves the default intact.  Values without default
+// are appended to as they are
+func (this *SingleArgument) copyDefault() {
+	if this.isSet || this.started {
+		return
+	}
+	this.started = true
+	if !this.useDefault {
+		return
+	}
+	switch this.defValue.Kind() {
+	case reflect.Slice:
+		value := reflect.MakeSlice(this.defValue.Type(), 0, this.defValue.Len())
+		this.value.Set(reflect.AppendSlice(value, this.defValue))
+	case reflect.Map:
+		value := reflect.MakeMap(this.defValue.Type())
+		for _, key := range this.defValue.MapKeys() {
+			value.SetMapIndex(key, this.defValue.MapIndex(key))
+		}
+		this.value.Set(value)
+	}
+}
+
+// splitConfigList splits list of configuration by commas and spaces.
+// Elements may be quoted by " or ', in which \n, \r, \t and the escaped
+// characters are unescaped
+func splitConfigList(str string) ([]string, error) {
+	var values []string
+	for i := 0; i < len(str); {
+		c := str[i]
+		if c == ',' || c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			i++
+			continue
+		}
+		var buf bytes.Buffer
+		if c != '"' && c != '\'' {
+			for i < len(str) && strings.IndexByte(", \t\r\n", str[i]) < 0 {
+				buf.WriteByte(str[i])
+				i++
+			}
+			values = append(values, buf.String())
+			continue
+		}
+		quote := c
+		closed := false
+		for i++; i < len(str); i++ {
+			c := str[i]
+			if c == quote {
+				closed = true
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(str) {
+				i++
+				switch str[i] {
+				case 'n':
+					buf.WriteByte('\n')
+				case 'r':
+					buf.WriteByte('\r')
+				case 't':
+					buf.WriteByte('\t')
+				default:
+					buf.WriteByte(str[i])
+				}
+				continue
+			}
+			buf.WriteByte(c)
+		}
+		if !closed {
+			return nil, fmt.Errorf("unterminated quoted string %s", str)
+		}
+		values = append(values, buf.String())
+	}
+	return values, nil
+}
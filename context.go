@@ -0,0 +1,85 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"context"
+)
+
+// ParseArgsContext parses args as ParseArgs does, with ctx being the context
+// of the functions called by the parse, e.g. those of SetDefaultFuncContext
+// and SetChoicesFuncContext fetching from remote services, and ValidateContext
+// of targets implementing ContextValidator.  The parse stops
+// with the error of ctx once it is done, including when waiting for input
+// of prompts
+func (this *ArgumentParser) ParseArgsContext(ctx context.Context, args []string) error {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+	this.setContext(ctx)
+	defer this.setContext(nil)
+	return this.handleError(this.parseArgs2(args, false, true))
+}
+
+// Context returns the context of the parse in progress of ParseArgsContext,
+// context.Background() otherwise
+func (this *ArgumentParser) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
+// setContext sets the context of the parser and the parsers of subcommands
+func (this *ArgumentParser) setContext(ctx context.Context) {
+	this.ctx = ctx
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.setContext(ctx)
+		}
+	}
+}
+
+// context returns the context of the parse of the parser of the argument
+func (this *SingleArgument) context() context.Context {
+	if this.parser == nil {
+		return context.Background()
+	}
+	return this.parser.Context()
+}
+
+// readPromptLine reads a line of prompt input by read unless ctx is done
+// first, in which case the pending read is abandoned along with the reader
+func (this *ArgumentParser) readPromptLine(read func() (string, error)) (string, error) {
+	ctx := this.Context()
+	if ctx.Done() == nil {
+		return read()
+	}
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := read()
+		ch <- result{line, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.line, r.err
+	case <-ctx.Done():
+		this.promptBuf = nil
+		return "", ctx.Err()
+	}
+}
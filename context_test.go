@@ -0,0 +1,139 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nyl1001/pkg/errors"
+)
+
+type testContextKey struct{}
+
+func TestParseArgsContext(t *testing.T) {
+	type CreateOptions struct {
+		Zone   string
+		Flavor string
+	}
+	type Options struct {
+		Region     string
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	newContextParser := func(t *testing.T) (*ArgumentParser, *Options, *CreateOptions) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		create := &CreateOptions{}
+		subparser, err := p.GetSubcommand().AddSubParser(create, "create", "create", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if err := p.SetDefaultFuncContext("region", func(ctx context.Context) (string, error) {
+			region, _ := ctx.Value(testContextKey{}).(string)
+			return region, nil
+		}); err != nil {
+			t.Fatalf("SetDefaultFuncContext: %v", err)
+		}
+		if err := subparser.SetChoicesFuncContext("flavor", func(ctx context.Context) []string {
+			if ctx.Value(testContextKey{}) == nil {
+				return []string{"small"}
+			}
+			return []string{"large"}
+		}); err != nil {
+			t.Fatalf("SetChoicesFuncContext: %v", err)
+		}
+		return p, s, create
+	}
+
+	t.Run("values", func(t *testing.T) {
+		p, s, create := newContextParser(t)
+		ctx := context.WithValue(context.Background(), testContextKey{}, "region-1")
+		if err := p.ParseArgsContext(ctx, []string{"create", "--flavor", "large"}); err != nil {
+			t.Fatalf("ParseArgsContext: %v", err)
+		}
+		if s.Region != "region-1" || create.Flavor != "large" {
+			t.Errorf("unexpected options %#v %#v", s, create)
+		}
+		if p.Context() != context.Background() {
+			t.Errorf("context is left after parse")
+		}
+		if err := p.ParseArgs([]string{"create", "--flavor", "large"}, false); err == nil {
+			t.Errorf("expecting choices of background context")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		p, _, _ := newContextParser(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := p.ParseArgsContext(ctx, []string{"create"})
+		if errors.Cause(err) != context.Canceled {
+			t.Errorf("want context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		type Options struct {
+			Name string `required:"true"`
+		}
+		type SecretOptions struct {
+			Password string `prompt:"password"`
+		}
+		for _, target := range []interface{}{&Options{}, &SecretOptions{}} {
+			p := mustNewParser(t, target)
+			p.SetInteractive(true)
+			in, w := io.Pipe()
+			defer w.Close()
+			p.promptIn = in
+			p.promptOut = &bytes.Buffer{}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := p.ParseArgsContext(ctx, nil)
+			if errors.Cause(err) != context.DeadlineExceeded {
+				t.Errorf("%T: want context.DeadlineExceeded, got %v", target, err)
+			}
+		}
+	})
+
+	t.Run("validator", func(t *testing.T) {
+		s := &testContextValidator{}
+		p := mustNewParser(t, s)
+		ctx := context.WithValue(context.Background(), testContextKey{}, "region-1")
+		if err := p.ParseArgsContext(ctx, nil); err != nil {
+			t.Fatalf("ParseArgsContext: %v", err)
+		}
+		if s.region != "region-1" {
+			t.Errorf("ValidateContext is not called with the context")
+		}
+	})
+}
+
+type testContextValidator struct {
+	Region string
+	region string
+}
+
+func (o *testContextValidator) Validate() error {
+	return fmt.Errorf("Validate is called instead of ValidateContext")
+}
+
+func (o *testContextValidator) ValidateContext(ctx context.Context) error {
+	o.region, _ = ctx.Value(testContextKey{}).(string)
+	return nil
+}
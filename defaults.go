@@ -0,0 +1,161 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SetDefaultFunc registers a function computing the default value of the
+// argument of token name, e.g. from the hostname or instance metadata.  It
+// is called by SetDefault and ApplyDefaults only if the argument is not
+// given, at most once, and takes precedence over the default tag.  The
+// returned string is parsed as the default tag is
+func (this *ArgumentParser) SetDefaultFunc(name string, fn func() (string, error)) error {
+	return this.SetDefaultFuncContext(name, func(ctx context.Context) (string, error) {
+		return fn()
+	})
+}
+
+// SetDefaultFuncContext is SetDefaultFunc of fn taking the context of the
+// parse, see ParseArgsContext
+func (this *ArgumentParser) SetDefaultFuncContext(name string, fn func(ctx context.Context) (string, error)) error {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	if arg == nil {
+		return fmt.Errorf("No such argument %s", name)
+	}
+	darg, ok := arg.(defaultFuncArgument)
+	if !ok {
+		return fmt.Errorf("Argument %s has no default value", name)
+	}
+	darg.setDefaultFunc(fn)
+	return nil
+}
+
+// SetArgumentDefault changes the default value of the argument of token
+// name, e.g. for programs sharing option structs with different defaults.
+// It must be called before parsing.  value is either a string parsed as the
+// default tag is, or a value of the type of the argument
+func (this *ArgumentParser) SetArgumentDefault(name string, value interface{}) error {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	if arg == nil {
+		return fmt.Errorf("No such argument %s", name)
+	}
+	darg, ok := arg.(defaultArgument)
+	if !ok || arg.IsSubcommand() {
+		return fmt.Errorf("Argument %s has no default value", name)
+	}
+	return darg.setDefault(value)
+}
+
+// defaultArgument is implemented by arguments supporting SetArgumentDefault
+type defaultArgument interface {
+	setDefault(value interface{}) error
+}
+
+func (this *SingleArgument) setDefault(value interface{}) error {
+	if this.positional {
+		return fmt.Errorf("positional %s must not have default value", this.Token())
+	}
+	if this.required {
+		return fmt.Errorf("non-positional argument with default value should not have required:true set")
+	}
+	tp := this.value.Type()
+	var rv reflect.Value
+	var str string
+	if val, ok := value.(string); ok {
+		var err error
+		rv, err = this.parseValue(val, tp)
+		if err != nil {
+			return fmt.Errorf("default of %s: %v", this.Token(), err)
+		}
+		str = val
+	} else {
+		rv = reflect.ValueOf(value)
+		// numbers convert to strings as runes, thus only strings do
+		if !rv.IsValid() || !rv.Type().ConvertibleTo(tp) || (rv.Kind() == reflect.String) != (tp.Kind() == reflect.String) {
+			return fmt.Errorf("default of %s: expecting %s, got %T", this.Token(), tp, value)
+		}
+		rv = rv.Convert(tp)
+		if values, ok := configValues(this, rv); ok {
+			str = strings.Join(values, " ")
+		}
+	}
+	this.defValue = rv
+	this.defaultStr = str
+	this.useDefault = true
+	return nil
+}
+
+// defaultFuncArgument is implemented by arguments supporting SetDefaultFunc
+type defaultFuncArgument interface {
+	setDefaultFunc(fn func(ctx context.Context) (string, error))
+	resolveDefaultFunc() error
+}
+
+func (this *SingleArgument) setDefaultFunc(fn func(ctx context.Context) (string, error)) {
+	this.defaultFunc = fn
+}
+
+// resolveDefaultFunc calls defaultFunc once if the argument is not set,
+// making its result the default value
+func (this *SingleArgument) resolveDefaultFunc() error {
+	if this.defaultFunc == nil || this.isSet {
+		return nil
+	}
+	ctx := this.context()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fn := this.defaultFunc
+	this.defaultFunc = nil
+	str, err := fn(ctx)
+	if err != nil {
+		return fmt.Errorf("default of %s: %v", this.Token(), err)
+	}
+	value, err := this.parseValue(str, this.value.Type())
+	if err != nil {
+		return fmt.Errorf("default of %s: %v", this.Token(), err)
+	}
+	this.defValue = value
+	this.useDefault = true
+	return nil
+}
+
+// resolveDefaultFuncs resolves defaults of the functions registered by
+// SetDefaultFunc of the parser and the chosen subcommand
+func (this *ArgumentParser) resolveDefaultFuncs() error {
+	var errs []error
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if darg, ok := arg.(defaultFuncArgument); ok {
+				if err := darg.resolveDefaultFunc(); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		if subparser := subcmd.GetSubParser(); subparser != nil {
+			if err := subparser.resolveDefaultFuncs(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return joinErrors(errs)
+}
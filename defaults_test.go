@@ -0,0 +1,179 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetDefaultFunc(t *testing.T) {
+	type Options struct {
+		Region string   `default:"static"`
+		Zones  []string `required:"true"`
+	}
+	newParser := func(t *testing.T, region func() (string, error)) (*ArgumentParser, *Options) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.SetDefaultFunc("Region", region); err != nil {
+			t.Fatalf("SetDefaultFunc: %v", err)
+		}
+		if err := p.SetDefaultFunc("zones", func() (string, error) { return "z1,z2", nil }); err != nil {
+			t.Fatalf("SetDefaultFunc: %v", err)
+		}
+		return p, s
+	}
+
+	t.Run("computed", func(t *testing.T) {
+		calls := 0
+		p, s := newParser(t, func() (string, error) {
+			calls++
+			return "region-host", nil
+		})
+		if err := p.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		p.SetDefault()
+		want := &Options{Region: "region-host", Zones: []string{"z1", "z2"}}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+		if calls != 1 {
+			t.Errorf("want 1 call, got %d", calls)
+		}
+		if got := p.Source("region"); got != SOURCE_DEFAULT {
+			t.Errorf("want source default, got %s", got)
+		}
+	})
+	t.Run("given", func(t *testing.T) {
+		p, s := newParser(t, func() (string, error) {
+			t.Errorf("unexpected call")
+			return "", nil
+		})
+		if err := p.ParseArgs([]string{"--region", "region-cli"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Region != "region-cli" {
+			t.Errorf("want region-cli, got %s", s.Region)
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		p, s := newParser(t, func() (string, error) {
+			return "", fmt.Errorf("metadata unavailable")
+		})
+		err := p.ParseArgs(nil, false)
+		if err == nil || !strings.Contains(err.Error(), "default of region: metadata unavailable") {
+			t.Errorf("unexpected error %v", err)
+		}
+		if s.Region != "static" {
+			t.Errorf("want fallback to default tag, got %s", s.Region)
+		}
+		p, _ = newParser(t, func() (string, error) {
+			return "", fmt.Errorf("metadata unavailable")
+		})
+		if err := p.ParseArgs2(nil, false, false); err != nil {
+			t.Fatalf("ParseArgs2: %v", err)
+		}
+		if err := p.ApplyDefaults(); err == nil || !strings.Contains(err.Error(), "metadata unavailable") {
+			t.Errorf("ApplyDefaults: unexpected error %v", err)
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		if err := p.SetDefaultFunc("zone", nil); err == nil {
+			t.Errorf("want error")
+		}
+	})
+	t.Run("subcommand", func(t *testing.T) {
+		p := mustNewParser(t, &struct {
+			SUBCOMMAND string `subcommand:"true"`
+		}{})
+		sub := &struct {
+			Name string
+		}{}
+		subp, err := p.AddSubParser(sub, "create", "create", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if err := subp.SetDefaultFunc("name", func() (string, error) { return "host1", nil }); err != nil {
+			t.Fatalf("SetDefaultFunc: %v", err)
+		}
+		if err := p.ParseArgs([]string{"create"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if sub.Name != "host1" {
+			t.Errorf("want host1, got %s", sub.Name)
+		}
+	})
+}
+
+func TestSetArgumentDefault(t *testing.T) {
+	type Options struct {
+		Region  string `default:"static"`
+		Port    int    `default:"8080"`
+		Zones   []string
+		Timeout time.Duration
+		Owner   string `required:"true"`
+		Size    int64
+		NAME    string
+	}
+	t.Run("override", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		for name, value := range map[string]interface{}{
+			"region":  "region-1",
+			"Port":    9090,
+			"zones":   []string{"z1", "z2"},
+			"timeout": "3s",
+			"size":    1024,
+		} {
+			if err := p.SetArgumentDefault(name, value); err != nil {
+				t.Fatalf("SetArgumentDefault %s: %v", name, err)
+			}
+		}
+		if err := p.ParseArgs([]string{"--owner", "admin", "--port", "1", "n"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		want := &Options{Region: "region-1", Port: 1, Zones: []string{"z1", "z2"}, Timeout: 3 * time.Second, Owner: "admin", Size: 1024, NAME: "n"}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+		help := p.HelpString()
+		for _, str := range []string{"(default: region-1)", "(default: 9090)", "(default: z1 z2)"} {
+			if !strings.Contains(help, str) {
+				t.Errorf("help without %q:\n%s", str, help)
+			}
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		for name, value := range map[string]interface{}{
+			"zone":    "z1",
+			"port":    "http",
+			"region":  1,
+			"timeout": nil,
+			"owner":   "admin",
+			"name":    "n",
+			"size":    true,
+		} {
+			if err := p.SetArgumentDefault(name, value); err == nil {
+				t.Errorf("%s: want error", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// parseEnum parses the enum tag of the form name=value|name=value into
+// the choices and their values
+func (this *SingleArgument) parseEnum(enum string) error {
+	tp := this.choiceType()
+	if tp.Kind() < reflect.Int || tp.Kind() > reflect.Uint64 {
+		return fmt.Errorf("enum is applicable to integer option ONLY")
+	}
+	var names []string
+	var values []reflect.Value
+	for _, item := range strings.Split(enum, "|") {
+		pos := strings.IndexByte(item, '=')
+		if pos <= 0 {
+			return fmt.Errorf("invalid item %q, expecting name=value", item)
+		}
+		name := item[:pos]
+		for _, n := range names {
+			if n == name {
+				return fmt.Errorf("duplicate name %s", name)
+			}
+		}
+		rv, err := this.parseValue(item[pos+1:], tp)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+		names = append(names, name)
+		values = append(values, rv)
+	}
+	this.choices = names
+	this.choiceValues = values
+	this.enum = true
+	return nil
+}
+
+// enumArgument is implemented by arguments supporting TAG_ENUM
+type enumArgument interface {
+	isEnum() bool
+	exportEnum(value reflect.Value) jsonutils.JSONObject
+}
+
+func (this *SingleArgument) isEnum() bool {
+	return this.enum
+}
+
+func isEnumArgument(arg Argument) bool {
+	earg, ok := arg.(enumArgument)
+	return ok && earg.isEnum()
+}
+
+// exportEnum converts value into JSONObject by the names of the enum.
+// Values without a name are kept as they are
+func (this *SingleArgument) exportEnum(value reflect.Value) jsonutils.JSONObject {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return jsonutils.JSONNull
+		}
+		return this.exportEnum(value.Elem())
+	case reflect.Slice:
+		array := jsonutils.NewArray()
+		for i := 0; i < value.Len(); i++ {
+			array.Add(this.exportEnum(value.Index(i)))
+		}
+		return array
+	}
+	for i, cv := range this.choiceValues {
+		if cv.Interface() == value.Interface() {
+			return jsonutils.NewString(this.choices[i])
+		}
+	}
+	return exportValue(value)
+}
+
+// exportArgumentValue converts value of arg into JSONObject as
+// exportValue does, values of enum by their names
+func exportArgumentValue(arg Argument, value reflect.Value) jsonutils.JSONObject {
+	if earg, ok := arg.(enumArgument); ok && earg.isEnum() {
+		return earg.exportEnum(value)
+	}
+	return exportValue(value)
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+func TestEnum(t *testing.T) {
+	type Options struct {
+		Level  int     `enum:"low=1|medium=5|high=10" default:"medium" help:"level"`
+		Levels []uint8 `enum:"low=1|high=10"`
+		Max    *int64  `enum:"low=1|high=10"`
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--levels", "high", "--levels", "1", "--max", "high"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Level != 5 || len(s.Levels) != 2 || s.Levels[0] != 10 || s.Levels[1] != 1 || s.Max == nil || *s.Max != 10 {
+			t.Errorf("wrong values %#v", s)
+		}
+		err := p.ParseArgs([]string{"--level", "hihg"}, false)
+		e, ok := errors.Cause(err).(*InvalidChoiceError)
+		if !ok || len(e.Suggestions) == 0 || e.Suggestions[0] != "high" {
+			t.Errorf("expecting suggestion high, got %v", err)
+		}
+		if err := p.ParseArgs([]string{"--level", "7"}, false); err == nil {
+			t.Errorf("expecting error of value without name")
+		}
+		if help := p.HelpString(); !strings.Contains(help, "level (default: medium) (choices: low|medium|high)") {
+			t.Errorf("wrong help:\n%s", help)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--level", "10", "--levels", "low"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		dict := p.JSONDict(true)
+		if level, _ := dict.GetString("level"); level != "high" {
+			t.Errorf("want level high, got %s", dict)
+		}
+		if levels, _ := dict.GetArray("levels"); len(levels) != 1 || levels[0].String() != `"low"` {
+			t.Errorf("want levels [low], got %s", dict)
+		}
+		var buf bytes.Buffer
+		if err := p.WriteConfig(&buf, true); err != nil {
+			t.Fatalf("WriteConfig: %v", err)
+		}
+		if !strings.Contains(buf.String(), "level = high") {
+			t.Errorf("wrong config:\n%s", buf.String())
+		}
+		o := &Options{}
+		p = mustNewParser(t, o)
+		if err := p.parseJSONDict(dict); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		if o.Level != 10 || len(o.Levels) != 1 || o.Levels[0] != 1 {
+			t.Errorf("wrong values parsed back %#v", o)
+		}
+		p = mustNewParser(t, o)
+		if err := p.parseJSONDict(jsonutils.Marshal(map[string]int{"level": 1}).(*jsonutils.JSONDict)); err != nil || o.Level != 1 {
+			t.Errorf("expecting value of level accepted, got %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, target := range []interface{}{
+			&struct {
+				Level string `enum:"low=1"`
+			}{},
+			&struct {
+				Level int `enum:"low=1|high"`
+			}{},
+			&struct {
+				Level int `enum:"low=1|low=2"`
+			}{},
+			&struct {
+				Level int `enum:"low=one"`
+			}{},
+			&struct {
+				Level int `enum:"low=1" choices:"low"`
+			}{},
+		} {
+			if _, err := NewArgumentParser(target, "", "", ""); err == nil {
+				t.Errorf("%T: expecting error", target)
+			}
+		}
+	})
+}
@@ -16,8 +16,291 @@ package structarg
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/nyl1001/pkg/errors"
 )
 
+// UnknownArgumentError reports an option, a positional value or a key of
+// configuration matching no argument
+type UnknownArgumentError struct {
+	// Argument is the option, value or key as given, e.g. --zone
+	Argument string
+	// Positional is set for an unexpected positional value
+	Positional bool
+	// Suggestions are the tokens similar to Argument
+	Suggestions []string
+}
+
+func (e *UnknownArgumentError) Error() string {
+	var msg string
+	switch {
+	case e.Positional:
+		msg = fmt.Sprintf("Unknown positional argument %s", e.Argument)
+	case strings.HasPrefix(e.Argument, "-"):
+		msg = fmt.Sprintf("Unknown optional argument %s", e.Argument)
+	default:
+		msg = fmt.Sprintf("Unknown argument %s", e.Argument)
+	}
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", ChoicesString(e.Suggestions))
+	}
+	return msg
+}
+
+// MissingRequiredError reports a required argument not set
+type MissingRequiredError struct {
+	// Argument is the token of the argument
+	Argument string
+}
+
+func (e *MissingRequiredError) Error() string {
+	return fmt.Sprintf("Non-optional argument %s not set", e.Argument)
+}
+
+// AmbiguousArgumentError reports an option abbreviating tokens of more than
+// one argument, see ABBREV_UNAMBIGUOUS
+type AmbiguousArgumentError struct {
+	// Argument is the option as given, e.g. --bool
+	Argument string
+	// Candidates are the tokens abbreviated
+	Candidates []string
+}
+
+func (e *AmbiguousArgumentError) Error() string {
+	return fmt.Sprintf("Ambiguous optional argument %s, could be %s", e.Argument, ChoicesString(e.Candidates))
+}
+
+// InvalidChoiceError reports a value out of the choices of an argument
+type InvalidChoiceError struct {
+	// Argument is the token of the argument
+	Argument string
+	Value    string
+	Choices  []string
+	// Suggestions are the choices similar to Value
+	Suggestions []string
+}
+
+func (e *InvalidChoiceError) Error() string {
+	msg := fmt.Sprintf("Unknown argument '%s' for %s", e.Value, e.Argument)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", quotedChoicesString(e.Suggestions))
+	} else if len(e.Choices) > 0 {
+		msg += fmt.Sprintf(", accepts %s", quotedChoicesString(e.Choices))
+	}
+	return msg
+}
+
+// ConversionError reports a value which cannot be converted into the type
+// of an argument
+type ConversionError struct {
+	// Argument is the token of the argument
+	Argument string
+	Value    string
+	Type     reflect.Type
+	Err      error
+}
+
+func (e *ConversionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConversionError) Cause() error {
+	return e.Err
+}
+
+// maxSuggestions limits the similar tokens suggested by errors
+const maxSuggestions = 3
+
+// suggestTokens returns the long tokens of the optional arguments similar to
+// token, which is an option with leading dashes or a key of configuration
+// without, and the suggestions are of the same form
+func (this *ArgumentParser) suggestTokens(token string) []string {
+	name := strings.TrimLeft(token, "-")
+	lead := token[:len(token)-len(name)]
+	var tokens []string
+	for _, arg := range this.optArgs {
+		if isHiddenArgument(arg) {
+			continue
+		}
+		for _, tk := range argumentTokens(arg) {
+			if strings.HasPrefix(tk, "--") {
+				tokens = append(tokens, tk[2:])
+			}
+		}
+	}
+	cands := FindSimilar(name, tokens, -1, 0.7)
+	if len(cands) > maxSuggestions {
+		cands = cands[:maxSuggestions]
+	}
+	for i := range cands {
+		if len(lead) > 0 {
+			cands[i] = "--" + cands[i]
+		}
+	}
+	return cands
+}
+
+// MissingValueError reports an option needing a value given as the last
+// argument
+type MissingValueError struct {
+	// Argument is the option as given, e.g. --zone
+	Argument string
+}
+
+func (e *MissingValueError) Error() string {
+	return fmt.Sprintf("Missing arguments for %s", e.Argument)
+}
+
+// NegativeValueError reports a value given to the negative token of a
+// boolean option, e.g. --no-debug=true
+type NegativeValueError struct {
+	// Argument is the option or key as given
+	Argument string
+}
+
+func (e *NegativeValueError) Error() string {
+	return fmt.Sprintf("Negative token %s does not take value", e.Argument)
+}
+
+// ArgumentCountError reports the values of a slice or map argument fewer
+// than Min or more than Max, either of which is -1 if not limited
+type ArgumentCountError struct {
+	// Argument is the token of the argument
+	Argument string
+	Count    int64
+	Min      int64
+	Max      int64
+}
+
+func (e *ArgumentCountError) Error() string {
+	if e.Min >= 0 && e.Count < e.Min {
+		return fmt.Sprintf("Argument count requires at least %d", e.Min)
+	}
+	return fmt.Sprintf("Argument count requires at most %d", e.Max)
+}
+
+// MultipleValuesError reports more than one value given to an argument
+// taking a single one, e.g. by a query of id=a&id=b
+type MultipleValuesError struct {
+	// Argument is the key as given
+	Argument string
+}
+
+func (e *MultipleValuesError) Error() string {
+	return fmt.Sprintf("Multiple values of %s", e.Argument)
+}
+
+// PatternError reports a value not matching the pattern tag of a string
+// argument
+type PatternError struct {
+	// Argument is the token of the argument
+	Argument string
+	// Value is masked for secret arguments
+	Value   string
+	Pattern *regexp.Regexp
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("Invalid value %q of %s, must match pattern %s", e.Value, e.Argument, e.Pattern)
+}
+
+// LengthError reports a string value out of the minlen and maxlen tags
+type LengthError struct {
+	// Argument is the token of the argument
+	Argument string
+	Length   int
+	// Range is of the form of min..max, >=min or <=max
+	Range string
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("Length %d of %s out of range %s", e.Length, e.Argument, e.Range)
+}
+
+// RangeError reports a number out of the min and max tags
+type RangeError struct {
+	// Argument is the token of the argument
+	Argument string
+	Value    interface{}
+	// Range is of the form of min..max, >=min or <=max
+	Range string
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("Value %v of %s out of range %s", e.Value, e.Argument, e.Range)
+}
+
+// ItemCountError reports the items of a slice or map argument fewer than
+// the minitems tag or more than the maxitems tag, either of which is 0 if
+// not limited
+type ItemCountError struct {
+	// Argument is the token of the argument
+	Argument string
+	Count    int
+	Min      int
+	Max      int
+}
+
+func (e *ItemCountError) Error() string {
+	if e.Min > 0 && e.Count < e.Min {
+		return fmt.Sprintf("Requires at least %d items, got %d", e.Min, e.Count)
+	}
+	return fmt.Sprintf("Accepts at most %d items, got %d", e.Max, e.Count)
+}
+
+// DuplicateValueError reports a value given twice to an argument of
+// unique:"error"
+type DuplicateValueError struct {
+	// Argument is the token of the argument
+	Argument string
+	// Value is masked for secret arguments
+	Value string
+}
+
+func (e *DuplicateValueError) Error() string {
+	return fmt.Sprintf("Duplicate value %s of %s", e.Value, e.Argument)
+}
+
+// PathError reports a path failing the file tag of a string argument
+type PathError struct {
+	// Argument is the token of the argument
+	Argument string
+	Path     string
+	Err      error
+}
+
+func (e *PathError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PathError) Cause() error {
+	return e.Err
+}
+
+// RequiresError reports an argument set without another named by its
+// requires tag
+type RequiresError struct {
+	// Argument is the token of the argument set
+	Argument string
+	// Required is the token of the argument not set
+	Required string
+}
+
+func (e *RequiresError) Error() string {
+	return fmt.Sprintf("Argument --%s requires --%s", e.Argument, e.Required)
+}
+
 type NotEnoughArgumentsError struct {
 	argument Argument
 }
@@ -25,3 +308,50 @@ type NotEnoughArgumentsError struct {
 func (e *NotEnoughArgumentsError) Error() string {
 	return fmt.Sprintf("Not enough arguments, missing %s", e.argument)
 }
+
+// SetCollectErrors controls whether ParseArgs keeps going after errors of
+// unknown arguments, bad values and failed validations to report all of
+// them at once.  More than one error are returned as errors.Aggregate.  The
+// setting is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetCollectErrors(collect bool) {
+	this.collectErrors = collect
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetCollectErrors(collect)
+		}
+	}
+}
+
+// joinErrors returns nil for no error, the error itself for one and the
+// flattened errors.Aggregate for more
+func joinErrors(errs []error) error {
+	var flat []error
+	for _, err := range errs {
+		if agg, ok := err.(errors.Aggregate); ok {
+			flat = append(flat, agg.Errors()...)
+		} else if err != nil {
+			flat = append(flat, err)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	}
+	return aggregateError{errors.NewAggregate(flat)}
+}
+
+// aggregateError is the errors.Aggregate of collected errors, unwrapping to
+// them for errors.Is and errors.As
+type aggregateError struct {
+	errors.Aggregate
+}
+
+func (e aggregateError) Unwrap() []error {
+	return e.Errors()
+}
+
+func (e aggregateError) Cause() error {
+	return errors.Cause(e.Aggregate)
+}
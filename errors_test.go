@@ -0,0 +1,315 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	stderrors "errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+func TestCollectErrors(t *testing.T) {
+	type Opts struct {
+		Zone  string `required:"true"`
+		Level string `choices:"debug|info"`
+		Count int    `min:"1"`
+		Name  string
+	}
+	args := []string{"--level", "warn", "--unknown", "--count", "0", "--name", "x"}
+	t.Run("first", func(t *testing.T) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		err := parser.ParseArgs(args, false)
+		if err == nil {
+			t.Fatalf("expecting error")
+		}
+		if _, ok := err.(errors.Aggregate); ok {
+			t.Errorf("expecting the first error only, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "Unknown argument 'warn' for level") {
+			t.Errorf("got %v", err)
+		}
+	})
+	t.Run("all", func(t *testing.T) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		parser.SetCollectErrors(true)
+		err := parser.ParseArgs(args, false)
+		agg, ok := err.(errors.Aggregate)
+		if !ok {
+			t.Fatalf("expecting aggregate, got %v", err)
+		}
+		want := []string{
+			"Unknown argument 'warn' for level",
+			"Unknown optional argument --unknown",
+			"Value 0 of count out of range >=1",
+			"zone error: Non-optional argument zone not set",
+		}
+		if len(agg.Errors()) != len(want) {
+			t.Fatalf("want %d errors, got %v", len(want), agg.Errors())
+		}
+		for i, e := range agg.Errors() {
+			if !strings.Contains(e.Error(), want[i]) {
+				t.Errorf("error %d: want %q, got %q", i, want[i], e)
+			}
+		}
+		if opts.Name != "x" {
+			t.Errorf("arguments after errors are not parsed")
+		}
+	})
+	t.Run("single", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		parser.SetCollectErrors(true)
+		err := parser.ParseArgs([]string{"--name", "x"}, false)
+		if _, ok := err.(errors.Aggregate); ok || err == nil {
+			t.Errorf("expecting the single error, got %v", err)
+		}
+		if err := parser.ParseArgs([]string{"--zone", "z"}, false); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+	t.Run("subcommand", func(t *testing.T) {
+		type CreateOptions struct {
+			NAME   string
+			Flavor string `choices:"small|large"`
+		}
+		parser := mustNewParser(t, &struct {
+			Debug      string `choices:"yes|no"`
+			SUBCOMMAND string `subcommand:"true"`
+		}{})
+		parser.SetCollectErrors(true)
+		if _, err := parser.AddSubParser(&CreateOptions{}, "create", "Create", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		err := parser.ParseArgs([]string{"--debug", "maybe", "create", "--flavor", "huge"}, false)
+		agg, ok := err.(errors.Aggregate)
+		if !ok {
+			t.Fatalf("expecting aggregate, got %v", err)
+		}
+		if len(agg.Errors()) != 3 {
+			t.Errorf("want 3 errors, got %v", agg.Errors())
+		}
+	})
+	t.Run("typed", func(t *testing.T) {
+		type Opts struct {
+			CertFile string
+			KeyFile  string   `requires:"cert-file"`
+			Tags     []string `nargs:"2"`
+			Debug    bool     `negative:"no-debug"`
+			Zone     string
+		}
+		parser := mustNewParser(t, &Opts{})
+		parser.SetCollectErrors(true)
+		err := parser.ParseArgs([]string{"--key-file", "k", "--tags", "a", "--no-debug=true", "--zone"}, false)
+		if _, ok := err.(errors.Aggregate); !ok {
+			t.Fatalf("expecting aggregate, got %v", err)
+		}
+		var negaErr *NegativeValueError
+		if !stderrors.As(err, &negaErr) || negaErr.Argument != "--no-debug" {
+			t.Errorf("NegativeValueError: got %v", err)
+		}
+		var valueErr *MissingValueError
+		if !stderrors.As(err, &valueErr) || valueErr.Argument != "--zone" {
+			t.Errorf("MissingValueError: got %v", err)
+		}
+		var countErr *ArgumentCountError
+		if !stderrors.As(err, &countErr) || countErr.Argument != "tags" || countErr.Count != 1 || countErr.Min != 2 {
+			t.Errorf("ArgumentCountError: got %v", err)
+		}
+		var reqErr *RequiresError
+		if !stderrors.As(err, &reqErr) || reqErr.Argument != "key-file" || reqErr.Required != "cert-file" {
+			t.Errorf("RequiresError: got %v", err)
+		}
+	})
+}
+
+func TestTypedErrors(t *testing.T) {
+	type Opts struct {
+		Zone  string `required:"true"`
+		Level string `choices:"debug|info"`
+		Count int
+		NAME  string
+	}
+	parse := func(args ...string) error {
+		return mustNewParser(t, &Opts{}).ParseArgs(args, false)
+	}
+	t.Run("unknown", func(t *testing.T) {
+		err := parse("--zone", "z", "--unknown", "1", "n")
+		e, ok := err.(*UnknownArgumentError)
+		if !ok || e.Argument != "--unknown" || e.Positional {
+			t.Fatalf("got %#v", err)
+		}
+		if err.Error() != "Unknown optional argument --unknown" {
+			t.Errorf("got %q", err)
+		}
+		err = parse("--zone", "z", "n", "extra")
+		if e, ok := err.(*UnknownArgumentError); !ok || e.Argument != "extra" || !e.Positional {
+			t.Errorf("got %#v", err)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		err := parse("n")
+		e, ok := errors.Cause(err).(*MissingRequiredError)
+		if !ok || e.Argument != "zone" {
+			t.Fatalf("got %#v", err)
+		}
+		if err.Error() != "zone error: Non-optional argument zone not set" {
+			t.Errorf("got %q", err)
+		}
+	})
+	t.Run("choice", func(t *testing.T) {
+		err := parse("--zone", "z", "--level", "inf", "n")
+		e, ok := err.(*InvalidChoiceError)
+		if !ok || e.Argument != "level" || e.Value != "inf" || len(e.Suggestions) != 1 || e.Suggestions[0] != "info" {
+			t.Fatalf("got %#v", err)
+		}
+		if len(e.Choices) != 2 {
+			t.Errorf("choices: got %v", e.Choices)
+		}
+	})
+	t.Run("conversion", func(t *testing.T) {
+		err := parse("--zone", "z", "--count", "many", "n")
+		e, ok := err.(*ConversionError)
+		if !ok || e.Argument != "count" || e.Value != "many" || e.Type.Kind() != reflect.Int || e.Err == nil {
+			t.Fatalf("got %#v", err)
+		}
+	})
+	t.Run("config", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		dict := jsonutils.NewDict()
+		dict.Set("zonee", jsonutils.NewString("z"))
+		err := parser.UpdateJSONDict(dict, true)
+		if e, ok := err.(*UnknownArgumentError); !ok || e.Argument != "zonee" {
+			t.Errorf("got %#v", err)
+		}
+	})
+	t.Run("env", func(t *testing.T) {
+		type EnvOpts struct {
+			Port int    `env:"STRUCTARG_TEST_TYPED_PORT"`
+			Zone string `env:"STRUCTARG_TEST_TYPED_ZONE" choices:"a|b"`
+		}
+		os.Setenv("STRUCTARG_TEST_TYPED_PORT", "abc")
+		defer os.Unsetenv("STRUCTARG_TEST_TYPED_PORT")
+		err := mustNewParser(t, &EnvOpts{}).ParseArgs(nil, false)
+		var convErr *ConversionError
+		if !stderrors.As(err, &convErr) || convErr.Argument != "port" {
+			t.Errorf("ConversionError: got %v", err)
+		}
+		os.Setenv("STRUCTARG_TEST_TYPED_PORT", "1")
+		os.Setenv("STRUCTARG_TEST_TYPED_ZONE", "c")
+		defer os.Unsetenv("STRUCTARG_TEST_TYPED_ZONE")
+		err = mustNewParser(t, &EnvOpts{}).ParseArgs(nil, false)
+		var choiceErr *InvalidChoiceError
+		if !stderrors.As(err, &choiceErr) || choiceErr.Value != "c" {
+			t.Errorf("InvalidChoiceError: got %v", err)
+		}
+	})
+	t.Run("validation", func(t *testing.T) {
+		type CheckOpts struct {
+			Name  string   `pattern:"^[a-z]+$"`
+			Label string   `maxlen:"2"`
+			Size  int      `min:"1"`
+			Tags  []string `unique:"error"`
+			Zones []string `minitems:"2"`
+			Conf  string   `file:"exist"`
+		}
+		parser := mustNewParser(t, &CheckOpts{})
+		parser.SetCollectErrors(true)
+		err := parser.ParseArgs([]string{"--name", "A", "--label", "abc", "--size", "0",
+			"--tags", "a", "--tags", "a", "--zones", "z", "--conf", "/nonexistent/structarg"}, false)
+		var patternErr *PatternError
+		if !stderrors.As(err, &patternErr) || patternErr.Argument != "name" || patternErr.Value != "A" {
+			t.Errorf("PatternError: got %v", err)
+		}
+		var lengthErr *LengthError
+		if !stderrors.As(err, &lengthErr) || lengthErr.Argument != "label" || lengthErr.Length != 3 {
+			t.Errorf("LengthError: got %v", err)
+		}
+		var rangeErr *RangeError
+		if !stderrors.As(err, &rangeErr) || rangeErr.Argument != "size" || rangeErr.Range != ">=1" {
+			t.Errorf("RangeError: got %v", err)
+		}
+		var dupErr *DuplicateValueError
+		if !stderrors.As(err, &dupErr) || dupErr.Argument != "tags" || dupErr.Value != "a" {
+			t.Errorf("DuplicateValueError: got %v", err)
+		}
+		var itemErr *ItemCountError
+		if !stderrors.As(err, &itemErr) || itemErr.Argument != "zones" || itemErr.Count != 1 {
+			t.Errorf("ItemCountError: got %v", err)
+		}
+		var pathErr *PathError
+		if !stderrors.As(err, &pathErr) || pathErr.Argument != "conf" {
+			t.Errorf("PathError: got %v", err)
+		}
+	})
+}
+
+func TestSuggestions(t *testing.T) {
+	type Opts struct {
+		Option     string
+		DnsDomain  string
+		Secret     string `hidden:"true"`
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	parser := mustNewParser(t, &Opts{})
+	if _, err := parser.AddSubParser(&struct{}{}, "create", "Create", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if _, err := parser.AddSubParser(&struct{}{}, "delete", "Delete", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := parser.GetSubcommand().AddAliases("delete", "remove"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--optoin", "x"}, "Unknown optional argument --optoin, did you mean --option?"},
+		{[]string{"--dns-domian", "x"}, "did you mean --dns-domain?"},
+		{[]string{"--secert", "x"}, "Unknown optional argument --secert"},
+		{[]string{"--zzz", "create"}, "Unknown optional argument --zzz"},
+		{[]string{"crate"}, "Unknown argument 'crate' for subcommand, did you mean \"create\"?"},
+		{[]string{"remvoe"}, "did you mean \"remove\""},
+	}
+	for _, c := range cases {
+		err := parser.ParseArgs(c.args, false)
+		if err == nil {
+			t.Errorf("%v: expecting error", c.args)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: want %q, got %q", c.args, c.want, err)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("%v: hidden option suggested: %q", c.args, err)
+		}
+	}
+	if err := parser.ParseArgs([]string{"--zzz", "create"}, false); strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("unexpected suggestion: %v", err)
+	}
+
+	dict := jsonutils.NewDict()
+	dict.Set("dns_domian", jsonutils.NewString("x"))
+	err := parser.UpdateJSONDict(dict, true)
+	if err == nil || err.Error() != "Unknown argument dns-domian, did you mean dns-domain?" {
+		t.Errorf("got %v", err)
+	}
+}
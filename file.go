@@ -0,0 +1,217 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the path must exist
+	FILE_EXIST = "exist"
+	// the path must be an existing directory
+	FILE_DIR = "dir"
+	// the file can be created, i.e. its parent is a writable directory
+	FILE_CREATE = "create"
+
+	// value of fromfile tag
+	FROMFILE_ALLOW = "allow"
+)
+
+// maxResponseFiles limits the response files expanded by a parse, which also
+// stops files including themselves
+const maxResponseFiles = 64
+
+// stdin is read for value @- of the arguments with fromfile tag
+var stdin io.Reader = os.Stdin
+
+// expandHome replaces the leading ~ of path with home directory of the user
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("expand %s: %v", path, err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// readFromFile returns content of the file if val is of the form @path and
+// the argument allows so.  Only values from command line and environment are
+// expanded, those of configuration files are taken literally
+func (this *SingleArgument) readFromFile(val string) (string, error) {
+	if !this.fromFile || !strings.HasPrefix(val, "@") || this.parser == nil {
+		return val, nil
+	}
+	if this.parser.source != SOURCE_COMMAND_LINE && this.parser.source != SOURCE_ENV {
+		return val, nil
+	}
+	var content []byte
+	var err error
+	if val == "@-" {
+		content, err = ioutil.ReadAll(stdin)
+	} else {
+		var path string
+		path, err = expandHome(val[1:])
+		if err == nil {
+			content, err = ioutil.ReadFile(path)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("read value of %s from %s: %v", this.Token(), val[1:], err)
+	}
+	str := string(content)
+	if strings.HasSuffix(str, "\n") {
+		str = strings.TrimSuffix(strings.TrimSuffix(str, "\n"), "\r")
+	}
+	return str, nil
+}
+
+func checkPath(path string, check string) error {
+	switch check {
+	case FILE_EXIST:
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("File %s does not exist", path)
+		}
+	case FILE_DIR:
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("Directory %s does not exist", path)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+	case FILE_CREATE:
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		dir := filepath.Dir(path)
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("Directory %s of %s does not exist", dir, path)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s of %s is not a directory", dir, path)
+		}
+		// probe by creating a file as permission bits tell nothing about
+		// ACLs, read-only mounts and the like
+		probe, err := ioutil.TempFile(dir, ".structarg")
+		if err != nil {
+			return fmt.Errorf("Directory %s of %s is not writable", dir, path)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	return nil
+}
+
+// SetResponseFiles enables response files, i.e. a positional @path of the
+// command line is replaced with the arguments read from file path, which are
+// split as by shell with quotes and backslash escapes, and comments from #
+// to the end of line.  Response files may refer to other ones.  Option values
+// of the form @path are not expanded.  The setting is also applied to the
+// parsers of subcommands
+func (this *ArgumentParser) SetResponseFiles(enable bool) {
+	this.responseFiles = enable
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetResponseFiles(enable)
+		}
+	}
+}
+
+func readResponseFile(path string) ([]string, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read response file: %v", err)
+	}
+	tokens, err := splitShellWords(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("response file %s: %v", path, err)
+	}
+	return tokens, nil
+}
+
+// splitShellWords splits str into words as by shell, without expansion of
+// variables and globs
+func splitShellWords(str string) ([]string, error) {
+	var words []string
+	var word []byte
+	inWord := false
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if inWord {
+				words = append(words, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			for i < len(str) && str[i] != '\n' {
+				i++
+			}
+		case c == '\\':
+			i++
+			if i >= len(str) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			if str[i] != '\n' {
+				word = append(word, str[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(str[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word = append(word, str[i+1:i+1+end]...)
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(str) && str[i] != '"'; i++ {
+				if str[i] == '\\' && i+1 < len(str) && strings.IndexByte("\"\\$`\n", str[i+1]) >= 0 {
+					i++
+					if str[i] == '\n' {
+						continue
+					}
+				}
+				word = append(word, str[i])
+			}
+			if i >= len(str) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, string(word))
+	}
+	return words, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "conf")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	type Opts struct {
+		Conf   string   `file:"exist"`
+		Data   string   `file:"dir"`
+		Output string   `file:"create"`
+		Certs  []string `file:"exist"`
+		Log    string   `file:"create" default:"/nonexist/dir/log"`
+	}
+	parse := func(args ...string) (*Opts, error) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		return opts, parser.ParseArgs(args, false)
+	}
+	t.Run("valid", func(t *testing.T) {
+		opts, err := parse("--conf", file, "--data", dir, "--output", filepath.Join(dir, "out"), "--certs", file, "--certs", dir)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Conf != file || opts.Data != dir || len(opts.Certs) != 2 {
+			t.Errorf("unexpected %#v", opts)
+		}
+		if opts.Log != "/nonexist/dir/log" {
+			t.Errorf("default not checked, got %q", opts.Log)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--conf", filepath.Join(dir, "nonexist")},
+			{"--data", file},
+			{"--data", filepath.Join(dir, "nonexist")},
+			{"--output", dir},
+			{"--output", filepath.Join(dir, "nonexist", "out")},
+			{"--output", filepath.Join(file, "out")},
+			{"--certs", file, "--certs", filepath.Join(dir, "nonexist")},
+		} {
+			if _, err := parse(args...); err == nil {
+				t.Errorf("%v: expecting error", args)
+			}
+		}
+	})
+	t.Run("home", func(t *testing.T) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			t.Skipf("no home: %v", err)
+		}
+		opts, err := parse("--data", "~")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Data != home {
+			t.Errorf("want %s, got %s", home, opts.Data)
+		}
+		if got, _ := expandHome("~/x/y"); got != filepath.Join(home, "x", "y") {
+			t.Errorf("got %s", got)
+		}
+		if got, _ := expandHome("~x"); got != "~x" {
+			t.Errorf("got %s", got)
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		if _, err := NewArgumentParser(&struct {
+			Conf string `file:"bad"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of invalid file tag")
+		}
+		if _, err := NewArgumentParser(&struct {
+			Num int `file:"exist"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of file tag on int")
+		}
+	})
+}
+
+func TestFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	body := filepath.Join(dir, "body.json")
+	if err := ioutil.WriteFile(body, []byte("{\"name\": \"x\"}\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	type Opts struct {
+		Data  string   `fromfile:"allow"`
+		Certs []string `fromfile:"allow"`
+		Name  string
+	}
+	parse := func(args ...string) (*Opts, error) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		return opts, parser.ParseArgs(args, false)
+	}
+	t.Run("file", func(t *testing.T) {
+		opts, err := parse("--data", "@"+body, "--certs", "@"+body, "--certs", "plain", "--name", "@"+body)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Data != `{"name": "x"}` {
+			t.Errorf("data: got %q", opts.Data)
+		}
+		if len(opts.Certs) != 2 || opts.Certs[0] != opts.Data || opts.Certs[1] != "plain" {
+			t.Errorf("certs: got %q", opts.Certs)
+		}
+		if opts.Name != "@"+body {
+			t.Errorf("name without tag: got %q", opts.Name)
+		}
+	})
+	t.Run("stdin", func(t *testing.T) {
+		defer func(r io.Reader) { stdin = r }(stdin)
+		stdin = strings.NewReader("from stdin\r\n")
+		opts, err := parse("--data", "@-")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Data != "from stdin" {
+			t.Errorf("got %q", opts.Data)
+		}
+	})
+	t.Run("nonexist", func(t *testing.T) {
+		if _, err := parse("--data", "@"+filepath.Join(dir, "nonexist")); err == nil {
+			t.Errorf("expecting error")
+		}
+	})
+	t.Run("config", func(t *testing.T) {
+		conf := filepath.Join(dir, "conf.json")
+		if err := ioutil.WriteFile(conf, []byte(`{"data": "@`+body+`"}`), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		if err := parser.ParseJSONFile(conf); err != nil {
+			t.Fatalf("ParseJSONFile: %v", err)
+		}
+		if opts.Data != "@"+body {
+			t.Errorf("config value is expanded: %q", opts.Data)
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		if _, err := NewArgumentParser(&struct {
+			Data string `fromfile:"yes"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of invalid fromfile tag")
+		}
+	})
+}
+
+func TestSplitShellWords(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"--name x\n--count 2\n", []string{"--name", "x", "--count", "2"}},
+		{"  a\tb  ", []string{"a", "b"}},
+		{`'a b' "c \"d\"" e\ f`, []string{"a b", `c "d"`, "e f"}},
+		{"x'y'\"z\"", []string{"xyz"}},
+		{"a # comment b\nc#d", []string{"a", "c#d"}},
+		{"a \\\nb", []string{"a", "b"}},
+		{`''`, []string{""}},
+	}
+	for _, c := range cases {
+		got, err := splitShellWords(c.in)
+		if err != nil {
+			t.Errorf("%q: %v", c.in, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("%q: want %q, got %q", c.in, c.want, got)
+		}
+	}
+	for _, in := range []string{`'a`, `"a`, `a\`} {
+		if _, err := splitShellWords(in); err == nil {
+			t.Errorf("%q: expecting error", in)
+		}
+	}
+}
+
+func TestResponseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		return path
+	}
+	inner := write("inner.txt", "--tag c\n")
+	args := write("args.txt", "# build flags\n--name 'my name'\n--tag a --tag b\n@"+inner+"\nsrc1\n")
+	loop := filepath.Join(dir, "loop.txt")
+	write("loop.txt", "@"+loop)
+	type Opts struct {
+		Name  string
+		Tag   []string
+		Data  string
+		FILES []string
+	}
+	t.Run("expand", func(t *testing.T) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		parser.SetResponseFiles(true)
+		err := parser.ParseArgs([]string{"@" + args, "--data", "@" + args, "src2"}, false)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Name != "my name" {
+			t.Errorf("name: got %q", opts.Name)
+		}
+		if strings.Join(opts.Tag, ",") != "a,b,c" {
+			t.Errorf("tag: got %q", opts.Tag)
+		}
+		if opts.Data != "@"+args {
+			t.Errorf("option value is expanded: %q", opts.Data)
+		}
+		if strings.Join(opts.FILES, ",") != "src1,src2" {
+			t.Errorf("files: got %q", opts.FILES)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		if err := parser.ParseArgs([]string{"@" + args}, false); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if len(opts.FILES) != 1 || opts.FILES[0] != "@"+args {
+			t.Errorf("got %q", opts.FILES)
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		for _, path := range []string{loop, filepath.Join(dir, "nonexist")} {
+			parser := mustNewParser(t, &Opts{})
+			parser.SetResponseFiles(true)
+			if err := parser.ParseArgs([]string{"@" + path}, false); err == nil {
+				t.Errorf("%s: expecting error", path)
+			}
+		}
+	})
+}
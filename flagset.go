@@ -0,0 +1,262 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// sFlagArg is an argument of a flag of flag.FlagSet, see AddFlagSet
+type sFlagArg struct {
+	sHelpArg
+	flag  *flag.Flag
+	isSet bool
+	// source of the value when isSet
+	source string
+	parser *ArgumentParser
+}
+
+func (self *sFlagArg) Token() string {
+	return self.flag.Name
+}
+
+func (self *sFlagArg) ShortToken() string {
+	return ""
+}
+
+func (self *sFlagArg) isBool() bool {
+	bf, ok := self.flag.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+func (self *sFlagArg) NeedData() bool {
+	return !self.isBool()
+}
+
+func (self *sFlagArg) MetaVar() string {
+	name, _ := flag.UnquoteUsage(self.flag)
+	if len(name) == 0 {
+		name = "value"
+	}
+	return strings.ToUpper(name)
+}
+
+func (self *sFlagArg) HelpString(indent string) string {
+	_, usage := flag.UnquoteUsage(self.flag)
+	if def := self.flag.DefValue; len(def) > 0 && def != "false" && def != "0" {
+		usage = strings.TrimLeft(fmt.Sprintf("%s (default: %s)", usage, def), " ")
+	}
+	return indent + usage
+}
+
+func (self *sFlagArg) String() string {
+	if self.NeedData() {
+		return fmt.Sprintf("[--%s %s]", self.Token(), self.MetaVar())
+	}
+	return fmt.Sprintf("[--%s]", self.Token())
+}
+
+func (self *sFlagArg) SetValue(val string) error {
+	if err := self.flag.Value.Set(val); err != nil {
+		return &ConversionError{Argument: self.Token(), Value: val, Type: reflect.TypeOf(self.flag.Value), Err: err}
+	}
+	self.isSet = true
+	self.source = self.parser.source
+	return nil
+}
+
+func (self *sFlagArg) DoAction(nega bool) error {
+	return self.SetValue(strconv.FormatBool(!nega))
+}
+
+// Reset restores the default value of the flag if it is set
+func (self *sFlagArg) Reset() {
+	if self.isSet {
+		self.flag.Value.Set(self.flag.DefValue)
+	}
+	self.isSet = false
+	self.source = ""
+}
+
+func (self *sFlagArg) IsSet() bool {
+	return self.isSet
+}
+
+func (self *sFlagArg) Source() string {
+	if self.isSet {
+		return self.source
+	}
+	return ""
+}
+
+// cloneArgument shares the flag, whose value lives outside of the target
+func (self *sFlagArg) cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error) {
+	return &sFlagArg{flag: self.flag, parser: parser}, nil
+}
+
+func (self *sFlagArg) setParser(parser *ArgumentParser) {
+	self.parser = parser
+}
+
+// AddFlagSet adds the flags of fs as optional arguments, e.g. those of
+// libraries registering flags to flag.CommandLine, so that they are parsed
+// and shown in help along with the others.  Flags are set by SetValue of
+// their flag.Value, the default is restored when parsed again
+func (this *ArgumentParser) AddFlagSet(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err == nil {
+			err = this.AddArgument(&sFlagArg{flag: f, parser: this})
+		}
+	})
+	return err
+}
+
+// sArgumentFlag exposes an argument as flag.Value, see BindFlagSet
+type sArgumentFlag struct {
+	arg  Argument
+	nega bool
+}
+
+func (self *sArgumentFlag) String() string {
+	if self == nil || self.arg == nil {
+		return ""
+	}
+	if isSecretArgument(self.arg) {
+		return ""
+	}
+	if varg, ok := self.arg.(valueArgument); ok && !self.nega {
+		if values, ok := configValues(self.arg, varg.getValue()); ok {
+			return strings.Join(values, ",")
+		}
+	}
+	return ""
+}
+
+func (self *sArgumentFlag) Set(val string) error {
+	if !self.IsBoolFlag() {
+		return self.arg.SetValue(val)
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return err
+	}
+	return self.arg.DoAction(b == self.nega)
+}
+
+func (self *sArgumentFlag) IsBoolFlag() bool {
+	return self.nega || !self.arg.NeedData()
+}
+
+// Type names the type of the value for pflag.Value
+func (self *sArgumentFlag) Type() string {
+	if self.IsBoolFlag() {
+		return "bool"
+	}
+	if darg, ok := self.arg.(documentedArgument); ok {
+		return darg.typeName()
+	}
+	return "string"
+}
+
+// FlagValue is a flag.Value with the Type method of pflag.Value, thus
+// accepted by both flag and github.com/spf13/pflag
+type FlagValue interface {
+	flag.Value
+	Type() string
+}
+
+// FlagDefinition describes a flag for an optional argument, see
+// FlagDefinitions
+type FlagDefinition struct {
+	Name      string
+	Shorthand string
+	Usage     string
+	Value     FlagValue
+	// NoOptDefVal is "true" for boolean flags, which take no value
+	NoOptDefVal string
+	Hidden      bool
+	// Complete returns candidate values starting with prefix, from the
+	// completer of SetCompleter or the choices
+	Complete func(prefix string) []string
+}
+
+// FlagDefinitions returns the flags of the optional arguments for other
+// flag libraries, e.g. for pflag and cobra without depending on them:
+//
+//	for _, def := range parser.FlagDefinitions() {
+//		f := cmd.Flags().VarPF(def.Value, def.Name, def.Shorthand, def.Usage)
+//		f.NoOptDefVal = def.NoOptDefVal
+//		f.Hidden = def.Hidden
+//	}
+//
+// Alias and negative tokens are flags of their own.  SetDefault should be
+// called after parsing to set the rest to defaults
+func (this *ArgumentParser) FlagDefinitions() []FlagDefinition {
+	var defs []FlagDefinition
+	for _, arg := range this.optArgs {
+		if _, ok := arg.(valueArgument); !ok {
+			continue
+		}
+		arg := arg
+		complete := func(prefix string) []string {
+			return this.completeValue(arg, "", prefix)
+		}
+		usage := strings.TrimSpace(arg.HelpString(""))
+		tokens := append([]string{arg.Token()}, argumentAliasTokens(arg)...)
+		for i, token := range tokens {
+			def := FlagDefinition{Name: token, Usage: usage, Value: &sArgumentFlag{arg: arg}, Hidden: isHiddenArgument(arg), Complete: complete}
+			if i == 0 {
+				def.Shorthand = arg.ShortToken()
+			}
+			defs = append(defs, def)
+		}
+		if len(arg.NegativeToken()) > 0 {
+			defs = append(defs, FlagDefinition{Name: arg.NegativeToken(), Usage: usage, Value: &sArgumentFlag{arg: arg, nega: true}, Hidden: isHiddenArgument(arg)})
+		}
+	}
+	for i := range defs {
+		if defs[i].Value.(*sArgumentFlag).IsBoolFlag() {
+			defs[i].NoOptDefVal = "true"
+		}
+	}
+	return defs
+}
+
+// BindFlagSet defines flags of fs for the optional arguments, e.g. for
+// programs invoking fs.Parse themselves.  Values are converted and checked
+// as in ParseArgs.  Short tokens are flags of their own.  SetDefault should
+// be called after fs.Parse to set the rest to defaults
+func (this *ArgumentParser) BindFlagSet(fs *flag.FlagSet) error {
+	defs := this.FlagDefinitions()
+	for _, def := range defs {
+		for _, name := range []string{def.Name, def.Shorthand} {
+			if len(name) > 0 && fs.Lookup(name) != nil {
+				return fmt.Errorf("Flag %s is defined already", name)
+			}
+		}
+	}
+	for _, def := range defs {
+		fs.Var(def.Value, def.Name, def.Usage)
+		if len(def.Shorthand) > 0 {
+			fs.Var(def.Value, def.Shorthand, def.Usage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddFlagSet(t *testing.T) {
+	type Options struct {
+		Zone string
+	}
+	newParser := func(t *testing.T) (*ArgumentParser, *Options, *int, *bool, *time.Duration) {
+		fs := flag.NewFlagSet("lib", flag.ContinueOnError)
+		v := fs.Int("v", 0, "log `level` for V logs")
+		logtostderr := fs.Bool("logtostderr", false, "log to standard error")
+		timeout := fs.Duration("lib-timeout", time.Second, "timeout of lib")
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.AddFlagSet(fs); err != nil {
+			t.Fatalf("AddFlagSet: %v", err)
+		}
+		return p, s, v, logtostderr, timeout
+	}
+	t.Run("parse", func(t *testing.T) {
+		p, s, v, logtostderr, timeout := newParser(t)
+		if err := p.ParseArgs([]string{"--v", "2", "--logtostderr", "--zone", "z1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if *v != 2 || !*logtostderr || *timeout != time.Second || s.Zone != "z1" {
+			t.Errorf("unexpected v %d, logtostderr %v, timeout %s, zone %s", *v, *logtostderr, *timeout, s.Zone)
+		}
+		if got := p.Source("v"); got != SOURCE_COMMAND_LINE {
+			t.Errorf("want source command line, got %q", got)
+		}
+		if err := p.ParseArgs([]string{"--lib-timeout", "1m"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if *v != 0 || *logtostderr || *timeout != time.Minute {
+			t.Errorf("want flags reset, got v %d, logtostderr %v, timeout %s", *v, *logtostderr, *timeout)
+		}
+		if err := p.ParseArgs([]string{"--v", "x"}, false); err == nil {
+			t.Errorf("want error of invalid value")
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		p, _, _, _, _ := newParser(t)
+		help := p.HelpString()
+		for _, str := range []string{"[--v LEVEL]", "[--logtostderr]", "log level for V logs\n", "timeout of lib (default: 1s)\n"} {
+			if !strings.Contains(help, str) {
+				t.Errorf("help without %q:\n%s", str, help)
+			}
+		}
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		fs := flag.NewFlagSet("lib", flag.ContinueOnError)
+		fs.String("zone", "", "zone")
+		p := mustNewParser(t, &Options{})
+		if err := p.AddFlagSet(fs); err == nil {
+			t.Errorf("want error of duplicate zone")
+		}
+	})
+}
+
+func TestBindFlagSet(t *testing.T) {
+	type Options struct {
+		Zone    string `short-token:"z" choices:"z1|z2" help:"zone of the server"`
+		Tags    []string
+		Debug   bool `negative:"no-debug"`
+		Verbose int  `count:"true"`
+		Limit   int  `default:"20"`
+	}
+	s := &Options{}
+	p := mustNewParser(t, s)
+	fs := flag.NewFlagSet("prog", flag.ContinueOnError)
+	var out bytes.Buffer
+	fs.SetOutput(&out)
+	if err := p.BindFlagSet(fs); err != nil {
+		t.Fatalf("BindFlagSet: %v", err)
+	}
+	if err := fs.Parse([]string{"-z", "z1", "--tags", "a", "-tags=b", "-debug", "-no-debug", "-verbose", "-verbose"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	p.SetDefault()
+	want := &Options{Zone: "z1", Tags: []string{"a", "b"}, Verbose: 2, Limit: 20}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("want %#v, got %#v", want, s)
+	}
+	if err := fs.Parse([]string{"-zone", "z3"}); err == nil {
+		t.Errorf("want error of invalid choice")
+	}
+	fs.PrintDefaults()
+	if !strings.Contains(out.String(), "zone of the server") {
+		t.Errorf("defaults without zone:\n%s", out.String())
+	}
+	if err := p.BindFlagSet(fs); err == nil {
+		t.Errorf("want error of duplicate flags")
+	}
+}
+
+func TestFlagDefinitions(t *testing.T) {
+	type Options struct {
+		Zone  string   `short-token:"z" choices:"z1|z2" help:"zone of the server"`
+		Disks []string `alias:"disk"`
+		Debug bool     `negative:"no-debug" hidden:"true"`
+	}
+	s := &Options{}
+	p := mustNewParser(t, s)
+	defs := make(map[string]FlagDefinition)
+	for _, def := range p.FlagDefinitions() {
+		defs[def.Name] = def
+	}
+	if len(defs) != 5 {
+		t.Fatalf("want 5 definitions, got %#v", defs)
+	}
+	zone := defs["zone"]
+	if zone.Shorthand != "z" || zone.Value.Type() != "string" || zone.NoOptDefVal != "" || !strings.Contains(zone.Usage, "zone of the server") {
+		t.Errorf("unexpected zone %#v", zone)
+	}
+	if cands := zone.Complete("z"); !reflect.DeepEqual(cands, []string{"z1", "z2"}) {
+		t.Errorf("want candidates z1 z2, got %v", cands)
+	}
+	if err := zone.Value.Set("z2"); err != nil || zone.Value.String() != "z2" {
+		t.Errorf("set zone: %v, %s", err, zone.Value.String())
+	}
+	if err := defs["disk"].Value.Set("d1"); err != nil || defs["disks"].Value.String() != "d1" {
+		t.Errorf("set disk: %v, %s", err, defs["disks"].Value.String())
+	}
+	debug := defs["debug"]
+	if debug.Value.Type() != "bool" || debug.NoOptDefVal != "true" || !debug.Hidden {
+		t.Errorf("unexpected debug %#v", debug)
+	}
+	debug.Value.Set("true")
+	if !s.Debug {
+		t.Errorf("want debug set")
+	}
+	defs["no-debug"].Value.Set("true")
+	if s.Debug {
+		t.Errorf("want debug unset")
+	}
+}
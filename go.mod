@@ -5,5 +5,6 @@ go 1.12
 require (
 	github.com/nyl1001/pkg v1.0.3
 	github.com/texttheater/golang-levenshtein v0.0.0-20180516184445-d188e65d659e
+	golang.org/x/term v0.15.0
 	yunion.io/x/log v1.0.1-0.20230411060016-feb3f46ab361
 )
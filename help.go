@@ -0,0 +1,358 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/utils"
+)
+
+// DefaultUsageTemplate renders the usage line of Usage, given HelpData
+const DefaultUsageTemplate = `Usage: {{.Prog}}` +
+	`{{range .Options}} {{.String}}{{end}}` +
+	`{{range .Positionals}} {{.String}}{{if or .IsSubcommand .IsMulti}} ...{{end}}{{end}}` + "\n\n"
+
+// DefaultHelpTemplate renders the help message of HelpString, given
+// HelpData.  The template "arguments" renders a list of arguments
+const DefaultHelpTemplate = `{{define "arguments"}}{{range .}}    {{.String}}
+{{.HelpString "        "}}
+{{end}}
+{{end}}` +
+	`{{.Usage}}{{.Description}}
+
+` +
+	`{{if .Positionals}}Positional arguments:
+{{template "arguments" .Positionals}}{{end}}` +
+	`{{if .Optionals}}Optional arguments:
+{{template "arguments" .Optionals}}{{end}}` +
+	`{{range .Groups}}{{.Name}}:
+{{template "arguments" .Arguments}}{{end}}` +
+	`{{if .Epilog}}{{.Epilog}}
+
+{{end}}`
+
+// HelpData is the data of usage and help templates
+type HelpData struct {
+	Parser      *ArgumentParser
+	Prog        string
+	Description string
+	Epilog      string
+	// Usage is the rendered usage, empty when rendering usage itself
+	Usage       string
+	Positionals []Argument
+	// Options are all the optional arguments not hidden
+	Options []Argument
+	// Optionals are the optional arguments not in any group
+	Optionals []Argument
+	// Groups are the groups of optional arguments in order of appearance
+	Groups []HelpGroup
+}
+
+// HelpGroup is a group of optional arguments, see TAG_GROUP
+type HelpGroup struct {
+	Name      string
+	Arguments []Argument
+}
+
+var helpFuncs = template.FuncMap{
+	"join":   strings.Join,
+	"repeat": strings.Repeat,
+}
+
+var (
+	defaultUsageTemplate = template.Must(template.New("usage").Funcs(helpFuncs).Parse(DefaultUsageTemplate))
+	defaultHelpTemplate  = template.Must(template.New("help").Funcs(helpFuncs).Parse(DefaultHelpTemplate))
+)
+
+// SetUsageTemplate replaces the template of Usage, see DefaultUsageTemplate.
+// Functions join and repeat of package strings are available.  The template
+// is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetUsageTemplate(text string) error {
+	tmpl, err := template.New("usage").Funcs(helpFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("parse usage template: %v", err)
+	}
+	this.setHelpTemplates(tmpl, this.helpTemplate)
+	return nil
+}
+
+// SetHelpTemplate replaces the template of HelpString, see
+// DefaultHelpTemplate.  Functions join and repeat of package strings are
+// available.  The template is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetHelpTemplate(text string) error {
+	tmpl, err := template.New("help").Funcs(helpFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("parse help template: %v", err)
+	}
+	this.setHelpTemplates(this.usageTemplate, tmpl)
+	return nil
+}
+
+// SetHelpDecorations controls whether the help of arguments is appended with
+// the default value, choices, environment variable and constraints of
+// pattern, range and length, e.g. "(default: 80) (env: PROG_PORT)".  It is
+// enabled by default.  The setting is also applied to the parsers of
+// subcommands
+func (this *ArgumentParser) SetHelpDecorations(enable bool) {
+	this.noHelpDecorations = !enable
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetHelpDecorations(enable)
+		}
+	}
+}
+
+func (this *ArgumentParser) setHelpTemplates(usage, help *template.Template) {
+	this.usageTemplate = usage
+	this.helpTemplate = help
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.setHelpTemplates(usage, help)
+		}
+	}
+}
+
+// helpData collects the data of templates, hidden arguments excluded
+func (this *ArgumentParser) helpData() *HelpData {
+	data := &HelpData{
+		Parser:      this,
+		Prog:        this.prog,
+		Description: this.description,
+		Epilog:      this.epilog,
+		Positionals: this.posArgs,
+	}
+	// ungrouped optional arguments first, then groups in order of
+	// appearance
+	groups := make(map[string]int)
+	for _, arg := range this.optArgs {
+		if isHiddenArgument(arg) {
+			continue
+		}
+		data.Options = append(data.Options, arg)
+		group := argumentGroup(arg)
+		if len(group) == 0 {
+			data.Optionals = append(data.Optionals, arg)
+			continue
+		}
+		idx, ok := groups[group]
+		if !ok {
+			idx = len(data.Groups)
+			groups[group] = idx
+			data.Groups = append(data.Groups, HelpGroup{Name: group})
+		}
+		data.Groups[idx].Arguments = append(data.Groups[idx].Arguments, arg)
+	}
+	return data
+}
+
+func executeHelpTemplate(tmpl *template.Template, data *HelpData) string {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Sprintf("%s template: %v\n", tmpl.Name(), err)
+	}
+	return buf.String()
+}
+
+// SetHelpEnabled controls whether the parser registers and handles the help
+// argument, e.g. disabled for programs handling --help themselves.  It is
+// enabled by default.  The setting is also applied to the parsers of
+// subcommands
+func (this *ArgumentParser) SetHelpEnabled(enable bool) {
+	this.noHelp = !enable
+	if harg := this.helpArgument(); harg != nil && !enable {
+		for i, arg := range this.optArgs {
+			if arg == harg {
+				this.optArgs = append(this.optArgs[:i], this.optArgs[i+1:]...)
+				this.invalidateTokens()
+				break
+			}
+		}
+	} else if harg == nil && enable {
+		// a field of the target taking the token help wins
+		this.AddArgument(&sHelpArg{})
+	}
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetHelpEnabled(enable)
+		}
+	}
+}
+
+// SetHelpToken renames the help argument, e.g. SetHelpToken("usage", "h")
+// for --usage and -h.  short is optional.  The tokens are also applied to
+// the parsers of subcommands
+func (this *ArgumentParser) SetHelpToken(token, short string) error {
+	harg := this.helpArgument()
+	if harg == nil {
+		return fmt.Errorf("No help argument")
+	}
+	if len(token) == 0 {
+		return fmt.Errorf("Empty help token")
+	}
+	for _, arg := range this.optArgs {
+		if arg == harg {
+			continue
+		}
+		if utils.IsInStringArray(token, longTokens(arg)) {
+			return fmt.Errorf("%s: Duplicate token --%s of help and %s", this.targetName(), token, arg.Token())
+		}
+		if len(short) > 0 && arg.ShortToken() == short {
+			return fmt.Errorf("%s: Duplicate short token -%s of help and %s", this.targetName(), short, arg.Token())
+		}
+	}
+	harg.token = token
+	harg.short = short
+	this.invalidateTokens()
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for name, data := range subcmd.subcommands {
+			if err := data.parser.SetHelpToken(token, short); err != nil {
+				return fmt.Errorf("subcommand %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// inheritHelp applies the help settings of parent to the parser of a
+// subcommand
+func (this *ArgumentParser) inheritHelp(parent *ArgumentParser) error {
+	if parent.noHelp {
+		this.SetHelpEnabled(false)
+		return nil
+	}
+	if harg := parent.helpArgument(); harg != nil && len(harg.token) > 0 {
+		return this.SetHelpToken(harg.token, harg.short)
+	}
+	return nil
+}
+
+func (this *ArgumentParser) helpArgument() *sHelpArg {
+	return this.tokenIndex().help
+}
+
+// isHelpToken tells whether argStr asks for help, e.g. --help
+func (this *ArgumentParser) isHelpToken(argStr string) bool {
+	if this.noHelp {
+		return false
+	}
+	token, short := "help", ""
+	if harg := this.helpArgument(); harg != nil {
+		token, short = harg.Token(), harg.ShortToken()
+	}
+	return isOption(argStr, "--", token) || (len(short) > 0 && isOption(argStr, "-", short))
+}
+
+// isOption tells whether argStr is token with leading dashes, without
+// concatenating them
+func isOption(argStr string, dashes string, token string) bool {
+	return len(argStr) == len(dashes)+len(token) && strings.HasPrefix(argStr, dashes) && strings.HasSuffix(argStr, token)
+}
+
+// ErrHelp is returned by parses showing help or version instead of parsing,
+// once SetHelpCommand is enabled
+const ErrHelp = errors.Error("help requested")
+
+// HELP_COMMAND is the implicit subcommand showing help, see SetHelpCommand
+const HELP_COMMAND = "help"
+
+// sHelpCommandOptions is the target of the implicit help subcommand
+type sHelpCommandOptions struct {
+	COMMAND []string `help:"Subcommand to show help of"`
+}
+
+// SetHelpCommand controls whether the implicit subcommand help is
+// registered, e.g. prog help server create shows the same help as prog
+// server create --help does, and whether parses showing help or version
+// return ErrHelp instead of nil or errors of the arguments missing, e.g.
+//
+//	if err := parser.ParseArgs(args, false); err == structarg.ErrHelp {
+//		os.Exit(0)
+//	}
+//
+// It is disabled by default.  A subcommand named help of the program is
+// kept as it is.  The setting is also applied to the parsers of subcommands,
+// those with subcommands get their own help subcommand
+func (this *ArgumentParser) SetHelpCommand(enable bool) error {
+	this.helpCommand = enable
+	subcmd := this.GetSubcommand()
+	if subcmd == nil {
+		return nil
+	}
+	for name, data := range subcmd.subcommands {
+		if _, ok := data.parser.Options().(*sHelpCommandOptions); ok {
+			if !enable {
+				delete(subcmd.subcommands, name)
+				for i, choice := range subcmd.choices {
+					if choice == name {
+						subcmd.choices = append(subcmd.choices[:i], subcmd.choices[i+1:]...)
+						break
+					}
+				}
+			}
+			continue
+		}
+		if err := data.parser.SetHelpCommand(enable); err != nil {
+			return fmt.Errorf("subcommand %s: %v", name, err)
+		}
+	}
+	if enable && !subcmd.hasCommand(HELP_COMMAND) {
+		if _, err := subcmd.AddSubParser(&sHelpCommandOptions{}, HELP_COMMAND, "Show help of a subcommand", nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// showCommandHelp shows the help of the subcommands of names, that of the
+// parser if names are empty, for the help subcommand
+func (this *ArgumentParser) showCommandHelp(names []string) error {
+	parser := this
+	for _, name := range names {
+		subcmd := parser.GetSubcommand()
+		if subcmd == nil {
+			return fmt.Errorf("No such command %s", name)
+		}
+		data, ok := subcmd.subcommands[subcmd.canonical(name)]
+		if !ok {
+			return fmt.Errorf("No such command %s", name)
+		}
+		parser = data.parser
+	}
+	fmt.Fprintln(parser.out(), parser.HelpString())
+	this.help = true
+	return nil
+}
+
+// helpShown tells whether help of the parser or of the chosen subcommands is
+// shown by the last parse
+func (this *ArgumentParser) helpShown() bool {
+	parser := this
+	for parser != nil {
+		if parser.help {
+			return true
+		}
+		subcmd := parser.GetSubcommand()
+		if subcmd == nil {
+			break
+		}
+		parser = subcmd.GetSubParser()
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHelpTemplate(t *testing.T) {
+	type Opts struct {
+		Zone string `help:"zone of the server"`
+		Name string `help:"name of the server" group:"Basic"`
+		NAME string `help:"server id"`
+	}
+	t.Run("default", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		want := `Usage: prog [--name NAME] [--help] [--zone ZONE] <NAME>
+
+prog desc
+
+Positional arguments:
+    <NAME>
+        server id
+
+Optional arguments:
+    [--help]
+        Print usage and this help message and exit.
+    [--zone ZONE]
+        zone of the server
+
+Basic:
+    [--name NAME]
+        name of the server
+
+prog epilog
+
+`
+		if got := parser.HelpString(); got != want {
+			t.Errorf("want:\n%q\ngot:\n%q", want, got)
+		}
+	})
+	t.Run("custom", func(t *testing.T) {
+		parser := mustNewParser(t, &struct {
+			Opts
+			SUBCOMMAND string `subcommand:"true"`
+		}{})
+		sub, err := parser.AddSubParser(&struct {
+			Ident string `help:"id"`
+		}{}, "show", "Show", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if err := parser.SetUsageTemplate(`{{.Prog}}{{range .Positionals}} {{.String}}{{end}}` + "\n"); err != nil {
+			t.Fatalf("SetUsageTemplate: %v", err)
+		}
+		err = parser.SetHelpTemplate(`{{.Usage}}{{range .Options}}{{printf "%-16s" .String}}{{.HelpString ""}}
+{{end}}{{range .Groups}}[{{.Name}}]{{end}}
+{{repeat "-" 3}}
+`)
+		if err != nil {
+			t.Fatalf("SetHelpTemplate: %v", err)
+		}
+		want := `prog <NAME> <SUBCOMMAND>
+[--name NAME]   name of the server
+[--help]        Print usage and this help message and exit.
+[--zone ZONE]   zone of the server
+[Basic]
+---
+`
+		if got := parser.HelpString(); got != want {
+			t.Errorf("want:\n%q\ngot:\n%q", want, got)
+		}
+		if got := sub.Usage(); got != "prog show\n" {
+			t.Errorf("subcommand usage: got %q", got)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		if err := parser.SetHelpTemplate("{{.Usage"); err == nil {
+			t.Errorf("expecting error of invalid template")
+		}
+		if err := parser.SetUsageTemplate("{{.NoSuchField}}"); err != nil {
+			t.Fatalf("SetUsageTemplate: %v", err)
+		}
+		if got := parser.Usage(); len(got) == 0 {
+			t.Errorf("expecting error message of template")
+		}
+	})
+}
+
+func TestHelpDecorations(t *testing.T) {
+	type Opts struct {
+		Port       int    `help:"listen port" default:"80" env:"PROG_PORT"`
+		Level      string `help:"log level" choices:"debug|info|warn" default:"info"`
+		Format     string `choices:"json|text"`
+		Url        string `help:"service url" deprecated:"use --endpoint"`
+		Endpoint   string
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	parser := mustNewParser(t, &Opts{})
+	sub, err := parser.AddSubParser(&struct {
+		Limit int `help:"max items" default:"20"`
+	}{}, "list", "List", nil)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	decorated := []string{
+		"        listen port (default: 80) (env: PROG_PORT)\n",
+		"        log level (default: info) (choices: debug|info|warn)\n",
+		"        (choices: json|text)\n",
+		"        service url (deprecated: use --endpoint)\n",
+	}
+	plain := []string{
+		"        listen port\n",
+		"        log level\n",
+		"        service url (deprecated: use --endpoint)\n",
+	}
+	help := parser.HelpString()
+	for _, line := range decorated {
+		if !strings.Contains(help, line) {
+			t.Errorf("missing %q in help:\n%s", line, help)
+		}
+	}
+	if help := sub.HelpString(); !strings.Contains(help, "max items (default: 20)\n") {
+		t.Errorf("subcommand help not decorated:\n%s", help)
+	}
+
+	parser.SetHelpDecorations(false)
+	help = parser.HelpString()
+	for _, line := range plain {
+		if !strings.Contains(help, line) {
+			t.Errorf("missing %q in help:\n%s", line, help)
+		}
+	}
+	if strings.Contains(help, "(default:") || strings.Contains(help, "(choices:") || strings.Contains(help, "(env:") {
+		t.Errorf("unexpected decorations in help:\n%s", help)
+	}
+	if help := sub.HelpString(); !strings.Contains(help, "max items\n") {
+		t.Errorf("subcommand help decorated:\n%s", help)
+	}
+}
+
+func TestHelpToken(t *testing.T) {
+	type Opts struct {
+		Zone string
+		Hash string `short-token:"H"`
+
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	newParser := func(t *testing.T) (*ArgumentParser, *ArgumentParser, *bytes.Buffer) {
+		parser := mustNewParser(t, &Opts{})
+		sub, err := parser.AddSubParser(&struct{}{}, "list", "list", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		var out bytes.Buffer
+		parser.SetOutput(&out)
+		return parser, sub, &out
+	}
+	t.Run("rename", func(t *testing.T) {
+		parser, sub, out := newParser(t)
+		if err := parser.SetHelpToken("usage", "h"); err != nil {
+			t.Fatalf("SetHelpToken: %v", err)
+		}
+		for _, args := range [][]string{{"-h", "list"}, {"--usage", "list"}, {"list", "-h"}} {
+			out.Reset()
+			if err := parser.ParseArgs(args, false); err != nil {
+				t.Fatalf("%v: %v", args, err)
+			}
+			if !parser.IsHelpSet() && !sub.IsHelpSet() || out.Len() == 0 {
+				t.Errorf("%v: want help", args)
+			}
+		}
+		if err := parser.ParseArgs([]string{"--help", "list"}, false); err == nil {
+			t.Errorf("want error of --help")
+		}
+		if usage := parser.Usage(); !strings.Contains(usage, "[--usage|-h]") {
+			t.Errorf("unexpected usage %q", usage)
+		}
+		if usage := sub.Usage(); !strings.Contains(usage, "[--usage|-h]") {
+			t.Errorf("unexpected usage of subcommand %q", usage)
+		}
+	})
+	t.Run("conflict", func(t *testing.T) {
+		parser, _, _ := newParser(t)
+		for _, tokens := range [][]string{{"zone", ""}, {"info", "H"}, {"", "h"}} {
+			if err := parser.SetHelpToken(tokens[0], tokens[1]); err == nil {
+				t.Errorf("%v: want error", tokens)
+			}
+		}
+	})
+	t.Run("disable", func(t *testing.T) {
+		parser, sub, out := newParser(t)
+		parser.SetHelpEnabled(false)
+		for _, args := range [][]string{{"--help"}, {"list", "--help"}} {
+			if err := parser.ParseArgs(args, false); err == nil {
+				t.Errorf("%v: want error", args)
+			}
+		}
+		if out.Len() > 0 || strings.Contains(parser.Usage(), "--help") || strings.Contains(sub.Usage(), "--help") {
+			t.Errorf("unexpected help %q", out.String())
+		}
+		for _, arg := range parser.GetOptArgs() {
+			if arg.Token() == "help" {
+				t.Errorf("unexpected help argument")
+			}
+		}
+		parser.SetHelpEnabled(true)
+		if err := parser.ParseArgs([]string{"list", "--help"}, false); err != nil || !sub.IsHelpSet() {
+			t.Errorf("want help of subcommand, err %v", err)
+		}
+	})
+	t.Run("inherit", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		if err := parser.SetHelpToken("usage", ""); err != nil {
+			t.Fatalf("SetHelpToken: %v", err)
+		}
+		sub, err := parser.AddSubParser(&struct{}{}, "list", "list", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if usage := sub.Usage(); !strings.Contains(usage, "[--usage]") {
+			t.Errorf("unexpected usage of subcommand %q", usage)
+		}
+	})
+}
+
+func TestHelpCommand(t *testing.T) {
+	type Opts struct {
+		Zone       string `required:"true"`
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	type ServerOpts struct {
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	newParser := func(t *testing.T) (*ArgumentParser, *bytes.Buffer) {
+		var out bytes.Buffer
+		parser, err := NewArgumentParserWithOptions(&Opts{}, WithProg("prog"), WithOutput(&out), WithHelpCommand())
+		if err != nil {
+			t.Fatalf("NewArgumentParserWithOptions: %v", err)
+		}
+		if _, err := parser.AddSubParser(&struct{ NAME string }{}, "create", "create", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		server, err := parser.AddSubParser(&ServerOpts{}, "server", "server", nil)
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if _, err := server.AddSubParser(&struct{ ID string }{}, "start", "start", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		return parser, &out
+	}
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"help", "create"}, "Usage: prog create"},
+		{[]string{"create", "--help"}, "Usage: prog create"},
+		{[]string{"help", "server", "start"}, "Usage: prog server start"},
+		{[]string{"server", "help", "start"}, "Usage: prog server start"},
+		{[]string{"help"}, "Usage: prog"},
+		{[]string{"--help"}, "Usage: prog"},
+	}
+	for _, c := range cases {
+		parser, out := newParser(t)
+		if err := parser.ParseArgs(c.args, false); err != ErrHelp {
+			t.Errorf("%v: want ErrHelp, got %v", c.args, err)
+		}
+		if !strings.HasPrefix(out.String(), c.want) {
+			t.Errorf("%v: want help %q, got:\n%s", c.args, c.want, out.String())
+		}
+	}
+
+	t.Run("listed", func(t *testing.T) {
+		parser, _ := newParser(t)
+		if help := parser.HelpString(); !strings.Contains(help, "Show help of a subcommand") {
+			t.Errorf("help subcommand not listed:\n%s", help)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		parser, _ := newParser(t)
+		if err := parser.ParseArgs([]string{"help", "delete"}, false); err == nil || !strings.Contains(err.Error(), "No such command delete") {
+			t.Errorf("want error of no such command, got %v", err)
+		}
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		parser, _ := newParser(t)
+		if err := parser.SetHelpCommand(false); err != nil {
+			t.Fatalf("SetHelpCommand: %v", err)
+		}
+		if err := parser.ParseArgs([]string{"--zone", "z1", "help", "create"}, false); err == nil {
+			t.Errorf("want error of unknown subcommand help")
+		}
+		if err := parser.ParseArgs([]string{"--zone", "z1", "create", "--help"}, false); err == ErrHelp {
+			t.Errorf("unexpected ErrHelp")
+		}
+	})
+}
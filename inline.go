@@ -0,0 +1,128 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// parseInlineDict parses a JSON or YAML document of an object given as the
+// value of a single option
+func parseInlineDict(token string, val string) (*jsonutils.JSONDict, error) {
+	obj, err := jsonutils.ParseYAML(val)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse value of %s as JSON or YAML: %v", token, err)
+	}
+	dict, ok := obj.(*jsonutils.JSONDict)
+	if !ok {
+		return nil, fmt.Errorf("Expecting JSON or YAML object for %s, got %s", token, val)
+	}
+	return dict, nil
+}
+
+// isInlineDict tells whether the value of map argument is a document instead
+// of key value pair, e.g. {"group":"anti-affinity"}
+func isInlineDict(val string) bool {
+	return strings.HasPrefix(strings.TrimSpace(val), "{")
+}
+
+// setInlineDict sets the key values of a document to map argument
+func (this *MultiArgument) setInlineDict(val string) error {
+	dict, err := parseInlineDict(this.Token(), val)
+	if err != nil {
+		return err
+	}
+	mapJson, err := dict.GetMap()
+	if err != nil {
+		return err
+	}
+	for k, v := range mapJson {
+		str, err := v.GetString()
+		if err != nil {
+			return err
+		}
+		if err := this.setMapIndex(k, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sInlineArgument takes a JSON or YAML document for the options of a nested
+// struct as a whole, e.g. --scheduler-hints '{"group":"anti-affinity"}' sets
+// --scheduler-hints-group.  Keys of the document map onto the options with
+// the prefix the same way as those of configuration files do, values already
+// given are overwritten
+type sInlineArgument struct {
+	SingleArgument
+	// prefix of the options in kebab-case, e.g. scheduler-hints-
+	prefix string
+}
+
+// isInlineDocument tells whether val is given as a document rather than a
+// value of an option, i.e. a JSON object or array, or a YAML mapping
+func isInlineDocument(val string) bool {
+	if trimmed := strings.TrimSpace(val); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return true
+	}
+	obj, err := jsonutils.ParseYAML(val)
+	if err != nil {
+		return false
+	}
+	_, ok := obj.(*jsonutils.JSONDict)
+	return ok
+}
+
+// findInlineArgument finds the document argument of token, which is the
+// prefix of a nested struct but not a token itself.  Unless val is a
+// document, the abbreviation of a token of the members takes precedence,
+// e.g. --db x for --db-host
+func (this *ArgumentParser) findInlineArgument(token string, val string) Argument {
+	if _, ok := this.tokenIndex().tokens[token]; ok || len(token) == 0 {
+		return nil
+	}
+	for _, prefix := range this.structPrefixes {
+		// in kebab-case, e.g. db of db-host
+		kebab := splitCamelString(strings.TrimSuffix(prefix, "-"))
+		if this.namedToken(kebab) != token {
+			continue
+		}
+		if !isInlineDocument(val) {
+			if arg, _, err := this.matchOptionalArgument(token); err == nil && arg != nil {
+				return nil
+			}
+		}
+		return &sInlineArgument{SingleArgument: SingleArgument{token: token, parser: this}, prefix: kebab + "-"}
+	}
+	return nil
+}
+
+func (self *sInlineArgument) NeedData() bool {
+	return true
+}
+
+func (self *sInlineArgument) SetValue(val string) error {
+	dict, err := parseInlineDict(self.token, val)
+	if err != nil {
+		return err
+	}
+	if err := self.parser.checkJSONDictKeys(self.prefix, dict); err != nil {
+		return err
+	}
+	return self.parser.parseJSONDictWithPrefix(self.prefix, dict, true, true)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInlineValues(t *testing.T) {
+	type Options struct {
+		SchedulerHints struct {
+			Group    string
+			MaxCount int
+			Tags     []string
+			Affinity struct {
+				Zone string
+			}
+		}
+		Labels map[string]string
+	}
+
+	t.Run("json", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		args := []string{"--scheduler-hints-group", "a", "--scheduler-hints-tags", "x",
+			"--scheduler-hints", `{"group":"anti-affinity","max_count":3,"tags":["y","z"],"affinity":{"zone":"z1"}}`}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		hints := s.SchedulerHints
+		if hints.Group != "anti-affinity" || hints.MaxCount != 3 || hints.Affinity.Zone != "z1" {
+			t.Errorf("unexpected hints %#v", hints)
+		}
+		if want := []string{"y", "z"}; !reflect.DeepEqual(hints.Tags, want) {
+			t.Errorf("tags: want %v, got %v", want, hints.Tags)
+		}
+		if !p.IsSet("scheduler-hints-group") || p.Source("scheduler-hints-group") != SOURCE_COMMAND_LINE {
+			t.Errorf("scheduler-hints-group is not set from command line")
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		args := []string{"--scheduler-hints=affinity: {zone: z2}", "--scheduler-hints-group", "b"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.SchedulerHints.Affinity.Zone != "z2" || s.SchedulerHints.Group != "b" {
+			t.Errorf("unexpected hints %#v", s.SchedulerHints)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		args := []string{"--labels", "a=1", "--labels", `{"b":"2","c":3}`}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if want := map[string]string{"a": "1", "b": "2", "c": "3"}; !reflect.DeepEqual(s.Labels, want) {
+			t.Errorf("labels: want %v, got %v", want, s.Labels)
+		}
+	})
+
+	t.Run("abbreviation", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--scheduler-hints-gr", "c"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.SchedulerHints.Group != "c" {
+			t.Errorf("unexpected hints %#v", s.SchedulerHints)
+		}
+		db := &struct {
+			Db struct {
+				Host string
+			}
+		}{}
+		p = mustNewParser(t, db)
+		if err := p.ParseArgs([]string{"--db", "x"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if db.Db.Host != "x" {
+			t.Errorf("--db x: want host x, got %q", db.Db.Host)
+		}
+		if err := p.ParseArgs([]string{"--db=host: db1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if db.Db.Host != "db1" {
+			t.Errorf("--db=host: db1: want host db1, got %q", db.Db.Host)
+		}
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		// prefixes of scalar options are abbreviations only
+		s := &struct {
+			AuthURL string
+			POS     string
+		}{}
+		p := mustNewParser(t, s)
+		for _, val := range []string{"a: b", `{"url":"u"}`} {
+			if err := p.ParseArgs([]string{"--auth", val, "p"}, false); err != nil {
+				t.Fatalf("ParseArgs %q: %v", val, err)
+			}
+			if s.AuthURL != val {
+				t.Errorf("want auth-url %q, got %q", val, s.AuthURL)
+			}
+		}
+	})
+
+	errCases := []struct {
+		name string
+		args []string
+		msg  string
+	}{
+		{"unknown key", []string{"--scheduler-hints", `{"size":1}`}, "scheduler-hints-size"},
+		{"not object", []string{"--scheduler-hints", `["a"]`}, "Expecting JSON or YAML object"},
+		{"invalid", []string{"--scheduler-hints", `{"group":`}, "Cannot parse value of scheduler-hints"},
+		{"invalid value", []string{"--scheduler-hints", `{"max_count":"x"}`}, "max_count"},
+		{"map invalid", []string{"--labels", `{"a":`}, "labels"},
+	}
+	for _, c := range errCases {
+		t.Run(c.name, func(t *testing.T) {
+			p := mustNewParser(t, &Options{})
+			err := p.ParseArgs(c.args, false)
+			if err == nil || !strings.Contains(err.Error(), c.msg) {
+				t.Errorf("expecting error of %q, got %v", c.msg, err)
+			}
+		})
+	}
+}
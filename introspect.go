@@ -0,0 +1,89 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"strings"
+)
+
+// ArgumentInfo describes an argument for tools like document generators and
+// web forms, see Arguments
+type ArgumentInfo struct {
+	Token         string
+	ShortToken    string
+	AliasTokens   []string
+	NegativeToken string
+	MetaVar       string
+	// Type of the value, e.g. "[]string", empty for --help and --version
+	Type string
+	// Help as in the help tag, without decorations
+	Help string
+	// Default as in the default tag, empty if there is none, SECRET_MASK
+	// if Secret
+	Default    string
+	Choices    []string
+	EnvName    string
+	Group      string
+	Deprecated string
+	Positional bool
+	Required   bool
+	Multi      bool
+	Hidden     bool
+	Subcommand bool
+	// Secret values are not to be shown, see TAG_SECRET
+	Secret bool
+}
+
+// Arguments returns the descriptions of the arguments, positional ones
+// first, then optional ones in order of help.  Hidden arguments are
+// included with Hidden set.  Subcommands are described by the parsers of
+// GetSubcommand
+func (this *ArgumentParser) Arguments() []ArgumentInfo {
+	infos := make([]ArgumentInfo, 0, len(this.posArgs)+len(this.optArgs))
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			infos = append(infos, argumentInfo(arg))
+		}
+	}
+	return infos
+}
+
+func argumentInfo(arg Argument) ArgumentInfo {
+	info := ArgumentInfo{
+		Token:         arg.Token(),
+		ShortToken:    arg.ShortToken(),
+		AliasTokens:   argumentAliasTokens(arg),
+		NegativeToken: arg.NegativeToken(),
+		MetaVar:       arg.MetaVar(),
+		Choices:       argumentChoices(arg),
+		EnvName:       argumentEnvName(arg),
+		Group:         argumentGroup(arg),
+		Deprecated:    argumentDeprecated(arg),
+		Positional:    arg.IsPositional(),
+		Required:      arg.IsRequired(),
+		Multi:         arg.IsMulti(),
+		Hidden:        isHiddenArgument(arg),
+		Subcommand:    arg.IsSubcommand(),
+		Secret:        isSecretArgument(arg),
+	}
+	if darg, ok := arg.(documentedArgument); ok {
+		info.Type = darg.typeName()
+		info.Default = darg.defaultString()
+		info.Help = darg.helpText()
+	} else {
+		info.Help = strings.TrimSpace(arg.HelpString(""))
+	}
+	return info
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArguments(t *testing.T) {
+	type Options struct {
+		Zone   string   `help:"zone of the server" default:"z1" choices:"z1|z2" short-token:"z" env:"ZONE"`
+		Tags   []string `metavar:"TAG" group:"Basic"`
+		Secret string   `hidden:"true"`
+		NAME   string   `help:"server name"`
+	}
+	p := mustNewParser(t, &Options{})
+	infos := p.Arguments()
+	if len(infos) != 5 {
+		t.Fatalf("want 5 arguments, got %#v", infos)
+	}
+	want := ArgumentInfo{Token: "name", MetaVar: "NAME", Type: "string", Help: "server name", Positional: true, Required: true}
+	if !reflect.DeepEqual(infos[0], want) {
+		t.Errorf("want %#v, got %#v", want, infos[0])
+	}
+	byToken := make(map[string]ArgumentInfo)
+	for _, info := range infos[1:] {
+		byToken[info.Token] = info
+	}
+	for _, want := range []ArgumentInfo{
+		{Token: "zone", ShortToken: "z", MetaVar: "{z1,z2}", Type: "string", Help: "zone of the server", Default: "z1", Choices: []string{"z1", "z2"}, EnvName: "ZONE"},
+		{Token: "tags", MetaVar: "TAG", Type: "[]string", Group: "Basic", Multi: true},
+		{Token: "secret", MetaVar: "SECRET", Type: "string", Hidden: true},
+		{Token: "help", Help: "Print usage and this help message and exit."},
+	} {
+		if got := byToken[want.Token]; !reflect.DeepEqual(got, want) {
+			t.Errorf("want %#v, got %#v", want, got)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/nyl1001/pkg/gotypes"
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// isStructType tells whether fields of tp are walked into as nested options
+func isStructType(tp reflect.Type) bool {
+	return tp.Kind() == reflect.Struct && tp != gotypes.TimeType && !isScalarType(tp)
+}
+
+// itemStructType returns the struct type of elements of slices of structs
+// or pointers to structs, nil for the other types
+func itemStructType(tp reflect.Type) reflect.Type {
+	if tp.Kind() != reflect.Slice || isScalarType(tp) {
+		return nil
+	}
+	elem := tp.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if !isStructType(elem) {
+		return nil
+	}
+	return elem
+}
+
+// initStructItems builds the parser of elements of slices of structs, e.g.
+// []SDisk, whose elements are given either by indexed tokens, e.g.
+// --disk.0.size 10G --disk.0.fs ext4 --disk.1.size 20G, or by a JSON object
+// per occurrence, e.g. --disk '{"size":"10G","fs":"ext4"}'
+func (this *MultiArgument) initStructItems() error {
+	if this.parseFunc.IsValid() {
+		return nil
+	}
+	tp := itemStructType(this.value.Type())
+	if tp == nil {
+		return nil
+	}
+	if this.unique {
+		return fmt.Errorf("unique is not applicable to slice of structs")
+	}
+	template, err := newArgumentParser(reflect.New(tp).Interface(), "", "", "")
+	if err != nil {
+		return err
+	}
+	template.SetHelpEnabled(false)
+	// built ahead as the template is shared by clones of the parser
+	template.tokenIndex()
+	this.itemTemplate = template
+	return nil
+}
+
+// newItem appends the parser of a new element, with fields set to their
+// defaults
+func (this *MultiArgument) newItem() (*ArgumentParser, error) {
+	tp := this.itemTemplate.target
+	item, err := newArgumentParser(reflect.New(reflect.TypeOf(tp).Elem()).Interface(), "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	item.SetHelpEnabled(false)
+	item.SetDefault()
+	if len(this.items) == 0 {
+		this.startValue()
+		this.itemBase = this.value.Len()
+	}
+	this.items = append(this.items, item)
+	return item, nil
+}
+
+// syncItems sets the value to the elements before the first one given,
+// followed by the elements parsed so far
+func (this *MultiArgument) syncItems() {
+	value := reflect.MakeSlice(this.value.Type(), 0, this.itemBase+len(this.items))
+	value = reflect.AppendSlice(value, this.value.Slice(0, this.itemBase))
+	for _, item := range this.items {
+		elem := reflect.ValueOf(item.target)
+		if this.value.Type().Elem().Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		value = reflect.Append(value, elem)
+	}
+	this.value.Set(value)
+	this.markSet()
+}
+
+// addStructItem appends an element given as a JSON object
+func (this *MultiArgument) addStructItem(val string) error {
+	obj, err := jsonutils.ParseString(val)
+	if err != nil {
+		return fmt.Errorf("Cannot parse %s to %s: %v", val, this.value.Type().Elem(), err)
+	}
+	dict, ok := obj.(*jsonutils.JSONDict)
+	if !ok {
+		return fmt.Errorf("Expecting JSON object for %s, got %s", this.Token(), val)
+	}
+	item, err := this.newItem()
+	if err != nil {
+		return err
+	}
+	if err := item.parseJSONDictWithPrefix("", dict, true, false); err != nil {
+		return err
+	}
+	this.syncItems()
+	return nil
+}
+
+// clearValue empties the value, e.g. before being replaced by configuration
+func (this *MultiArgument) clearValue() {
+	this.value.Set(reflect.Zero(this.value.Type()))
+	this.items = nil
+	this.itemBase = 0
+}
+
+func (this *MultiArgument) Reset() {
+	this.items = nil
+	this.itemBase = 0
+	this.SingleArgument.Reset()
+}
+
+// validateStructItems validates the fields of each element given
+func (this *MultiArgument) validateStructItems() error {
+	for i, item := range this.items {
+		if err := item.Validate(); err != nil {
+			return fmt.Errorf("item %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// sItemArgument is the field of an element of slice of structs addressed by
+// indexed token, e.g. --disk.0.size.  It describes the field of the template
+// and sets the field of the element of index
+type sItemArgument struct {
+	Argument
+	marg  *MultiArgument
+	index int
+	field string
+}
+
+// findItemArgument finds the field of element addressed by token of form
+// slice-token.index.field-token
+func (this *ArgumentParser) findItemArgument(token string) (Argument, bool) {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		return nil, false
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		return nil, false
+	}
+	match, ok := this.tokenIndex().tokens[parts[0]]
+	if !ok || match.negative {
+		return nil, false
+	}
+	marg, ok := match.arg.(*MultiArgument)
+	if !ok || marg.itemTemplate == nil {
+		return nil, false
+	}
+	field := parts[2]
+	if this.naming != nil {
+		// fields of elements keep tokens in kebab-case
+		field = splitCamelString(field)
+	}
+	arg, nega := marg.itemTemplate.findOptionalArgument(field, true)
+	if arg == nil {
+		return nil, false
+	}
+	return &sItemArgument{Argument: arg, marg: marg, index: index, field: field}, nega
+}
+
+func (self *sItemArgument) Token() string {
+	return fmt.Sprintf("%s.%d.%s", self.marg.Token(), self.index, self.Argument.Token())
+}
+
+// itemArgument returns the field of the element, which is appended if index
+// is right after the last element
+func (self *sItemArgument) itemArgument() (Argument, error) {
+	marg := self.marg
+	if self.index > len(marg.items) {
+		return nil, fmt.Errorf("Index %d of %s out of order, expecting %d", self.index, marg.Token(), len(marg.items))
+	}
+	if self.index == len(marg.items) {
+		if _, err := marg.newItem(); err != nil {
+			return nil, err
+		}
+	}
+	arg, _ := marg.items[self.index].findOptionalArgument(self.field, true)
+	return arg, nil
+}
+
+func (self *sItemArgument) SetValue(val string) error {
+	arg, err := self.itemArgument()
+	if err != nil {
+		return err
+	}
+	if err := arg.SetValue(val); err != nil {
+		return err
+	}
+	self.marg.syncItems()
+	return nil
+}
+
+func (self *sItemArgument) DoAction(nega bool) error {
+	arg, err := self.itemArgument()
+	if err != nil {
+		return err
+	}
+	if err := arg.DoAction(nega); err != nil {
+		return err
+	}
+	self.marg.syncItems()
+	return nil
+}
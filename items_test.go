@@ -0,0 +1,136 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+type testDisk struct {
+	Size string `required:"true"`
+	Fs   string `default:"ext4" choices:"ext4|xfs"`
+	Ssd  bool
+}
+
+func TestStructItems(t *testing.T) {
+	type Options struct {
+		Disk []testDisk
+		Nic  []*struct {
+			Network string
+		}
+	}
+	cases := []struct {
+		name  string
+		args  []string
+		disks []testDisk
+	}{
+		{
+			name: "indexed",
+			args: []string{"--disk.0.size", "10G", "--disk.0.fs", "xfs", "--disk.0.ssd", "--disk.1.size=20G"},
+			disks: []testDisk{
+				{Size: "10G", Fs: "xfs", Ssd: true},
+				{Size: "20G", Fs: "ext4"},
+			},
+		},
+		{
+			name: "json",
+			args: []string{"--disk", `{"size":"10G","ssd":true}`, "--disk", `{"size":"20G","fs":"xfs"}`},
+			disks: []testDisk{
+				{Size: "10G", Fs: "ext4", Ssd: true},
+				{Size: "20G", Fs: "xfs"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Options{}
+			p := mustNewParser(t, s)
+			if err := p.ParseArgs(c.args, false); err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if !reflect.DeepEqual(s.Disk, c.disks) {
+				t.Errorf("disks: want %#v, got %#v", c.disks, s.Disk)
+			}
+			if !p.IsSet("disk") {
+				t.Errorf("disk is not set")
+			}
+		})
+	}
+
+	t.Run("pointers", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--nic.0.network", "a", "--nic", `{"network":"b"}`}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if len(s.Nic) != 2 || s.Nic[0].Network != "a" || s.Nic[1].Network != "b" {
+			t.Errorf("unexpected nics %#v", s.Nic)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		conf := jsonutils.Marshal(map[string]interface{}{
+			"disk": []map[string]string{{"size": "30G"}},
+		}).(*jsonutils.JSONDict)
+		if err := p.parseJSONDict(conf); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		if want := []testDisk{{Size: "30G", Fs: "ext4"}}; !reflect.DeepEqual(s.Disk, want) {
+			t.Errorf("disks: want %#v, got %#v", want, s.Disk)
+		}
+	})
+
+	t.Run("reparse", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--disk.0.size", "10G", "--disk.1.size", "20G"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p.ParseArgs([]string{"--disk.0.size", "30G"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if want := []testDisk{{Size: "30G", Fs: "ext4"}}; !reflect.DeepEqual(s.Disk, want) {
+			t.Errorf("disks: want %#v, got %#v", want, s.Disk)
+		}
+	})
+
+	errCases := []struct {
+		name string
+		args []string
+		msg  string
+	}{
+		{"out of order", []string{"--disk.1.size", "10G"}, "out of order"},
+		{"unknown field", []string{"--disk.0.type", "hdd"}, "disk.0.type"},
+		{"invalid choice", []string{"--disk.0.size", "10G", "--disk.0.fs", "fat"}, "fat"},
+		{"missing field", []string{"--disk.0.fs", "xfs"}, "item 0"},
+		{"not object", []string{"--disk", `["10G"]`}, "Expecting JSON object"},
+		{"unknown key", []string{"--disk", `{"type":"hdd"}`}, "type"},
+	}
+	for _, c := range errCases {
+		t.Run(c.name, func(t *testing.T) {
+			p := mustNewParser(t, &Options{})
+			err := p.ParseArgs(c.args, false)
+			if err == nil || !strings.Contains(err.Error(), c.msg) {
+				t.Errorf("expecting error of %q, got %v", c.msg, err)
+			}
+		})
+	}
+}
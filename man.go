@@ -0,0 +1,158 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GenerateManPage writes a man page of the parser in roff format to w, e.g.
+// section 1 for commands and 8 for daemons.  The page has the sections NAME,
+// SYNOPSIS, DESCRIPTION, ARGUMENTS, COMMANDS, OPTIONS, ENVIRONMENT and NOTES
+// of the epilog, those without content are omitted
+func (this *ArgumentParser) GenerateManPage(w io.Writer, section int) error {
+	if section < 1 || section > 9 {
+		return fmt.Errorf("Invalid man page section %d", section)
+	}
+	prog := strings.Fields(this.prog)
+	if len(prog) == 0 {
+		return fmt.Errorf("Empty prog name")
+	}
+	name := strings.Join(prog, "-")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, ".TH \"%s\" \"%d\"\n", strings.ToUpper(roffEscape(name)), section)
+	buf.WriteString(".SH NAME\n")
+	buf.WriteString(roffEscape(name))
+	if desc := this.ShortDescription(); len(desc) > 0 {
+		fmt.Fprintf(&buf, " \\- %s", roffEscape(desc))
+	}
+	buf.WriteByte('\n')
+
+	data := this.helpData()
+	buf.WriteString(".SH SYNOPSIS\n")
+	fmt.Fprintf(&buf, ".B %s\n", roffEscape(this.prog))
+	for _, arg := range data.Options {
+		fmt.Fprintf(&buf, "%s\n", roffLine(arg.String()))
+	}
+	for _, arg := range data.Positionals {
+		str := arg.String()
+		if arg.IsSubcommand() || arg.IsMulti() {
+			str += " ..."
+		}
+		fmt.Fprintf(&buf, "%s\n", roffLine(str))
+	}
+	if len(this.description) > 0 {
+		buf.WriteString(".SH DESCRIPTION\n")
+		writeRoffText(&buf, this.description)
+	}
+
+	var positionals []Argument
+	var subcmd *SubcommandArgument
+	for _, arg := range data.Positionals {
+		if arg.IsSubcommand() {
+			subcmd = arg.(*SubcommandArgument)
+		} else {
+			positionals = append(positionals, arg)
+		}
+	}
+	if len(positionals) > 0 {
+		buf.WriteString(".SH ARGUMENTS\n")
+		for _, arg := range positionals {
+			fmt.Fprintf(&buf, ".TP\n.I %s\n", roffEscape(arg.MetaVar()))
+			writeRoffText(&buf, arg.HelpString(""))
+		}
+	}
+	if subcmd != nil {
+		buf.WriteString(".SH COMMANDS\n")
+		for _, name := range subcmd.subcommandNames() {
+			sub := subcmd.subcommands[name]
+			names := append([]string{name}, sub.aliases...)
+			fmt.Fprintf(&buf, ".TP\n.B %s\n", roffEscape(strings.Join(names, ", ")))
+			writeRoffText(&buf, sub.parser.ShortDescription())
+		}
+	}
+	if len(data.Options) > 0 {
+		buf.WriteString(".SH OPTIONS\n")
+		writeRoffOptions(&buf, data.Optionals)
+		for _, group := range data.Groups {
+			fmt.Fprintf(&buf, ".SS %s\n", roffEscape(group.Name))
+			writeRoffOptions(&buf, group.Arguments)
+		}
+	}
+	var envs []Argument
+	for _, arg := range data.Options {
+		if len(argumentEnvName(arg)) > 0 {
+			envs = append(envs, arg)
+		}
+	}
+	if len(envs) > 0 {
+		buf.WriteString(".SH ENVIRONMENT\n")
+		for _, arg := range envs {
+			fmt.Fprintf(&buf, ".TP\n.B %s\n", roffEscape(argumentEnvName(arg)))
+			fmt.Fprintf(&buf, "Sets \\fB%s\\fR.\n", roffEscape("--"+arg.Token()))
+		}
+	}
+	if len(this.epilog) > 0 {
+		buf.WriteString(".SH NOTES\n")
+		writeRoffText(&buf, this.epilog)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeRoffOptions(buf *bytes.Buffer, args []Argument) {
+	for _, arg := range args {
+		tokens := argumentTokens(arg)
+		for i := range tokens {
+			tokens[i] = "\\fB" + roffEscape(tokens[i]) + "\\fR"
+		}
+		fmt.Fprintf(buf, ".TP\n%s", strings.Join(tokens, ", "))
+		if arg.NeedData() {
+			fmt.Fprintf(buf, " \\fI%s\\fR", roffEscape(arg.MetaVar()))
+		}
+		buf.WriteByte('\n')
+		writeRoffText(buf, arg.HelpString(""))
+	}
+}
+
+// writeRoffText writes paragraphs of text, blank lines separate paragraphs
+func writeRoffText(buf *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			buf.WriteString(".PP\n")
+			continue
+		}
+		buf.WriteString(roffLine(line))
+		buf.WriteByte('\n')
+	}
+}
+
+// roffLine escapes a line of text, guarding leading control characters
+func roffLine(line string) string {
+	line = roffEscape(line)
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		line = "\\&" + line
+	}
+	return line
+}
+
+func roffEscape(str string) string {
+	str = strings.Replace(str, "\\", "\\e", -1)
+	return strings.Replace(str, "-", "\\-", -1)
+}
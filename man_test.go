@@ -0,0 +1,97 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateManPage(t *testing.T) {
+	parser, err := NewArgumentParser(&struct {
+		Zone       string `help:"zone of the server" default:"z1" env:"ZONE"`
+		Level      string `choices:"debug|info" short-token:"l"`
+		Name       string `group:"Basic" help:"a \\ b\n.line2"`
+		Secret     string `hidden:"true"`
+		FILE       string `help:"input file"`
+		SUBCOMMAND string `subcommand:"true"`
+	}{}, "prog", "Manage servers\n\nLong description.", "See also x-y.")
+	if err != nil {
+		t.Fatalf("NewArgumentParser: %v", err)
+	}
+	if _, err := parser.AddSubParser(&struct{}{}, "list", "List items", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := parser.GetSubcommand().AddAliases("list", "ls"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := parser.GenerateManPage(&buf, 1); err != nil {
+		t.Fatalf("GenerateManPage: %v", err)
+	}
+	want := `.TH "PROG" "1"
+.SH NAME
+prog \- Manage servers
+.SH SYNOPSIS
+.B prog
+[\-\-level|\-l {debug,info}]
+[\-\-name NAME]
+[\-\-help]
+[\-\-zone ZONE]
+<FILE>
+<SUBCOMMAND> ...
+.SH DESCRIPTION
+Manage servers
+.PP
+Long description.
+.SH ARGUMENTS
+.TP
+.I FILE
+input file
+.SH COMMANDS
+.TP
+.B list, ls
+List items
+.SH OPTIONS
+.TP
+\fB\-\-level\fR, \fB\-l\fR \fI{debug,info}\fR
+(choices: debug|info)
+.TP
+\fB\-\-help\fR
+Print usage and this help message and exit.
+.TP
+\fB\-\-zone\fR \fIZONE\fR
+zone of the server (default: z1) (env: ZONE)
+.SS Basic
+.TP
+\fB\-\-name\fR \fINAME\fR
+a \e b
+\&.line2
+.SH ENVIRONMENT
+.TP
+.B ZONE
+Sets \fB\-\-zone\fR.
+.SH NOTES
+See also x\-y.
+`
+	if got := buf.String(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+	for _, section := range []int{0, 10} {
+		if err := parser.GenerateManPage(&buf, section); err == nil {
+			t.Errorf("section %d: expecting error", section)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// documentedArgument provides the details of arguments for generated
+// documents
+type documentedArgument interface {
+	typeName() string
+	defaultString() string
+	plainHelp() string
+	helpText() string
+}
+
+func (this *SingleArgument) typeName() string {
+	return this.value.Type().String()
+}
+
+func (this *SingleArgument) defaultString() string {
+	if !this.useDefault {
+		return ""
+	}
+	return this.shownValue(this.defaultStr)
+}
+
+func (this *SingleArgument) helpText() string {
+	return this.help
+}
+
+// plainHelp returns the help with environment variable and deprecation,
+// but not defaults and choices which are documented separately
+func (this *SingleArgument) plainHelp() string {
+	help := this.help
+	if envName := this.EnvName(); len(envName) > 0 {
+		help = strings.TrimLeft(fmt.Sprintf("%s (env: %s)", help, envName), " ")
+	}
+	if len(this.deprecated) > 0 {
+		help = strings.TrimLeft(fmt.Sprintf("%s (deprecated: %s)", help, this.deprecated), " ")
+	}
+	return help
+}
+
+// GenerateMarkdown writes a reference page of the parser in Markdown to w,
+// with synopsis, tables of positional and optional arguments, and a section
+// for each subcommand
+func (this *ArgumentParser) GenerateMarkdown(w io.Writer) error {
+	var buf bytes.Buffer
+	this.writeMarkdown(&buf, 1)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func markdownHeading(level int) string {
+	if level > 6 {
+		level = 6
+	}
+	return strings.Repeat("#", level)
+}
+
+func (this *ArgumentParser) writeMarkdown(buf *bytes.Buffer, level int) {
+	heading := markdownHeading(level)
+	section := markdownHeading(level + 1)
+	fmt.Fprintf(buf, "%s %s\n\n", heading, this.prog)
+	if len(this.description) > 0 {
+		buf.WriteString(this.description)
+		buf.WriteString("\n\n")
+	}
+	fmt.Fprintf(buf, "%s Synopsis\n\n```\n%s\n```\n\n", section, strings.TrimSpace(this.Usage()))
+
+	data := this.helpData()
+	var positionals []Argument
+	var subcmd *SubcommandArgument
+	for _, arg := range data.Positionals {
+		if arg.IsSubcommand() {
+			subcmd = arg.(*SubcommandArgument)
+		} else {
+			positionals = append(positionals, arg)
+		}
+	}
+	if len(positionals) > 0 {
+		fmt.Fprintf(buf, "%s Positional arguments\n\n", section)
+		buf.WriteString("| Name | Type | Description |\n|------|------|-------------|\n")
+		for _, arg := range positionals {
+			typeName, _, help := markdownArgument(arg)
+			fmt.Fprintf(buf, "| `%s` | %s | %s |\n", arg.MetaVar(), typeName, help)
+		}
+		buf.WriteByte('\n')
+	}
+	if subcmd != nil {
+		fmt.Fprintf(buf, "%s Subcommands\n\n", section)
+		for _, name := range subcmd.subcommandNames() {
+			sub := subcmd.subcommands[name]
+			fmt.Fprintf(buf, "- `%s`", name)
+			if len(sub.aliases) > 0 {
+				fmt.Fprintf(buf, " (%s)", strings.Join(sub.aliases, ", "))
+			}
+			if desc := sub.parser.ShortDescription(); len(desc) > 0 {
+				fmt.Fprintf(buf, ": %s", desc)
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteByte('\n')
+	}
+	if len(data.Optionals) > 0 {
+		fmt.Fprintf(buf, "%s Options\n\n", section)
+		writeMarkdownOptions(buf, data.Optionals)
+	}
+	for _, group := range data.Groups {
+		fmt.Fprintf(buf, "%s %s\n\n", section, group.Name)
+		writeMarkdownOptions(buf, group.Arguments)
+	}
+	if len(this.epilog) > 0 {
+		buf.WriteString(this.epilog)
+		buf.WriteString("\n\n")
+	}
+	if subcmd != nil {
+		for _, name := range subcmd.subcommandNames() {
+			subcmd.subcommands[name].parser.writeMarkdown(buf, level+1)
+		}
+	}
+}
+
+func writeMarkdownOptions(buf *bytes.Buffer, args []Argument) {
+	buf.WriteString("| Option | Type | Default | Choices | Description |\n")
+	buf.WriteString("|--------|------|---------|---------|-------------|\n")
+	for _, arg := range args {
+		tokens := argumentTokens(arg)
+		for i := range tokens {
+			tokens[i] = "`" + tokens[i] + "`"
+		}
+		typeName, defval, help := markdownArgument(arg)
+		if len(defval) > 0 {
+			defval = "`" + markdownEscape(defval) + "`"
+		}
+		choices := argumentChoices(arg)
+		for i := range choices {
+			choices[i] = "`" + markdownEscape(choices[i]) + "`"
+		}
+		fmt.Fprintf(buf, "| %s | %s | %s | %s | %s |\n", strings.Join(tokens, ", "), typeName, defval, strings.Join(choices, ", "), help)
+	}
+	buf.WriteByte('\n')
+}
+
+// markdownArgument returns the type, default value and help of arg escaped
+// for table cells
+func markdownArgument(arg Argument) (string, string, string) {
+	if darg, ok := arg.(documentedArgument); ok {
+		return markdownEscape(darg.typeName()), darg.defaultString(), markdownEscape(darg.plainHelp())
+	}
+	return "", "", markdownEscape(arg.HelpString(""))
+}
+
+func markdownEscape(str string) string {
+	str = strings.Replace(str, "|", "\\|", -1)
+	return strings.Replace(strings.TrimSpace(str), "\n", "<br>", -1)
+}
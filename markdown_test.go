@@ -0,0 +1,72 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateMarkdown(t *testing.T) {
+	parser := mustNewParser(t, &struct {
+		Zone       string `help:"zone of the server" default:"z1" env:"ZONE"`
+		Level      string `choices:"debug|info" short-token:"l"`
+		Name       string `group:"Basic" help:"a | b\nline2"`
+		Secret     string `hidden:"true"`
+		SUBCOMMAND string `subcommand:"true"`
+	}{})
+	_, err := parser.AddSubParser(&struct {
+		ID    []string `help:"ids"`
+		Limit int      `default:"20"`
+	}{}, "list", "List items", nil)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := parser.GetSubcommand().AddAliases("list", "ls"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := parser.GenerateMarkdown(&buf); err != nil {
+		t.Fatalf("GenerateMarkdown: %v", err)
+	}
+	want := "# prog\n\nprog desc\n\n" +
+		"## Synopsis\n\n```\nUsage: prog [--level|-l {debug,info}] [--name NAME] [--help] [--zone ZONE] <SUBCOMMAND> ...\n```\n\n" +
+		"## Subcommands\n\n- `list` (ls): List items\n\n" +
+		"## Options\n\n" +
+		"| Option | Type | Default | Choices | Description |\n" +
+		"|--------|------|---------|---------|-------------|\n" +
+		"| `--level`, `-l` | string |  | `debug`, `info` |  |\n" +
+		"| `--help` |  |  |  | Print usage and this help message and exit. |\n" +
+		"| `--zone` | string | `z1` |  | zone of the server (env: ZONE) |\n\n" +
+		"## Basic\n\n" +
+		"| Option | Type | Default | Choices | Description |\n" +
+		"|--------|------|---------|---------|-------------|\n" +
+		"| `--name` | string |  |  | a \\| b<br>line2 |\n\n" +
+		"prog epilog\n\n" +
+		"## prog list\n\nList items\n\n" +
+		"### Synopsis\n\n```\nUsage: prog list [--help] [--limit LIMIT] <ID> ...\n```\n\n" +
+		"### Positional arguments\n\n" +
+		"| Name | Type | Description |\n" +
+		"|------|------|-------------|\n" +
+		"| `ID` | []string | ids |\n\n" +
+		"### Options\n\n" +
+		"| Option | Type | Default | Choices | Description |\n" +
+		"|--------|------|---------|---------|-------------|\n" +
+		"| `--help` |  |  |  | Print usage and this help message and exit. |\n" +
+		"| `--limit` | int | `20` |  |  |\n\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
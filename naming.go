@@ -0,0 +1,128 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NamingStrategy derives the token of an argument from its kebab-case form,
+// e.g. auth-url, see SetNamingStrategy
+type NamingStrategy func(token string) string
+
+// KebabCase keeps tokens in kebab-case, e.g. auth-url, the default
+func KebabCase(token string) string {
+	return token
+}
+
+// SnakeCase derives tokens in snake_case, e.g. auth_url
+func SnakeCase(token string) string {
+	return strings.Replace(token, "-", "_", -1)
+}
+
+// CamelCase derives tokens in camelCase, e.g. authUrl
+func CamelCase(token string) string {
+	words := strings.Split(token, "-")
+	for i := 1; i < len(words); i++ {
+		if len(words[i]) > 0 {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// SetNamingStrategy sets how tokens of arguments are derived, e.g.
+// SnakeCase for --auth_url, or a function of the kebab-case token.  Keys of
+// configuration files and queries in any case map onto the tokens, while
+// names of environment variables are the upper case of the kebab-case
+// tokens as before, e.g. AUTH_URL.  Tokens of alias and negative tags are
+// derived as well.  The strategy is also applied to the parsers of
+// subcommands
+func (this *ArgumentParser) SetNamingStrategy(strategy NamingStrategy) error {
+	old := this.naming
+	this.naming = strategy
+	if err := this.renameArguments(); err != nil {
+		this.naming = old
+		this.renameArguments()
+		return err
+	}
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for name, data := range subcmd.subcommands {
+			if err := data.parser.SetNamingStrategy(strategy); err != nil {
+				return fmt.Errorf("subcommand %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// namingArgument is implemented by arguments deriving tokens from tags
+type namingArgument interface {
+	normalizeTokens()
+	kebabToken() string
+}
+
+// renameArguments derives the tokens of arguments again, failing on
+// duplicates
+func (this *ArgumentParser) renameArguments() error {
+	defer this.invalidateTokens()
+	tokens := make(map[string]Argument)
+	for _, args := range [][]Argument{this.posArgs, this.optArgs, this.disabledArgs} {
+		for _, arg := range args {
+			if narg, ok := arg.(namingArgument); ok {
+				narg.normalizeTokens()
+			}
+			if arg.IsPositional() {
+				continue
+			}
+			for _, token := range longTokens(arg) {
+				if other, ok := tokens[token]; ok && other != arg {
+					return fmt.Errorf("%s: Duplicate token --%s of %s and %s", this.targetName(), token, other.Token(), arg.Token())
+				}
+				tokens[token] = arg
+			}
+		}
+	}
+	return nil
+}
+
+// namedToken converts token in any case into the one of the naming
+// strategy, e.g. auth-url into auth_url with SnakeCase
+func (this *ArgumentParser) namedToken(token string) string {
+	if this.naming == nil {
+		return token
+	}
+	return this.naming(splitCamelString(token))
+}
+
+// keyToken converts key of configuration files and queries into token
+func (this *ArgumentParser) keyToken(key string) string {
+	return this.namedToken(keyToToken(key))
+}
+
+// kebabToken returns the token in kebab-case regardless of the naming
+// strategy, which environment variables and metavars are derived from
+func (this *SingleArgument) kebabToken() string {
+	return this.tokens.kebab
+}
+
+// kebabToken returns the token of arg in kebab-case
+func kebabToken(arg Argument) string {
+	if narg, ok := arg.(namingArgument); ok {
+		return narg.kebabToken()
+	}
+	return arg.Token()
+}
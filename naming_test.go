@@ -0,0 +1,136 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+func TestNamingStrategy(t *testing.T) {
+	type Options struct {
+		AuthURL string `alias:"auth-endpoint"`
+		Debug   bool   `negative:"no-debug"`
+		DB      struct {
+			MaxConns int
+		}
+		Region string `env:"NAMING_TEST_REGION"`
+	}
+	cases := []struct {
+		name     string
+		strategy NamingStrategy
+		args     []string
+		token    string
+		conf     string
+	}{
+		{"kebab", KebabCase, []string{"--auth-endpoint", "u", "--no-debug", "--db-max-conns", "3"}, "auth-url", "auth-url"},
+		{"snake", SnakeCase, []string{"--auth_endpoint", "u", "--no_debug", "--db_max_conns", "3"}, "auth_url", "auth_url"},
+		{"camel", CamelCase, []string{"--authEndpoint", "u", "--noDebug", "--dbMaxConns", "3"}, "authUrl", "authUrl"},
+		{"callback", func(token string) string { return "x-" + token }, []string{"--x-auth-endpoint", "u", "--x-no-debug", "--x-db-max-conns", "3"}, "x-auth-url", "auth_url"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Options{Debug: true}
+			p, err := NewArgumentParserWithOptions(s, WithProg("prog"), WithNamingStrategy(c.strategy), WithEnvPrefix("STRUCTARG"))
+			if err != nil {
+				t.Fatalf("NewArgumentParserWithOptions: %v", err)
+			}
+			if err := p.ParseArgs(c.args, false); err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if s.AuthURL != "u" || s.Debug || s.DB.MaxConns != 3 {
+				t.Errorf("unexpected options %#v", s)
+			}
+			if !p.IsSet("auth_url") || !p.IsSet("AuthURL") || !p.IsSet(c.token) {
+				t.Errorf("auth-url is not found in any case")
+			}
+			if !strings.Contains(p.HelpString(), "--"+c.token) {
+				t.Errorf("help does not show --%s:\n%s", c.token, p.HelpString())
+			}
+			if env := argumentEnvName(p.findArgumentByToken("auth-url")); env != "STRUCTARG_AUTH_URL" {
+				t.Errorf("want env STRUCTARG_AUTH_URL, got %s", env)
+			}
+
+			conf := jsonutils.NewDict()
+			conf.Set(c.conf, jsonutils.NewString("c"))
+			conf.Set("db", jsonutils.Marshal(map[string]int{"max_conns": 4}))
+			s2 := &Options{}
+			p2, err := NewArgumentParserWithOptions(s2, WithNamingStrategy(c.strategy))
+			if err != nil {
+				t.Fatalf("NewArgumentParserWithOptions: %v", err)
+			}
+			if err := p2.UpdateJSONDict(conf, true); err != nil {
+				t.Fatalf("UpdateJSONDict: %v", err)
+			}
+			if s2.AuthURL != "c" || s2.DB.MaxConns != 4 {
+				t.Errorf("unexpected options from config %#v", s2)
+			}
+
+			os.Setenv("NAMING_TEST_REGION", "r1")
+			defer os.Unsetenv("NAMING_TEST_REGION")
+			if err := p2.ParseArgs(nil, false); err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if s2.Region != "r1" {
+				t.Errorf("region from env: want r1, got %q", s2.Region)
+			}
+		})
+	}
+
+	t.Run("subcommand", func(t *testing.T) {
+		type Sub struct {
+			DryRun bool
+		}
+		s := &struct {
+			SUBCOMMAND string `subcommand:"true"`
+		}{}
+		p := mustNewParser(t, s)
+		if _, err := p.AddSubParser(&Sub{}, "before", "", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		if err := p.SetNamingStrategy(SnakeCase); err != nil {
+			t.Fatalf("SetNamingStrategy: %v", err)
+		}
+		sub := &Sub{}
+		if _, err := p.AddSubParser(sub, "after", "", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		for _, cmd := range []string{"before", "after"} {
+			if err := p.ParseArgs([]string{cmd, "--dry_run"}, false); err != nil {
+				t.Errorf("ParseArgs %s: %v", cmd, err)
+			}
+		}
+		if !sub.DryRun {
+			t.Errorf("dry_run of after is not set")
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		s := &struct {
+			AuthURL string
+			Region  string
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.SetNamingStrategy(func(string) string { return "same" }); err == nil {
+			t.Fatalf("expecting error of duplicate tokens")
+		}
+		if err := p.ParseArgs([]string{"--auth-url", "u"}, false); err != nil || s.AuthURL != "u" {
+			t.Errorf("tokens are not restored: %v", err)
+		}
+	})
+}
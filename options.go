@@ -0,0 +1,225 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrorHandling decides what ParseArgs2 does when parsing fails
+type ErrorHandling int
+
+const (
+	// CONTINUE_ON_ERROR returns the error, the default
+	CONTINUE_ON_ERROR ErrorHandling = iota
+	// EXIT_ON_ERROR prints the error and usage to the warning writer and
+	// exits with status 2.  It also exits with status 0 after help
+	EXIT_ON_ERROR
+	// PANIC_ON_ERROR panics with the error
+	PANIC_ON_ERROR
+)
+
+// exit is replaced by tests
+var exit = os.Exit
+
+// ParserOption configures the parser of NewArgumentParserWithOptions
+type ParserOption func(parser *ArgumentParser) error
+
+// NewArgumentParserWithOptions creates a parser of target configured by
+// opts.  The prog name is the base name of os.Args[0] unless WithProg is
+// given
+func NewArgumentParserWithOptions(target interface{}, opts ...ParserOption) (*ArgumentParser, error) {
+	prog := ""
+	if len(os.Args) > 0 {
+		prog = filepath.Base(os.Args[0])
+	}
+	parser, err := newArgumentParser(target, prog, "", "")
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(parser); err != nil {
+			return nil, err
+		}
+	}
+	return parser, nil
+}
+
+func WithProg(prog string) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.prog = prog
+		return nil
+	}
+}
+
+func WithDescription(desc string) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.description = desc
+		return nil
+	}
+}
+
+func WithEpilog(epilog string) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.epilog = epilog
+		return nil
+	}
+}
+
+// WithErrorHandling sets what ParseArgs2 does with errors, see
+// SetErrorHandling
+func WithErrorHandling(handling ErrorHandling) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetErrorHandling(handling)
+		return nil
+	}
+}
+
+func WithEnvPrefix(prefix string) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetEnvPrefix(prefix)
+		return nil
+	}
+}
+
+// WithHelpDisabled leaves --help to the program, see SetHelpEnabled
+func WithHelpDisabled() ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetHelpEnabled(false)
+		return nil
+	}
+}
+
+// WithHelpToken renames the help argument, see SetHelpToken
+func WithHelpToken(token, short string) ParserOption {
+	return func(parser *ArgumentParser) error {
+		return parser.SetHelpToken(token, short)
+	}
+}
+
+// WithOutput sets where help, version and completion go, see SetOutput
+func WithOutput(w io.Writer) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetOutput(w)
+		return nil
+	}
+}
+
+// WithChoicesIgnoreCase matches choices regardless of case, see
+// SetChoicesIgnoreCase
+func WithChoicesIgnoreCase() ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetChoicesIgnoreCase(true)
+		return nil
+	}
+}
+
+// WithNamingStrategy sets how tokens are derived, see SetNamingStrategy
+func WithNamingStrategy(strategy NamingStrategy) ParserOption {
+	return func(parser *ArgumentParser) error {
+		return parser.SetNamingStrategy(strategy)
+	}
+}
+
+// WithAbbreviationMode sets how options abbreviate tokens, see
+// SetAbbreviationMode
+func WithAbbreviationMode(mode AbbreviationMode) ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetAbbreviationMode(mode)
+		return nil
+	}
+}
+
+// WithOptionsFirst requires options before positionals, see SetInterspersed
+func WithOptionsFirst() ParserOption {
+	return func(parser *ArgumentParser) error {
+		parser.SetInterspersed(false)
+		return nil
+	}
+}
+
+// WithHelpCommand registers the help subcommand, see SetHelpCommand
+func WithHelpCommand() ParserOption {
+	return func(parser *ArgumentParser) error {
+		return parser.SetHelpCommand(true)
+	}
+}
+
+// SetErrorHandling sets what ParseArgs2 does with errors, the default is
+// CONTINUE_ON_ERROR.  Only the parser ParseArgs2 is called on is concerned,
+// errors of subcommands are handled by their parent
+func (this *ArgumentParser) SetErrorHandling(handling ErrorHandling) {
+	this.errorHandling = handling
+}
+
+// SetOutput sets where help, version and completion go, the default is
+// os.Stdout.  The writer is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetOutput(w io.Writer) {
+	this.output = w
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetOutput(w)
+		}
+	}
+}
+
+// SetInterspersed controls whether positionals and options may be given in
+// any order, e.g. prog POS1 --opt v POS2, the default.  Otherwise options
+// end at the first positional, the rest are all positionals as if after
+// --, e.g. prog --opt v POS1 --not-opt.  Subcommands start their own
+// options.  The setting is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetInterspersed(interspersed bool) {
+	this.optionsFirst = !interspersed
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetInterspersed(interspersed)
+		}
+	}
+}
+
+func (this *ArgumentParser) out() io.Writer {
+	if this.output == nil {
+		return os.Stdout
+	}
+	return this.output
+}
+
+// handleError applies errorHandling to err returned by parseArgs2
+func (this *ArgumentParser) handleError(err error) error {
+	switch this.errorHandling {
+	case EXIT_ON_ERROR:
+		if err == ErrHelp {
+			exit(0)
+		} else if err != nil {
+			w := this.warnWriter
+			if w == nil {
+				w = os.Stderr
+			}
+			fmt.Fprintf(w, "%s: %v\n", this.prog, err)
+			fmt.Fprint(w, this.Usage())
+			exit(2)
+		} else if this.help {
+			exit(0)
+		}
+	case PANIC_ON_ERROR:
+		if err != nil && err != ErrHelp {
+			panic(err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewArgumentParserWithOptions(t *testing.T) {
+	type Options struct {
+		Region string `env:"STRUCTARG_TEST_OPTIONS_REGION"`
+	}
+	newParser := func(t *testing.T, opts ...ParserOption) (*ArgumentParser, *Options) {
+		s := &Options{}
+		p, err := NewArgumentParserWithOptions(s, opts...)
+		if err != nil {
+			t.Fatalf("NewArgumentParserWithOptions: %v", err)
+		}
+		return p, s
+	}
+	t.Run("help", func(t *testing.T) {
+		var out bytes.Buffer
+		p, _ := newParser(t, WithProg("prog"), WithDescription("prog desc"), WithEpilog("prog epilog"), WithOutput(&out))
+		if err := p.ParseArgs([]string{"--help"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !p.IsHelpSet() {
+			t.Errorf("want help set")
+		}
+		for _, str := range []string{"Usage: prog", "prog desc", "prog epilog"} {
+			if !strings.Contains(out.String(), str) {
+				t.Errorf("help without %q:\n%s", str, out.String())
+			}
+		}
+	})
+	t.Run("help disabled", func(t *testing.T) {
+		p, _ := newParser(t, WithHelpDisabled())
+		if err := p.ParseArgs([]string{"--help"}, false); err == nil {
+			t.Errorf("want error of unknown --help")
+		}
+		if strings.Contains(p.HelpString(), "--help") {
+			t.Errorf("help with --help:\n%s", p.HelpString())
+		}
+	})
+	t.Run("env prefix", func(t *testing.T) {
+		p, s := newParser(t, WithEnvPrefix("PROG"))
+		os.Setenv("PROG_STRUCTARG_TEST_OPTIONS_REGION", "region-env")
+		defer os.Unsetenv("PROG_STRUCTARG_TEST_OPTIONS_REGION")
+		if err := p.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Region != "region-env" {
+			t.Errorf("want region-env, got %s", s.Region)
+		}
+	})
+}
+
+func TestErrorHandling(t *testing.T) {
+	type Options struct {
+		Region string
+	}
+	defer func(fn func(int)) { exit = fn }(exit)
+	var code int
+	exit = func(c int) { code = c }
+	t.Run("exit", func(t *testing.T) {
+		var buf bytes.Buffer
+		p, err := NewArgumentParserWithOptions(&Options{}, WithProg("prog"), WithErrorHandling(EXIT_ON_ERROR))
+		if err != nil {
+			t.Fatalf("NewArgumentParserWithOptions: %v", err)
+		}
+		p.SetWarningWriter(&buf)
+		p.SetOutput(&buf)
+		code = -1
+		p.ParseArgs([]string{"--zone"}, false)
+		if code != 2 {
+			t.Errorf("want exit 2, got %d", code)
+		}
+		if !strings.HasPrefix(buf.String(), "prog: Unknown optional argument --zone") || !strings.Contains(buf.String(), "Usage: prog") {
+			t.Errorf("unexpected output %q", buf.String())
+		}
+		code = -1
+		p.ParseArgs([]string{"--help"}, false)
+		if code != 0 {
+			t.Errorf("want exit 0 after help, got %d", code)
+		}
+		code = -1
+		p.ParseArgs([]string{"--region", "r1"}, false)
+		if code != -1 {
+			t.Errorf("want no exit, got %d", code)
+		}
+	})
+	t.Run("panic", func(t *testing.T) {
+		p, err := NewArgumentParserWithOptions(&Options{}, WithErrorHandling(PANIC_ON_ERROR))
+		if err != nil {
+			t.Fatalf("NewArgumentParserWithOptions: %v", err)
+		}
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "--zone") {
+				t.Errorf("unexpected recover %v", r)
+			}
+		}()
+		p.ParseArgs([]string{"--zone"}, false)
+	})
+}
+
+func TestInterspersed(t *testing.T) {
+	type Options struct {
+		Opt   string
+		Debug bool
+		SRC   string
+		DEST  []string
+	}
+	cases := []struct {
+		name         string
+		optionsFirst bool
+		args         []string
+		want         Options
+		err          bool
+	}{
+		{"interspersed", false, []string{"a", "--opt", "v", "b", "--debug", "c"}, Options{Opt: "v", Debug: true, SRC: "a", DEST: []string{"b", "c"}}, false},
+		{"options first", true, []string{"--opt", "v", "a", "--debug", "b"}, Options{Opt: "v", SRC: "a", DEST: []string{"--debug", "b"}}, false},
+		{"options first after --", true, []string{"--", "a", "--opt"}, Options{SRC: "a", DEST: []string{"--opt"}}, false},
+		{"options first too many", true, []string{"a"}, Options{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Options{}
+			var opts []ParserOption
+			if c.optionsFirst {
+				opts = append(opts, WithOptionsFirst())
+			}
+			p, err := NewArgumentParserWithOptions(s, opts...)
+			if err != nil {
+				t.Fatalf("NewArgumentParserWithOptions: %v", err)
+			}
+			err = p.ParseArgs(c.args, false)
+			if c.err {
+				if err == nil {
+					t.Errorf("expecting error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if !reflect.DeepEqual(*s, c.want) {
+				t.Errorf("want %#v, got %#v", c.want, *s)
+			}
+		})
+	}
+
+	t.Run("subcommand", func(t *testing.T) {
+		type Options struct {
+			Debug      bool
+			SUBCOMMAND string `subcommand:"true"`
+		}
+		type CreateOptions struct {
+			Size  string
+			NAMES []string
+		}
+		s := &Options{}
+		p := mustNewParser(t, s)
+		create := &CreateOptions{}
+		if _, err := p.AddSubParser(create, "create", "create", nil); err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		p.SetInterspersed(false)
+		args := []string{"--debug", "create", "--size", "10G", "host1", "--size", "20G"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !s.Debug || create.Size != "10G" {
+			t.Errorf("unexpected options %#v %#v", s, create)
+		}
+		if want := []string{"host1", "--size", "20G"}; !reflect.DeepEqual(create.NAMES, want) {
+			t.Errorf("names: want %v, got %v", want, create.NAMES)
+		}
+	})
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nyl1001/pkg/errors"
+	"golang.org/x/term"
+)
+
+// maxPromptAttempts limits the prompts of an argument given invalid input
+const maxPromptAttempts = 3
+
+// SetInteractive controls whether ParseArgs prompts for the missing
+// required arguments when stdin is a terminal, instead of failing, e.g. for
+// first-run setup commands.  Choices are shown as a menu and defaults are
+// taken on empty input.  Values of multi arguments are comma separated.
+// Prompts are written to stderr.  It is disabled by default.  The setting
+// is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetInteractive(enable bool) {
+	this.interactive = enable
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetInteractive(enable)
+		}
+	}
+}
+
+// canPrompt tells whether missing required arguments are to be prompted for
+func (this *ArgumentParser) canPrompt() bool {
+	return this.interactive && this.promptable()
+}
+
+// promptable tells whether there is a terminal to prompt on
+func (this *ArgumentParser) promptable() bool {
+	if this.help {
+		return false
+	}
+	if this.promptIn != nil {
+		return true
+	}
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (this *ArgumentParser) promptReader() *bufio.Reader {
+	if this.promptBuf == nil {
+		var in io.Reader = os.Stdin
+		if this.promptIn != nil {
+			in = this.promptIn
+		}
+		this.promptBuf = bufio.NewReader(in)
+	}
+	return this.promptBuf
+}
+
+func (this *ArgumentParser) promptWriter() io.Writer {
+	if this.promptOut == nil {
+		return os.Stderr
+	}
+	return this.promptOut
+}
+
+// promptPositionals prompts for positional arguments from posIdx on, up to
+// the subcommand, and returns the index of the first one not given
+func (this *ArgumentParser) promptPositionals(posIdx int) (int, error) {
+	for ; posIdx < len(this.posArgs); posIdx++ {
+		arg := this.posArgs[posIdx]
+		if arg.IsSubcommand() {
+			break
+		}
+		if err := this.promptArgument(arg, &NotEnoughArgumentsError{argument: arg}); err != nil {
+			return posIdx, err
+		}
+	}
+	return posIdx, nil
+}
+
+// promptOptionals prompts for the required optional arguments not set in
+// interactive mode, and the arguments of TAG_PROMPT not set
+func (this *ArgumentParser) promptOptionals() error {
+	for _, arg := range this.optArgs {
+		var err error
+		if this.interactive {
+			err = arg.Validate()
+			if _, ok := errors.Cause(err).(*MissingRequiredError); !ok {
+				err = nil
+			}
+		}
+		if err == nil {
+			if parg, ok := arg.(promptedArgument); !ok || !parg.needPrompt() || arg.IsSet() {
+				continue
+			}
+		}
+		// checked only when needed, it costs a system call
+		if !this.promptable() {
+			return nil
+		}
+		if err := this.promptArgument(arg, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promptArgument prompts for the missing arg, err is reported if no value
+// is given
+func (this *ArgumentParser) promptArgument(arg Argument, err error) error {
+	for i := 0; ; i++ {
+		if e := this.Context().Err(); e != nil {
+			return e
+		}
+		val, e := this.promptValue(arg)
+		if e != nil {
+			if ctxErr := this.Context().Err(); ctxErr != nil {
+				return ctxErr
+			}
+			// EOF and the like, report the argument missing
+			return err
+		}
+		if len(val) > 0 {
+			if arg.IsMulti() {
+				// comma separated as values of environment variables
+				for _, v := range strings.Split(val, ",") {
+					if e = arg.SetValue(strings.TrimSpace(v)); e != nil {
+						break
+					}
+				}
+			} else {
+				e = arg.SetValue(val)
+			}
+			if e == nil {
+				return nil
+			}
+			fmt.Fprintf(this.promptWriter(), "%v\n", e)
+			arg.Reset()
+		}
+		if i+1 >= maxPromptAttempts {
+			if e != nil {
+				return e
+			}
+			return err
+		}
+	}
+}
+
+// promptValue prompts for arg once and returns the input, the chosen
+// choice or the default value on empty input
+func (this *ArgumentParser) promptValue(arg Argument) (string, error) {
+	w := this.promptWriter()
+	label := arg.Token()
+	if help := promptHelp(arg); len(help) > 0 {
+		label = fmt.Sprintf("%s (%s)", label, help)
+	}
+	defval, shown := "", ""
+	if parg, ok := arg.(promptedArgument); ok {
+		defval = parg.promptDefault()
+		shown = defval
+	}
+	secret := isSecretArgument(arg)
+	if secret && len(defval) > 0 {
+		shown = SECRET_MASK
+	}
+	choices := argumentChoices(arg)
+	if len(choices) > 0 {
+		fmt.Fprintf(w, "%s:\n", label)
+		for i, choice := range choices {
+			fmt.Fprintf(w, "  %d) %s\n", i+1, choice)
+		}
+		label = "Choose"
+	}
+	if len(shown) > 0 {
+		fmt.Fprintf(w, "%s [%s]: ", label, shown)
+	} else {
+		fmt.Fprintf(w, "%s: ", label)
+	}
+	var line string
+	var err error
+	if secret {
+		line, err = this.readSecret()
+	} else {
+		reader := this.promptReader()
+		line, err = this.readPromptLine(func() (string, error) {
+			return reader.ReadString('\n')
+		})
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	val := strings.TrimSpace(line)
+	if len(val) == 0 {
+		return defval, nil
+	}
+	if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= len(choices) {
+		return choices[n-1], nil
+	}
+	return val, nil
+}
+
+// readSecret reads a line from the terminal with echo disabled
+func (this *ArgumentParser) readSecret() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if this.promptIn != nil || !term.IsTerminal(fd) {
+		reader := this.promptReader()
+		return this.readPromptLine(func() (string, error) {
+			return reader.ReadString('\n')
+		})
+	}
+	state, err := term.GetState(fd)
+	if err != nil {
+		return "", err
+	}
+	secret, err := this.readPromptLine(func() (string, error) {
+		secret, err := term.ReadPassword(fd)
+		return string(secret), err
+	})
+	// the newline typed is not echoed either
+	fmt.Fprintln(this.promptWriter())
+	if err != nil {
+		if this.Context().Err() != nil {
+			// echo stays disabled by the read abandoned
+			term.Restore(fd, state)
+		}
+		return "", err
+	}
+	return secret, nil
+}
+
+// promptedArgument is implemented by arguments which can be prompted for
+type promptedArgument interface {
+	// needPrompt tells whether the argument is of TAG_PROMPT
+	needPrompt() bool
+	// promptDefault is the default value taken on empty input
+	promptDefault() string
+}
+
+func (this *SingleArgument) needPrompt() bool {
+	return this.prompt
+}
+
+func (this *SingleArgument) promptDefault() string {
+	if !this.useDefault {
+		return ""
+	}
+	return this.defaultStr
+}
+
+func promptHelp(arg Argument) string {
+	if darg, ok := arg.(documentedArgument); ok {
+		return darg.helpText()
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInteractive(t *testing.T) {
+	type Options struct {
+		NAME     string `help:"name of server"`
+		ZONE     string
+		Protocol string   `required:"true" choices:"tcp|udp"`
+		Tags     []string `required:"true" nargs:"+"`
+	}
+	parse := func(t *testing.T, input string, args ...string) (*Options, string, error) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		var out bytes.Buffer
+		p.SetInteractive(true)
+		p.promptIn = strings.NewReader(input)
+		p.promptOut = &out
+		err := p.ParseArgs(args, false)
+		return s, out.String(), err
+	}
+	t.Run("prompt", func(t *testing.T) {
+		s, out, err := parse(t, "srv1\nz1\n2\nt1, t2\n")
+		if err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.NAME != "srv1" || s.Protocol != "udp" || s.ZONE != "z1" || strings.Join(s.Tags, ",") != "t1,t2" {
+			t.Errorf("unexpected options %#v", s)
+		}
+		for _, str := range []string{"name (name of server): ", "  1) tcp\n  2) udp\n", "zone: "} {
+			if !strings.Contains(out, str) {
+				t.Errorf("prompt without %q:\n%s", str, out)
+			}
+		}
+	})
+	t.Run("given", func(t *testing.T) {
+		s, out, err := parse(t, "", "srv1", "z2", "--protocol", "tcp", "--tags", "t1")
+		if err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if len(out) > 0 {
+			t.Errorf("want no prompt, got %q", out)
+		}
+		if s.Protocol != "tcp" {
+			t.Errorf("want tcp, got %s", s.Protocol)
+		}
+	})
+	t.Run("invalid choice", func(t *testing.T) {
+		s, _, err := parse(t, "srv1\nz1\nsctp\ntcp\nt1\n")
+		if err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Protocol != "tcp" {
+			t.Errorf("want tcp, got %s", s.Protocol)
+		}
+	})
+	t.Run("eof", func(t *testing.T) {
+		_, _, err := parse(t, "srv1\n")
+		if err == nil {
+			t.Errorf("want error on eof")
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		var out bytes.Buffer
+		p.promptIn = strings.NewReader("srv1\n")
+		p.promptOut = &out
+		if err := p.ParseArgs(nil, false); err == nil {
+			t.Errorf("want error of missing arguments")
+		}
+		if out.Len() > 0 {
+			t.Errorf("want no prompt, got %q", out.String())
+		}
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"net/url"
+	"sort"
+)
+
+// ParseQuery parses the query string or form values of HTTP requests, e.g.
+// ?zone=z1&tag=a&tag=b&dry_run, as ParseArgs does the command line.  Keys
+// are tokens of the arguments, positional ones included, with _ standing
+// for -.  Repeated keys are values of multi arguments, and a key without
+// value sets boolean flags.  Unlike ParseArgs, keys must match exactly and
+// environment variables are not consulted
+func (this *ArgumentParser) ParseQuery(values url.Values) error {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+	this.reset()
+	defer this.setSource(SOURCE_QUERY)()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		if err := this.parseQueryValues(key, values[key]); err != nil {
+			errs = append(errs, err)
+			if !this.collectErrors {
+				break
+			}
+		}
+	}
+	if len(errs) == 0 || this.collectErrors {
+		errs = append(errs, this.validate(this.posArgs, this.collectErrors)...)
+	}
+	err := joinErrors(errs)
+	if e := this.ApplyDefaults(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = this.postParse()
+	}
+	return err
+}
+
+func (this *ArgumentParser) parseQueryValues(key string, values []string) error {
+	token := this.keyToken(key)
+	arg := this.findArgumentByToken(token)
+	nega := false
+	if arg == nil || arg.IsSubcommand() {
+		arg, nega = this.findOptionalArgument(token, true)
+	}
+	if arg == nil {
+		return &UnknownArgumentError{Argument: key, Suggestions: this.suggestTokens(token)}
+	}
+	arg = this.useArgument(arg, key)
+	if !arg.IsMulti() && len(values) > 1 {
+		return &MultipleValuesError{Argument: key}
+	}
+	for _, value := range values {
+		var err error
+		switch {
+		case nega || (!arg.NeedData() && len(value) == 0):
+			if len(value) > 0 {
+				return &NegativeValueError{Argument: key}
+			}
+			err = arg.DoAction(nega)
+		default:
+			err = arg.SetValue(value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/nyl1001/pkg/errors"
+)
+
+func TestParseQuery(t *testing.T) {
+	type Options struct {
+		Zone   string `choices:"z1|z2" default:"z1"`
+		Limit  int    `default:"20"`
+		Tags   []string
+		DryRun bool `negative:"no-dry-run"`
+		Force  bool
+		ID     string
+	}
+	parse := func(t *testing.T, query string) (*ArgumentParser, *Options, error) {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("ParseQuery %s: %v", query, err)
+		}
+		s := &Options{}
+		p := mustNewParser(t, s)
+		return p, s, p.ParseQuery(values)
+	}
+	t.Run("parse", func(t *testing.T) {
+		p, s, err := parse(t, "id=i1&limit=5&tags=a&tags=b&dry_run&force=true")
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		want := &Options{Zone: "z1", Limit: 5, Tags: []string{"a", "b"}, DryRun: true, Force: true, ID: "i1"}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+		if got := p.Source("limit"); got != SOURCE_QUERY {
+			t.Errorf("want source query, got %q", got)
+		}
+		if got := p.Source("zone"); got != SOURCE_DEFAULT {
+			t.Errorf("want source default, got %q", got)
+		}
+	})
+	t.Run("negative", func(t *testing.T) {
+		_, s, err := parse(t, "id=i1&dry-run&no-dry-run")
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		if s.DryRun {
+			t.Errorf("want dry run unset")
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		for query, want := range map[string]interface{}{
+			"":                      &MissingRequiredError{},
+			"id=i1&zone=z3":         nil,
+			"id=i1&limit=x":         &ConversionError{},
+			"id=i1&limit=1&limit=2": &MultipleValuesError{},
+			"id=i1&zon=z1":          &UnknownArgumentError{},
+			"id=i1&no-dry-run=1":    &NegativeValueError{},
+		} {
+			_, _, err := parse(t, query)
+			if err == nil {
+				t.Errorf("%q: want error", query)
+				continue
+			}
+			if want != nil && reflect.TypeOf(err) != reflect.TypeOf(want) && reflect.TypeOf(errors.Cause(err)) != reflect.TypeOf(want) {
+				t.Errorf("%q: want %T, got %T %v", query, want, err, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,318 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"yunion.io/x/log"
+)
+
+// sConfigLoad records a call parsing configuration files, which Reload
+// replays
+type sConfigLoad struct {
+	paths []string
+	// layered loads override values of earlier files, see ParseFiles
+	layered bool
+	parse   func(this *ArgumentParser, path string) error
+}
+
+// recordConfigLoad records a load, replacing the same load recorded before,
+// e.g. of the file parsed again, so that Reload replays it once and last
+func (this *ArgumentParser) recordConfigLoad(parse func(*ArgumentParser, string) error, layered bool, paths ...string) sConfigLoad {
+	load := sConfigLoad{paths: paths, layered: layered, parse: parse}
+	var loads []sConfigLoad
+	for _, old := range this.configLoads {
+		if !old.equals(load) {
+			loads = append(loads, old)
+		}
+	}
+	this.configLoads = append(loads, load)
+	return load
+}
+
+func (this sConfigLoad) equals(load sConfigLoad) bool {
+	return this.layered == load.layered &&
+		reflect.ValueOf(this.parse).Pointer() == reflect.ValueOf(load.parse).Pointer() &&
+		reflect.DeepEqual(this.paths, load.paths)
+}
+
+func (this *ArgumentParser) loadConfigFiles(load sConfigLoad) error {
+	if load.layered {
+		this.confSources = make(map[string]bool)
+		defer func() {
+			this.confSources = nil
+		}()
+	}
+	for _, path := range load.paths {
+		if load.layered {
+			this.confSources[path] = true
+		}
+		if err := load.parse(this, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConfigFiles returns paths of the configuration files parsed so far, in
+// order
+func (this *ArgumentParser) ConfigFiles() []string {
+	var paths []string
+	for _, load := range this.configLoads {
+		paths = append(paths, load.paths...)
+	}
+	return paths
+}
+
+// sArgumentState is the value of an argument saved by Reload
+type sArgumentState struct {
+	value  reflect.Value
+	isSet  bool
+	source string
+}
+
+func (this *SingleArgument) saveState() sArgumentState {
+	value := reflect.New(this.value.Type()).Elem()
+	if this.value.Kind() == reflect.Map && !this.value.IsNil() {
+		// maps are updated in place
+		value.Set(reflect.MakeMap(this.value.Type()))
+		for _, key := range this.value.MapKeys() {
+			value.SetMapIndex(key, this.value.MapIndex(key))
+		}
+	} else {
+		value.Set(this.value)
+	}
+	return sArgumentState{value: value, isSet: this.isSet, source: this.source}
+}
+
+func (this *SingleArgument) restoreState(state sArgumentState) {
+	this.value.Set(state.value)
+	this.isSet = state.isSet
+	this.source = state.source
+}
+
+// statefulArgument is implemented by arguments whose values Reload saves
+type statefulArgument interface {
+	saveState() sArgumentState
+	restoreState(state sArgumentState)
+}
+
+// Reload parses environment and the configuration files parsed so far
+// again, in the same way and order, to update the target at runtime.
+// Values from command line, UpdateJSONDict and ParseQuery are kept, the
+// others are parsed afresh, i.e. values removed from the files fall back to
+// defaults.  The hooks of Validator and PostParser are called afterwards,
+// and the target is restored if any of them or the parsing fails.  It
+// returns tokens of the arguments whose values changed.
+//
+// Reads of the target concurrent with Reload should be guarded by
+// RLockOptions and RUnlockOptions
+func (this *ArgumentParser) Reload() ([]string, error) {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+
+	states := make([]sArgumentState, len(this.optArgs))
+	for i, arg := range this.optArgs {
+		sarg, ok := arg.(statefulArgument)
+		if !ok {
+			continue
+		}
+		states[i] = sarg.saveState()
+		if source := argumentSource(arg); source != SOURCE_COMMAND_LINE && source != SOURCE_UPDATE && source != SOURCE_QUERY {
+			arg.Reset()
+		}
+	}
+	restore := func() {
+		for i, arg := range this.optArgs {
+			if sarg, ok := arg.(statefulArgument); ok {
+				sarg.restoreState(states[i])
+			}
+		}
+	}
+	if err := this.parseEnv(); err != nil {
+		restore()
+		return nil, err
+	}
+	for _, load := range this.configLoads {
+		if err := this.loadConfigFiles(load); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+	if err := this.ApplyDefaults(); err != nil {
+		restore()
+		return nil, err
+	}
+	if err := this.postParse(); err != nil {
+		restore()
+		return nil, err
+	}
+
+	var changed []string
+	for i, arg := range this.optArgs {
+		varg, ok := arg.(valueArgument)
+		if !ok || !states[i].value.IsValid() {
+			continue
+		}
+		if !reflect.DeepEqual(states[i].value.Interface(), varg.getValue().Interface()) {
+			changed = append(changed, arg.Token())
+		}
+	}
+	return changed, nil
+}
+
+// RLockOptions locks the target for reading against Reload, ParseArgs and
+// ParseQuery
+func (this *ArgumentParser) RLockOptions() {
+	this.optionsLock.RLock()
+}
+
+// RUnlockOptions undoes a single RLockOptions call
+func (this *ArgumentParser) RUnlockOptions() {
+	this.optionsLock.RUnlock()
+}
+
+// ConfigWatchInterval is how often WatchConfigFile checks the file
+var ConfigWatchInterval = 2 * time.Second
+
+// ConfigWatcher polls a configuration file, see WatchConfigFile
+type ConfigWatcher struct {
+	parser   *ArgumentParser
+	path     string
+	content  []byte
+	callback func(changed []string)
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// WatchConfigFile watches the configuration file of path, and calls Reload
+// whenever its content changes, every ConfigWatchInterval.  callback is
+// called with tokens of the changed arguments, if there are any.  Errors of
+// Reload are logged, leaving the target intact.  The file is parsed by
+// ParseFile first unless it is parsed already
+func (this *ArgumentParser) WatchConfigFile(path string, callback func(changed []string)) (*ConfigWatcher, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %v", path, err)
+	}
+	parsed := false
+	for _, file := range this.ConfigFiles() {
+		if file == path {
+			parsed = true
+			break
+		}
+	}
+	if !parsed {
+		this.optionsLock.Lock()
+		err := this.ParseFile(path)
+		this.optionsLock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	w := &ConfigWatcher{
+		parser:   this,
+		path:     path,
+		content:  content,
+		callback: callback,
+		stop:     make(chan struct{}),
+	}
+	go w.run(ConfigWatchInterval)
+	return w, nil
+}
+
+func (w *ConfigWatcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *ConfigWatcher) check() {
+	content, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		// being replaced, e.g. by editors
+		return
+	}
+	if bytes.Equal(content, w.content) {
+		return
+	}
+	w.content = content
+	changed, err := w.parser.Reload()
+	if err != nil {
+		log.Errorf("reload %s: %v", w.path, err)
+		return
+	}
+	if len(changed) > 0 && w.callback != nil {
+		w.callback(changed)
+	}
+}
+
+// Stop stops watching the file
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// ReloadOnSignal calls Reload whenever the process receives one of sigs,
+// SIGHUP if none is given, as daemons conventionally do.  callback, if not
+// nil, is called after each reload with tokens of the changed arguments
+// and the error of Reload.  It returns the function to stop it
+func (this *ArgumentParser) ReloadOnSignal(callback func(changed []string, err error), sigs ...os.Signal) func() {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ch:
+				changed, err := this.Reload()
+				if callback != nil {
+					callback(changed, err)
+				} else if err != nil {
+					log.Errorf("reload: %v", err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(stop)
+		})
+	}
+}
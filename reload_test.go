@@ -0,0 +1,190 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog.conf")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("start = 1\nend = 5\n")
+
+	opts := &testHookOptions{}
+	parser := mustNewParser(t, opts)
+	if err := parser.ParseArgs2(nil, false, false); err != nil {
+		t.Fatalf("ParseArgs2: %v", err)
+	}
+	if err := parser.ParseFile(path); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	parser.SetDefault()
+	if opts.Span != 4 {
+		t.Fatalf("want span 4, got %d", opts.Span)
+	}
+
+	t.Run("changed", func(t *testing.T) {
+		write("start = 2\n")
+		changed, err := parser.Reload()
+		if err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+		sort.Strings(changed)
+		if want := []string{"end", "span", "start"}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("want changed %v, got %v", want, changed)
+		}
+		if opts.Start != 2 || opts.End != 10 || opts.Span != 8 {
+			t.Errorf("unexpected options %#v", opts)
+		}
+		if got := parser.Source("end"); got != SOURCE_DEFAULT {
+			t.Errorf("want end from default, got %s", got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		write("start = 20\n")
+		if _, err := parser.Reload(); err == nil {
+			t.Fatalf("want error")
+		}
+		if opts.Start != 2 || opts.End != 10 || opts.Span != 8 {
+			t.Errorf("options not restored %#v", opts)
+		}
+		if got := parser.Source("start"); got != path {
+			t.Errorf("want start from %s, got %s", path, got)
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		interval := ConfigWatchInterval
+		ConfigWatchInterval = 10 * time.Millisecond
+		defer func() {
+			ConfigWatchInterval = interval
+		}()
+		ch := make(chan []string, 1)
+		w, err := parser.WatchConfigFile(path, func(changed []string) {
+			ch <- changed
+		})
+		if err != nil {
+			t.Fatalf("WatchConfigFile: %v", err)
+		}
+		defer w.Stop()
+		write("start = 3\nend = 10\n")
+		select {
+		case changed := <-ch:
+			sort.Strings(changed)
+			if want := []string{"span", "start"}; !reflect.DeepEqual(changed, want) {
+				t.Errorf("want changed %v, got %v", want, changed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reload")
+		}
+		parser.RLockOptions()
+		defer parser.RUnlockOptions()
+		if opts.Start != 3 || opts.Span != 7 {
+			t.Errorf("unexpected options %#v", opts)
+		}
+	})
+}
+
+func TestReloadParsedTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prog.conf")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("tags = a\n")
+
+	opts := &struct {
+		Tags []string `slice-mode:"append"`
+	}{}
+	parser := mustNewParser(t, opts)
+	for i := 0; i < 2; i++ {
+		if err := parser.ParseFile(path); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+	}
+	if files := parser.ConfigFiles(); !reflect.DeepEqual(files, []string{path}) {
+		t.Errorf("want config files %v, got %v", []string{path}, files)
+	}
+	write("tags = b\n")
+	if _, err := parser.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !reflect.DeepEqual(opts.Tags, []string{"b"}) {
+		t.Errorf("want tags [b], got %v", opts.Tags)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	os.Setenv("STRUCTARG_TEST_RELOAD_ZONE", "z1")
+	opts := &struct {
+		Zone string `env:"STRUCTARG_TEST_RELOAD_ZONE"`
+	}{}
+	parser := mustNewParser(t, opts)
+	if err := parser.ParseArgs(nil, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	ch := make(chan []string, 1)
+	stop := parser.ReloadOnSignal(func(changed []string, err error) {
+		if err != nil {
+			t.Errorf("Reload: %v", err)
+		}
+		ch <- changed
+	})
+	defer stop()
+
+	os.Setenv("STRUCTARG_TEST_RELOAD_ZONE", "z2")
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("FindProcess: %v", err)
+	}
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("SIGHUP not supported: %v", err)
+	}
+	select {
+	case changed := <-ch:
+		if want := []string{"zone"}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("want changed %v, got %v", want, changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reload")
+	}
+	if opts.Zone != "z2" {
+		t.Errorf("want zone z2, got %s", opts.Zone)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+
+	"github.com/nyl1001/pkg/gotypes"
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// JSONSchema returns a JSON Schema (draft-07) of the object JSONDict returns
+// and parseJSONDict accepts, e.g. to validate API payloads against the
+// definition of the command line.  Properties are keyed as in JSONDict and
+// carry types, enums of choices, defaults, ranges, lengths and patterns.
+// With SetStrictConfig, unknown properties are not allowed.  Subcommands are
+// not described
+func (this *ArgumentParser) JSONSchema() *jsonutils.JSONDict {
+	schema := jsonutils.NewDict()
+	schema.Set("$schema", jsonutils.NewString("http://json-schema.org/draft-07/schema#"))
+	if len(this.prog) > 0 {
+		schema.Set("title", jsonutils.NewString(this.prog))
+	}
+	if len(this.description) > 0 {
+		schema.Set("description", jsonutils.NewString(this.description))
+	}
+	schema.Set("type", jsonutils.NewString("object"))
+	properties := jsonutils.NewDict()
+	var required []string
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			sarg, ok := arg.(schemaArgument)
+			if !ok || arg.IsSubcommand() {
+				continue
+			}
+			properties.Set(configKey(arg), sarg.jsonSchema())
+			if arg.IsRequired() {
+				required = append(required, configKey(arg))
+			}
+		}
+	}
+	schema.Set("properties", properties)
+	if len(required) > 0 {
+		schema.Set("required", jsonutils.NewStringArray(required))
+	}
+	if this.strictConfig {
+		schema.Set("additionalProperties", jsonutils.JSONFalse)
+	}
+	return schema
+}
+
+// schemaArgument is implemented by arguments described by JSONSchema
+type schemaArgument interface {
+	jsonSchema() *jsonutils.JSONDict
+}
+
+func (this *SingleArgument) jsonSchema() *jsonutils.JSONDict {
+	schema := this.typeSchema(this.value.Type())
+	if len(this.help) > 0 {
+		schema.Set("description", jsonutils.NewString(this.help))
+	}
+	if this.secret {
+		schema.Set("writeOnly", jsonutils.JSONTrue)
+	} else if this.useDefault && this.defValue.IsValid() {
+		schema.Set("default", exportArgumentValue(this, this.defValue))
+	}
+	if len(this.deprecated) > 0 {
+		schema.Set("deprecated", jsonutils.JSONTrue)
+	}
+	return schema
+}
+
+// typeSchema describes values of tp.  Choices, range, length and pattern
+// apply to the elements of slices and maps as they do when parsing
+func (this *SingleArgument) typeSchema(tp reflect.Type) *jsonutils.JSONDict {
+	schema := jsonutils.NewDict()
+	jsonType := "string"
+	switch {
+	case tp == gotypes.TimeType:
+		if len(this.layouts) == 0 {
+			schema.Set("format", jsonutils.NewString("date-time"))
+		}
+	case tp == urlType:
+		schema.Set("format", jsonutils.NewString("uri"))
+	case tp == durationType || this.isScalar(tp):
+	case tp.Kind() == reflect.Ptr:
+		return this.typeSchema(tp.Elem())
+	case tp.Kind() == reflect.Slice || tp.Kind() == reflect.Array:
+		schema.Set("type", jsonutils.NewString("array"))
+		schema.Set("items", this.typeSchema(tp.Elem()))
+		return schema
+	case tp.Kind() == reflect.Map:
+		schema.Set("type", jsonutils.NewString("object"))
+		schema.Set("additionalProperties", this.typeSchema(tp.Elem()))
+		return schema
+	case this.enum:
+		// names of the enum
+	case tp.Kind() == reflect.Bool:
+		jsonType = "boolean"
+	case tp.Kind() >= reflect.Int && tp.Kind() <= reflect.Uint64:
+		jsonType = "integer"
+	case tp.Kind() == reflect.Float32 || tp.Kind() == reflect.Float64:
+		jsonType = "number"
+	}
+	if this.unit == UNIT_BYTES && jsonType == "integer" {
+		// sizes like 512M are accepted as well
+		schema.Set("type", jsonutils.NewStringArray([]string{"integer", "string"}))
+	} else {
+		schema.Set("type", jsonutils.NewString(jsonType))
+	}
+	if choices := this.Choices(); len(choices) > 0 {
+		enum := jsonutils.NewArray()
+		for _, choice := range choices {
+			if rv, err := this.parseValue(choice, tp); err == nil {
+				enum.Add(exportValue(rv))
+			} else {
+				enum.Add(jsonutils.NewString(choice))
+			}
+		}
+		schema.Set("enum", enum)
+	}
+	if jsonType == "integer" || jsonType == "number" {
+		if this.minValue.IsValid() {
+			schema.Set("minimum", exportValue(this.minValue))
+		}
+		if this.maxValue.IsValid() {
+			schema.Set("maximum", exportValue(this.maxValue))
+		}
+	}
+	if tp.Kind() == reflect.String {
+		if this.minLen > 0 {
+			schema.Set("minLength", jsonutils.NewInt(int64(this.minLen)))
+		}
+		if this.maxLen > 0 {
+			schema.Set("maxLength", jsonutils.NewInt(int64(this.maxLen)))
+		}
+		if this.pattern != nil {
+			schema.Set("pattern", jsonutils.NewString(this.pattern.String()))
+		}
+	}
+	return schema
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+func TestJSONSchema(t *testing.T) {
+	type Options struct {
+		Zone    string         `help:"zone of the server" default:"z1" choices:"z1|z2"`
+		Port    int            `min:"1" max:"65535" required:"true"`
+		Name    string         `minlen:"1" maxlen:"8" pattern:"^[a-z]+$"`
+		Disks   []int64        `unit:"bytes"`
+		Labels  map[string]int `deprecated:"use tags"`
+		Timeout time.Duration  `default:"3s"`
+		Since   time.Time
+		Debug   bool
+		ID      string
+	}
+	p := mustNewParser(t, &Options{})
+	want := `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title": "prog",
+		"description": "prog desc",
+		"type": "object",
+		"properties": {
+			"zone": {"type": "string", "enum": ["z1", "z2"], "description": "zone of the server", "default": "z1"},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+			"disks": {"type": "array", "items": {"type": ["integer", "string"]}},
+			"labels": {"type": "object", "additionalProperties": {"type": "integer"}, "deprecated": true},
+			"timeout": {"type": "string", "default": "3s"},
+			"since": {"type": "string", "format": "date-time"},
+			"debug": {"type": "boolean"},
+			"id": {"type": "string"}
+		},
+		"required": ["id", "port"]
+	}`
+	wantObj, err := jsonutils.ParseString(want)
+	if err != nil {
+		t.Fatalf("parse want: %v", err)
+	}
+	if got := p.JSONSchema(); got.String() != wantObj.String() {
+		t.Errorf("want\n%s\ngot\n%s", wantObj.PrettyString(), got.PrettyString())
+	}
+
+	p.SetStrictConfig(true)
+	if v, err := p.JSONSchema().Bool("additionalProperties"); err != nil || v {
+		t.Errorf("want additionalProperties false, got %v %v", v, err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+// SECRET_MASK replaces values of secret arguments in help, generated
+// documents, errors and logs, see TAG_SECRET
+const SECRET_MASK = "******"
+
+// secretArgument is implemented by arguments which may be secret
+type secretArgument interface {
+	isSecret() bool
+}
+
+func (this *SingleArgument) isSecret() bool {
+	return this.secret
+}
+
+// isSecretArgument tells whether values of arg must not be shown
+func isSecretArgument(arg Argument) bool {
+	sarg, ok := arg.(secretArgument)
+	return ok && sarg.isSecret()
+}
+
+// shownValue returns val, or SECRET_MASK if the argument is secret
+func (this *SingleArgument) shownValue(val string) string {
+	if this.secret {
+		return SECRET_MASK
+	}
+	return val
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	type Options struct {
+		Password string `secret:"true" default:"passw0rd" pattern:"^[a-z0-9]+$"`
+		Token    int    `secret:"true"`
+		Key      string `prompt:"password" help:"key of the api"`
+	}
+	t.Run("help", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		var buf bytes.Buffer
+		if err := p.GenerateConfigTemplate(&buf); err != nil {
+			t.Fatalf("GenerateConfigTemplate: %v", err)
+		}
+		for _, out := range []string{p.HelpString(), buf.String()} {
+			if strings.Contains(out, "passw0rd") {
+				t.Errorf("secret leaks:\n%s", out)
+			}
+			if !strings.Contains(out, SECRET_MASK) {
+				t.Errorf("want mask:\n%s", out)
+			}
+		}
+		if !strings.Contains(buf.String(), "\n#password =\n") {
+			t.Errorf("want assignment without value:\n%s", buf.String())
+		}
+		for _, info := range p.Arguments() {
+			if info.Token == "password" && (!info.Secret || info.Default != SECRET_MASK) {
+				t.Errorf("unexpected info %#v", info)
+			}
+		}
+		prop, _ := p.JSONSchema().Get("properties", "password")
+		if prop.Contains("default") || !prop.Contains("writeOnly") {
+			t.Errorf("unexpected schema %s", prop)
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--password", "Secret!"},
+			{"--token", "secret"},
+		} {
+			p := mustNewParser(t, &Options{})
+			err := p.ParseArgs(args, false)
+			if err == nil {
+				t.Errorf("want error of %v", args)
+				continue
+			}
+			if strings.Contains(err.Error(), "ecret") && !strings.Contains(err.Error(), "secret value") {
+				t.Errorf("secret leaks: %v", err)
+			}
+		}
+	})
+	t.Run("prompt", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		var out bytes.Buffer
+		p.promptIn = strings.NewReader("k3y\n")
+		p.promptOut = &out
+		if err := p.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Key != "k3y" || s.Password != "passw0rd" {
+			t.Errorf("unexpected options %#v", s)
+		}
+		if out.String() != "key (key of the api): " {
+			t.Errorf("unexpected prompt %q", out.String())
+		}
+	})
+	t.Run("given", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		var out bytes.Buffer
+		p.promptIn = strings.NewReader("k3y\n")
+		p.promptOut = &out
+		if err := p.ParseArgs([]string{"--key", "given"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.Key != "given" || out.Len() > 0 {
+			t.Errorf("want no prompt, got %q", out.String())
+		}
+	})
+	t.Run("export", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		if err := p.ParseArgs([]string{"--token", "42", "--key", "k3y"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := p.WriteConfig(&buf, false); err != nil {
+			t.Fatalf("WriteConfig: %v", err)
+		}
+		want := "#token =\n#key =\n#password =\n"
+		if buf.String() != want {
+			t.Errorf("want config %q, got %q", want, buf.String())
+		}
+		buf.Reset()
+		if err := p.WriteConfigUnredacted(&buf, false); err != nil {
+			t.Fatalf("WriteConfigUnredacted: %v", err)
+		}
+		want = "token = 42\nkey = k3y\npassword = passw0rd\n"
+		if buf.String() != want {
+			t.Errorf("want config %q, got %q", want, buf.String())
+		}
+		want = `{"key":"******","password":"******","token":"******"}`
+		if got := p.JSONDict(false).String(); got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+		want = `{"key":"k3y","password":"passw0rd","token":42}`
+		if got := p.UnredactedJSONDict(false).String(); got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+		if got := p.GetValue("key"); got != "k3y" {
+			t.Errorf("want k3y, got %v", got)
+		}
+		fs := flag.NewFlagSet("prog", flag.ContinueOnError)
+		if err := p.BindFlagSet(fs); err != nil {
+			t.Fatalf("BindFlagSet: %v", err)
+		}
+		if got := fs.Lookup("token").Value.String(); got != "" {
+			t.Errorf("want empty flag value, got %q", got)
+		}
+	})
+	t.Run("invalid prompt", func(t *testing.T) {
+		type Options struct {
+			Key string `prompt:"yes"`
+		}
+		if _, err := NewArgumentParser(&Options{}, "prog", "", ""); err == nil {
+			t.Errorf("want error of invalid prompt tag")
+		}
+	})
+}
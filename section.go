@@ -0,0 +1,95 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+
+	"github.com/nyl1001/pkg/util/reflectutils"
+)
+
+// sFieldWalker walks the fields of structs for walkStructFields
+type sFieldWalker struct {
+	visit func(prefix string, group string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error
+	// sections of the pointers to nested structs walked into
+	sections []*sStructSection
+	// prefixes of the tokens of nested structs walked into
+	prefixes []string
+	// types of the structs being walked, pointers to them are taken as
+	// plain fields instead of being walked into again
+	types []reflect.Type
+}
+
+// isSection tells whether fields of type tp are pointers to nested structs
+func (w *sFieldWalker) isSection(tp reflect.Type) bool {
+	if tp.Kind() != reflect.Ptr || !isStructType(tp.Elem()) {
+		return false
+	}
+	for _, t := range w.types {
+		if t == tp.Elem() {
+			return false
+		}
+	}
+	return true
+}
+
+// sStructSection is a pointer to nested struct, e.g. DB *SDBOptions.  Its
+// options are bound to the struct allocated ahead, which is only assigned
+// to the pointer once one of the options is set.  The pointer is left nil
+// otherwise, telling that the section is not configured
+type sStructSection struct {
+	// field is the pointer, ofield its value before parsing
+	field  reflect.Value
+	ofield reflect.Value
+	// value is the struct allocated, with the values pointed to by ofield
+	value reflect.Value
+}
+
+func newStructSection(field reflect.Value) *sStructSection {
+	section := &sStructSection{
+		field:  field,
+		ofield: reflect.New(field.Type()).Elem(),
+		value:  reflect.New(field.Type().Elem()),
+	}
+	section.ofield.Set(field)
+	if !field.IsNil() {
+		section.value.Elem().Set(field.Elem())
+	}
+	return section
+}
+
+// allocSections assigns the sections holding value, the field of an option,
+// to their pointers, along with the sections holding those pointers
+func (this *ArgumentParser) allocSections(value reflect.Value) {
+	if len(this.sections) == 0 || !value.CanAddr() {
+		return
+	}
+	addr := value.UnsafeAddr()
+	for _, section := range this.sections {
+		start := section.value.Pointer()
+		if addr >= start && addr < start+section.value.Type().Elem().Size() && section.field.Pointer() != start {
+			section.field.Set(section.value)
+			this.allocSections(section.field)
+		}
+	}
+}
+
+// resetSections restores the pointers of sections to their values before
+// parsing
+func (this *ArgumentParser) resetSections() {
+	for _, section := range this.sections {
+		section.field.Set(section.ofield)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"testing"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+type testSectionDB struct {
+	Host    string
+	Port    int `default:"3306"`
+	Replica *struct {
+		Host string
+	}
+}
+
+type testSectionNode struct {
+	Name string
+	Next *testSectionNode
+}
+
+func TestStructSections(t *testing.T) {
+	type Options struct {
+		Name string
+		DB   *testSectionDB
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--name", "a"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB != nil {
+			t.Errorf("db is allocated: %#v", s.DB)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--db-host", "h"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB == nil || s.DB.Host != "h" || s.DB.Port != 3306 {
+			t.Fatalf("unexpected db %#v", s.DB)
+		}
+		if s.DB.Replica != nil {
+			t.Errorf("replica is allocated: %#v", s.DB.Replica)
+		}
+		if err := p.ParseArgs([]string{"--name", "a"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB != nil {
+			t.Errorf("db is kept after parsing again: %#v", s.DB)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--db-replica-host", "r"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB == nil || s.DB.Replica == nil || s.DB.Replica.Host != "r" {
+			t.Fatalf("unexpected db %#v", s.DB)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		conf := jsonutils.Marshal(map[string]interface{}{
+			"db": map[string]interface{}{"port": 3307},
+		}).(*jsonutils.JSONDict)
+		if err := p.parseJSONDict(conf); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		if s.DB == nil || s.DB.Port != 3307 {
+			t.Fatalf("unexpected db %#v", s.DB)
+		}
+	})
+
+	t.Run("initial", func(t *testing.T) {
+		db := &testSectionDB{Host: "i"}
+		s := &Options{DB: db}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--name", "a"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB != db {
+			t.Errorf("initial db is replaced: %#v", s.DB)
+		}
+		if err := p.ParseArgs([]string{"--db-port", "1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.DB.Host != "i" || s.DB.Port != 1 || db.Port != 0 {
+			t.Errorf("unexpected db %#v, initial %#v", s.DB, db)
+		}
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--db-host", "h"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		clone, err := p.Clone()
+		if err != nil {
+			t.Fatalf("Clone: %v", err)
+		}
+		cs := clone.Options().(*Options)
+		if cs.DB != nil {
+			t.Errorf("db of clone is allocated: %#v", cs.DB)
+		}
+		if err := clone.ParseArgs([]string{"--db-host", "c"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if cs.DB == nil || cs.DB.Host != "c" || s.DB.Host != "h" {
+			t.Errorf("unexpected db %#v of clone, %#v of origin", cs.DB, s.DB)
+		}
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		s := &testSectionNode{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--next-name", "n"}, false); err == nil {
+			t.Errorf("expecting error of recursive struct")
+		}
+		if err := p.ParseArgs([]string{"--name", "n"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+	})
+}
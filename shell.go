@@ -0,0 +1,231 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ShellHandler runs the command of a line parsed by the parser of the
+// shell, e.g. by dispatching on GetLeafSubcommand
+type ShellHandler func(parser *ArgumentParser) error
+
+// Shell is an interactive mode of a parser, usually one of subcommands, e.g.
+// climc shell.  Each line read is split the way ParseString does, parsed and
+// passed to the handler.  Errors are reported and the shell goes on with the
+// next line, thus the parser is expected to be of CONTINUE_ON_ERROR.  On a
+// terminal, lines are edited with history of up and down keys and tab
+// completes the words the way Complete does.  Lines of exit or quit, unless
+// they are subcommands, and end of input leave the shell
+type Shell struct {
+	parser  *ArgumentParser
+	prompt  string
+	handler ShellHandler
+
+	in  io.Reader
+	out io.Writer
+
+	// history are the lines run so far
+	history []string
+}
+
+// NewShell creates the shell of parser, prompting with prompt, e.g.
+// "climc> "
+func NewShell(parser *ArgumentParser, prompt string, handler ShellHandler) *Shell {
+	return &Shell{
+		parser:  parser,
+		prompt:  prompt,
+		handler: handler,
+	}
+}
+
+// SetIO replaces stdin and stdout of the shell.  Lines are edited only when
+// in is a terminal, otherwise they are read as they are, e.g. from scripts
+func (this *Shell) SetIO(in io.Reader, out io.Writer) {
+	this.in = in
+	this.out = out
+}
+
+// History returns the lines run so far
+func (this *Shell) History() []string {
+	return this.history
+}
+
+func (this *Shell) input() io.Reader {
+	if this.in == nil {
+		return os.Stdin
+	}
+	return this.in
+}
+
+func (this *Shell) output() io.Writer {
+	if this.out == nil {
+		return os.Stdout
+	}
+	return this.out
+}
+
+// Run reads and runs lines until exit or end of input
+func (this *Shell) Run() error {
+	if f, ok := this.input().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return this.runTerminal(int(f.Fd()))
+	}
+	scanner := bufio.NewScanner(this.input())
+	for {
+		fmt.Fprint(this.output(), this.prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(this.output())
+			return scanner.Err()
+		}
+		if this.runLine(scanner.Text()) {
+			return nil
+		}
+	}
+}
+
+// runTerminal reads lines by the line editor of terminal fd, which is in
+// raw mode only while a line is being read
+func (this *Shell) runTerminal(fd int) error {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{this.input(), this.output()}
+	t := term.NewTerminal(rw, this.prompt)
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return this.completeLine(line, pos)
+	}
+	for {
+		if width, height, err := term.GetSize(fd); err == nil {
+			t.SetSize(width, height)
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		line, err := t.ReadLine()
+		term.Restore(fd, state)
+		if err == io.EOF {
+			fmt.Fprintln(this.output())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if this.runLine(line) {
+			return nil
+		}
+	}
+}
+
+// runLine parses and runs a line, and tells whether to leave the shell
+func (this *Shell) runLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return false
+	}
+	this.history = append(this.history, line)
+	if (line == "exit" || line == "quit") && !this.isSubcommand(line) {
+		return true
+	}
+	if err := this.parser.ParseString(line); err != nil {
+		if err != ErrHelp {
+			fmt.Fprintf(this.output(), "%s: %v\n", this.parser.prog, err)
+		}
+		return false
+	}
+	if this.parser.helpShown() || this.handler == nil {
+		return false
+	}
+	if err := this.handler(this.parser); err != nil {
+		fmt.Fprintf(this.output(), "%s: %v\n", this.parser.prog, err)
+	}
+	return false
+}
+
+func (this *Shell) isSubcommand(name string) bool {
+	subcmd := this.parser.GetSubcommand()
+	if subcmd == nil {
+		return false
+	}
+	_, ok := subcmd.subcommands[name]
+	return ok
+}
+
+// completeLine completes the word before pos of line to the longest common
+// prefix of the candidates, followed by a space if there is only one.  pos
+// is in runes, as the line editor counts
+func (this *Shell) completeLine(line string, pos int) (string, int, bool) {
+	runes := []rune(line)
+	if pos < 0 || pos > len(runes) {
+		return "", 0, false
+	}
+	before, after := string(runes[:pos]), string(runes[pos:])
+	words, err := splitShellWords(before)
+	if err != nil {
+		// e.g. inside of quotes
+		return "", 0, false
+	}
+	// a letter appended makes a new word only if the word before pos is
+	// finished, e.g. by a space
+	if more, _ := splitShellWords(before + "x"); len(more) > len(words) {
+		words = append(words, "")
+	}
+	if len(words) == 0 {
+		// e.g. inside of a comment
+		return "", 0, false
+	}
+	cur := words[len(words)-1]
+	cands := this.parser.Complete(words)
+	if len(cands) == 0 {
+		return "", 0, false
+	}
+	common := cands[0]
+	for _, cand := range cands[1:] {
+		for !strings.HasPrefix(cand, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+	if !strings.HasPrefix(common, cur) || (len(common) == len(cur) && len(cands) > 1) {
+		return "", 0, false
+	}
+	insert := escapeShellWord(common[len(cur):])
+	if len(cands) == 1 {
+		insert += " "
+	}
+	return before + insert + after, pos + utf8.RuneCountInString(insert), true
+}
+
+// escapeShellWord escapes the characters of str split by splitShellWords
+func escapeShellWord(str string) string {
+	var buf strings.Builder
+	for i := 0; i < len(str); i++ {
+		if strings.IndexByte(" \t\r\n'\"\\#", str[i]) >= 0 {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(str[i])
+	}
+	return buf.String()
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testShellCreateOptions struct {
+	NAME   string
+	Flavor string `choices:"small|large|large-ssd"`
+}
+
+func newTestShell(t *testing.T, handler ShellHandler) *Shell {
+	s := &struct {
+		Debug      bool
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	if _, err := subcmd.AddSubParser(&testShellCreateOptions{}, "create", "Create", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if _, err := subcmd.AddSubParser(&struct{}{}, "list", "List", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	return NewShell(p, "prog> ", handler)
+}
+
+func TestShell(t *testing.T) {
+	var runs []string
+	sh := newTestShell(t, func(parser *ArgumentParser) error {
+		subparser := parser.GetLeafSubcommand().GetSubParser()
+		if opts, ok := subparser.Options().(*testShellCreateOptions); ok {
+			if opts.NAME == "bad" {
+				return fmt.Errorf("cannot create %s", opts.NAME)
+			}
+			runs = append(runs, fmt.Sprintf("create %s %s", opts.NAME, opts.Flavor))
+		} else {
+			runs = append(runs, "list")
+		}
+		return nil
+	})
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join([]string{
+		"create 'host 1' --flavor small",
+		"",
+		"# comment",
+		"list",
+		"create host2",
+		"create --flavor tiny host3",
+		"create bad",
+		"create --help",
+		"exit",
+		"list",
+	}, "\n"))
+	sh.SetIO(in, &out)
+	sh.parser.SetOutput(&out)
+	if err := sh.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := []string{"create host 1 small", "list", "create host2 "}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("runs: want %q, got %q", want, runs)
+	}
+	if len(sh.History()) != 7 || sh.History()[6] != "exit" {
+		t.Errorf("unexpected history %q", sh.History())
+	}
+	for _, want := range []string{"prog> ", "prog: ", "tiny", "cannot create bad", "Usage: prog create"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	t.Run("end of input", func(t *testing.T) {
+		sh := newTestShell(t, nil)
+		var out bytes.Buffer
+		sh.SetIO(strings.NewReader("list"), &out)
+		if err := sh.Run(); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if out.String() != "prog> prog> \n" {
+			t.Errorf("unexpected output %q", out.String())
+		}
+	})
+}
+
+func TestShellComplete(t *testing.T) {
+	sh := newTestShell(t, nil)
+	cases := []struct {
+		line    string
+		pos     int
+		want    string
+		wantPos int
+	}{
+		{"cr", 2, "create ", 7},
+		{"create --fl", 11, "create --flavor ", 16},
+		{"create --flavor l", 17, "create --flavor large", 21},
+		{"cr host", 2, "create  host", 7},
+		{"create 'é' --flavor l", 21, "create 'é' --flavor large", 25},
+	}
+	for _, c := range cases {
+		line, pos, ok := sh.completeLine(c.line, c.pos)
+		if !ok || line != c.want || pos != c.wantPos {
+			t.Errorf("%q: want %q at %d, got %q at %d %v", c.line, c.want, c.wantPos, line, pos, ok)
+		}
+	}
+	for _, line := range []string{"", "x", "#", "create --flavor large", "create --flavor 'l"} {
+		if got, _, ok := sh.completeLine(line, len(line)); ok {
+			t.Errorf("%q: expecting no completion, got %q", line, got)
+		}
+	}
+	if got := escapeShellWord(`a b'c`); got != `a\ b\'c` {
+		t.Errorf("escapeShellWord: got %q", got)
+	}
+}
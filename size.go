@@ -0,0 +1,97 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes accepting unit suffixes, e.g. 512M, 2GiB and
+// 1024k.  Units are powers of 1024, case insensitive, with optional i and B,
+// i.e. k, K, Ki, KB and KiB are all 1024
+type Size int64
+
+const (
+	UNIT_BYTES = "bytes"
+)
+
+var sizeUnits = []string{"", "K", "M", "G", "T", "P", "E"}
+
+func init() {
+	RegisterTypeParser(reflect.TypeOf(Size(0)), func(val string) (interface{}, error) {
+		size, err := ParseSize(val)
+		return Size(size), err
+	})
+}
+
+// ParseSize parses size with unit suffix into number of bytes
+func ParseSize(val string) (int64, error) {
+	str := strings.TrimSpace(val)
+	end := len(str)
+	for end > 0 && (str[end-1] < '0' || str[end-1] > '9') && str[end-1] != '.' {
+		end--
+	}
+	num, unit := str[:end], strings.ToUpper(strings.TrimSpace(str[end:]))
+	unit = strings.TrimSuffix(unit, "B")
+	if len(unit) > 1 {
+		if !strings.HasSuffix(unit, "I") {
+			return 0, fmt.Errorf("invalid size %q, unknown unit", val)
+		}
+		unit = unit[:len(unit)-1]
+	}
+	exp := -1
+	for i, u := range sizeUnits {
+		if u == unit {
+			exp = i
+			break
+		}
+	}
+	if exp < 0 || len(num) == 0 {
+		return 0, fmt.Errorf("invalid size %q, expecting number with optional unit, e.g. 512M, 2GiB", val)
+	}
+	mul := math.Pow(1024, float64(exp))
+	if i, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if float64(i)*mul > math.MaxInt64 {
+			return 0, fmt.Errorf("size %q overflows", val)
+		}
+		return i * int64(mul), nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", val, err)
+	}
+	if f*mul > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q overflows", val)
+	}
+	return int64(f * mul), nil
+}
+
+// String formats size with the largest unit dividing it exactly, e.g. 512MiB
+func (size Size) String() string {
+	val := int64(size)
+	i := 0
+	for i+1 < len(sizeUnits) && val != 0 && val%1024 == 0 {
+		val /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatInt(val, 10)
+	}
+	return fmt.Sprintf("%d%siB", val, sizeUnits[i])
+}
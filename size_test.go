@@ -0,0 +1,97 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100B", 100},
+		{"1024k", 1024 * 1024},
+		{"1K", 1024},
+		{"1KB", 1024},
+		{"1KiB", 1024},
+		{"512M", 512 << 20},
+		{"512mi", 512 << 20},
+		{"2GiB", 2 << 30},
+		{"1.5G", 3 << 29},
+		{"1T", 1 << 40},
+	}
+	for _, c := range cases {
+		got, err := ParseSize(c.in)
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", c.in, err)
+		} else if got != c.want {
+			t.Errorf("ParseSize(%q): want %d, got %d", c.in, c.want, got)
+		}
+	}
+	for _, in := range []string{"", "M", "12X", "1GX", "9E", "1.2.3M"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q): expecting error", in)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	for size, want := range map[Size]string{
+		0:         "0",
+		100:       "100",
+		1024:      "1KiB",
+		512 << 20: "512MiB",
+		1536:      "1536",
+		3 << 30:   "3GiB",
+	} {
+		if got := size.String(); got != want {
+			t.Errorf("%d: want %s, got %s", size, want, got)
+		}
+	}
+}
+
+func TestSizeField(t *testing.T) {
+	s := &struct {
+		Memory Size   `default:"1G"`
+		Disk   int64  `unit:"bytes"`
+		Quota  uint32 `unit:"bytes"`
+		Caches []Size
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--disk", "20GiB", "--quota", "1M", "--caches", "64k", "--caches", "1M"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Memory != 1<<30 || s.Disk != 20<<30 || s.Quota != 1<<20 || len(s.Caches) != 2 || s.Caches[0] != 64<<10 {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if got := p.JSONDict(false).String(); got != `{"caches":["64KiB","1MiB"],"disk":21474836480,"memory":"1GiB","quota":1048576}` {
+		t.Errorf("JSONDict: got %s", got)
+	}
+	if err := p.ParseArgs([]string{"--quota", "8G"}, false); err == nil {
+		t.Errorf("expecting out of range error")
+	}
+	t.Run("bad unit tag", func(t *testing.T) {
+		s := &struct {
+			Disk string `unit:"bytes"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for unit on string")
+		}
+	})
+}
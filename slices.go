@@ -0,0 +1,126 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// startValue prepares the value for the first of the values collected
+// since Reset, see TAG_SLICE_MODE.  The value is cleared to be replaced, set
+// to a copy of the default to be appended to, or restored to the initial
+// value in case SetDefault is called already
+func (this *MultiArgument) startValue() {
+	if this.isSet || this.started {
+		return
+	}
+	switch this.sliceMode {
+	case SLICE_MODE_APPEND:
+		this.copyDefault()
+	case SLICE_MODE_REPLACE:
+		this.started = true
+		this.value.Set(reflect.Zero(this.value.Type()))
+	default:
+		this.Reset()
+		this.started = true
+	}
+}
+
+// appendsValues tells whether values of arg from all sources are collected
+func appendsValues(arg Argument) bool {
+	marg, ok := arg.(*MultiArgument)
+	return ok && marg.sliceMode == SLICE_MODE_APPEND
+}
+
+// parseItems parses the unique, minitems and maxitems tags
+func (this *MultiArgument) parseItems(unique, min, max string) error {
+	if len(unique) > 0 {
+		if valueIsMap(this.value) {
+			return fmt.Errorf("unique is applicable to slice option ONLY")
+		}
+		if unique == UNIQUE_ERROR {
+			this.unique, this.uniqueError = true, true
+		} else if b, err := strconv.ParseBool(unique); err == nil {
+			this.unique = b
+		} else {
+			return fmt.Errorf("invalid unique %q, expecting true, false or %q", unique, UNIQUE_ERROR)
+		}
+	}
+	var err error
+	if len(min) > 0 {
+		if this.minItems, err = strconv.Atoi(min); err != nil || this.minItems < 0 {
+			return fmt.Errorf("invalid minitems %q", min)
+		}
+	}
+	if len(max) > 0 {
+		if this.maxItems, err = strconv.Atoi(max); err != nil || this.maxItems <= 0 {
+			return fmt.Errorf("invalid maxitems %q", max)
+		}
+		if this.minItems > this.maxItems {
+			return fmt.Errorf("minitems %d exceeds maxitems %d", this.minItems, this.maxItems)
+		}
+	}
+	return nil
+}
+
+// dropDuplicate removes the value val just appended if it is there already
+func (this *MultiArgument) dropDuplicate(val string) error {
+	n := this.value.Len()
+	last := this.value.Index(n - 1).Interface()
+	for i := 0; i < n-1; i++ {
+		if reflect.DeepEqual(this.value.Index(i).Interface(), last) {
+			this.value.Set(this.value.Slice(0, n-1))
+			if this.uniqueError {
+				return &DuplicateValueError{Argument: this.Token(), Value: this.shownValue(val)}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkItems checks the number of items given against minitems and
+// maxitems.  Values left to the default are not checked
+func (this *MultiArgument) checkItems() error {
+	if !this.isSet {
+		return nil
+	}
+	n := this.value.Len()
+	if (this.minItems > 0 && n < this.minItems) || (this.maxItems > 0 && n > this.maxItems) {
+		return &ItemCountError{Argument: this.Token(), Count: n, Min: this.minItems, Max: this.maxItems}
+	}
+	return nil
+}
+
+func (this *MultiArgument) jsonSchema() *jsonutils.JSONDict {
+	schema := this.SingleArgument.jsonSchema()
+	if valueIsMap(this.value) {
+		return schema
+	}
+	if this.minItems > 0 {
+		schema.Set("minItems", jsonutils.NewInt(int64(this.minItems)))
+	}
+	if this.maxItems > 0 {
+		schema.Set("maxItems", jsonutils.NewInt(int64(this.maxItems)))
+	}
+	if this.unique {
+		schema.Set("uniqueItems", jsonutils.JSONTrue)
+	}
+	return schema
+}
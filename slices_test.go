@@ -0,0 +1,184 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+func TestSliceMode(t *testing.T) {
+	type Options struct {
+		Plain   []string `default:"d"`
+		Replace []string `default:"d" slice-mode:"replace"`
+		Append  []string `default:"d" slice-mode:"append" env:"STRUCTARG_TEST_APPEND"`
+
+		InitPlain   []string
+		InitReplace []string          `slice-mode:"replace"`
+		InitAppend  []string          `slice-mode:"append"`
+		Labels      map[string]string `slice-mode:"append"`
+	}
+	newOptions := func() *Options {
+		return &Options{
+			InitPlain:   []string{"i"},
+			InitReplace: []string{"i"},
+			InitAppend:  []string{"i"},
+			Labels:      map[string]string{"i": "0"},
+		}
+	}
+	conf := jsonutils.Marshal(map[string][]string{
+		"plain":   {"c"},
+		"replace": {"c"},
+		"append":  {"c"},
+	}).(*jsonutils.JSONDict)
+
+	t.Run("command line", func(t *testing.T) {
+		s := newOptions()
+		p := mustNewParser(t, s)
+		args := []string{"--plain", "a", "--replace", "a", "--append", "a",
+			"--init-plain", "a", "--init-replace", "a", "--init-append", "a", "--labels", "k=v"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p.parseJSONDict(conf); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		want := &Options{
+			Plain:       []string{"a"},
+			Replace:     []string{"a"},
+			Append:      []string{"d", "a", "c"},
+			InitPlain:   []string{"i", "a"},
+			InitReplace: []string{"a"},
+			InitAppend:  []string{"i", "a"},
+			Labels:      map[string]string{"i": "0", "k": "v"},
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		os.Setenv("STRUCTARG_TEST_APPEND", "e")
+		defer os.Unsetenv("STRUCTARG_TEST_APPEND")
+		s := newOptions()
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p.parseJSONDict(conf); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		for name, want := range map[string][]string{
+			"plain":   {"c"},
+			"replace": {"c"},
+			"append":  {"d", "e", "c"},
+		} {
+			if got := p.GetValue(name); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: want %v, got %v", name, want, got)
+			}
+		}
+		defaults := *newOptions()
+		if err := p.ParseArgs(nil, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !reflect.DeepEqual(s.InitPlain, defaults.InitPlain) || !reflect.DeepEqual(s.Append, []string{"d", "e"}) {
+			t.Errorf("wrong values after reparse %#v", s)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, target := range []interface{}{
+			&struct {
+				Tags []string `slice-mode:"merge"`
+			}{},
+			&struct {
+				Tag string `slice-mode:"append"`
+			}{},
+		} {
+			if _, err := NewArgumentParser(target, "", "", ""); err == nil {
+				t.Errorf("%T: expecting error", target)
+			}
+		}
+	})
+}
+
+func TestUniqueItems(t *testing.T) {
+	s := &struct {
+		SecurityGroup []string          `unique:"true" maxitems:"3"`
+		Port          []int             `unique:"error"`
+		Disk          []string          `minitems:"2"`
+		Labels        map[string]string `maxitems:"1"`
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--security-group", "sg1", "--security-group", "sg2", "--security-group", "sg1", "--port", "80", "--port", "443"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if !reflect.DeepEqual(s.SecurityGroup, []string{"sg1", "sg2"}) || !reflect.DeepEqual(s.Port, []int{80, 443}) {
+		t.Errorf("wrong values %#v", s)
+	}
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--port", "80", "--port", "80"}, "Duplicate value 80 of port"},
+		{[]string{"--security-group", "a", "--security-group", "b", "--security-group", "c", "--security-group", "d"}, "at most 3 items, got 4"},
+		{[]string{"--disk", "d1"}, "at least 2 items, got 1"},
+		{[]string{"--labels", "a=1", "--labels", "b=2"}, "at most 1 items, got 2"},
+	} {
+		err := p.ParseArgs(c.args, false)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: expecting error %q, got %v", c.args, c.want, err)
+		}
+	}
+
+	t.Run("schema", func(t *testing.T) {
+		schema := p.JSONSchema()
+		prop, err := schema.Get("properties", "security_group")
+		if err != nil {
+			t.Fatalf("no property security_group: %s", schema)
+		}
+		if unique, _ := prop.Bool("uniqueItems"); !unique {
+			t.Errorf("missing uniqueItems: %s", prop)
+		}
+		if max, _ := prop.Int("maxItems"); max != 3 {
+			t.Errorf("missing maxItems: %s", prop)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, target := range []interface{}{
+			&struct {
+				Tags []string `unique:"yes please"`
+			}{},
+			&struct {
+				Tags map[string]string `unique:"true"`
+			}{},
+			&struct {
+				Tags []string `minitems:"3" maxitems:"2"`
+			}{},
+			&struct {
+				Tag string `maxitems:"2"`
+			}{},
+		} {
+			if _, err := NewArgumentParser(target, "", "", ""); err == nil {
+				t.Errorf("%T: expecting error", target)
+			}
+		}
+	})
+}
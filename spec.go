@@ -0,0 +1,115 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/gotypes"
+	"github.com/nyl1001/pkg/util/reflectutils"
+)
+
+// ArgumentSpec describes an argument not backed by a field of the target,
+// e.g. options contributed by plugins at runtime.  The fields mirror the
+// tags of struct fields
+type ArgumentSpec struct {
+	// Token of the argument, e.g. "log-level"
+	Token string
+	// Type of the value, string if nil
+	Type reflect.Type
+	Help string
+	// Default is parsed as the default tag is
+	Default    string
+	Choices    []string
+	Short      string
+	Metavar    string
+	Env        string
+	Group      string
+	Positional bool
+	Required   bool
+	Hidden     bool
+	// Tags are the other tags of the argument, e.g. {"min": "1"}
+	Tags map[string]string
+}
+
+// tags returns the tag map equivalent to the spec
+func (spec *ArgumentSpec) tags() map[string]string {
+	tags := make(map[string]string)
+	for k, v := range spec.Tags {
+		tags[k] = v
+	}
+	for k, v := range map[string]string{
+		TAG_TOKEN:       spec.Token,
+		TAG_HELP:        spec.Help,
+		TAG_DEFAULT:     spec.Default,
+		TAG_CHOICES:     strings.Join(spec.Choices, "|"),
+		TAG_SHORT_TOKEN: spec.Short,
+		TAG_METAVAR:     spec.Metavar,
+		TAG_ENV:         spec.Env,
+		TAG_GROUP:       spec.Group,
+	} {
+		if len(v) > 0 {
+			tags[k] = v
+		}
+	}
+	tags[TAG_POSITIONAL] = fmt.Sprintf("%t", spec.Positional)
+	if spec.Required {
+		tags[TAG_REQUIRED] = "true"
+	}
+	if spec.Hidden {
+		tags[TAG_HIDDEN] = "true"
+	}
+	return tags
+}
+
+// AddArgumentSpec adds an argument described by spec.  Its value is kept by
+// the parser instead of the target and retrieved by GetValue
+func (this *ArgumentParser) AddArgumentSpec(spec ArgumentSpec) error {
+	if len(spec.Token) == 0 {
+		return fmt.Errorf("Empty token of argument spec")
+	}
+	tp := spec.Type
+	if tp == nil {
+		tp = reflect.TypeOf("")
+	}
+	if tp.Kind() == reflect.Struct && tp != gotypes.TimeType && !isScalarType(tp) {
+		return fmt.Errorf("argument %s: struct type %s is not supported", spec.Token, tp)
+	}
+	info := reflectutils.ParseFieldJsonInfo(spec.Token, "")
+	info.Tags = spec.tags()
+	err := this.addArgument("", "", reflect.New(tp).Elem(), &info)
+	if err != nil {
+		return errors.Wrapf(err, "argument %s", spec.Token)
+	}
+	return nil
+}
+
+// GetValue returns the value of the argument of token name, e.g. those
+// added by AddArgumentSpec, or nil if there is no such argument.  Values of
+// secret arguments are returned as they are
+func (this *ArgumentParser) GetValue(name string) interface{} {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	if arg == nil {
+		return nil
+	}
+	varg, ok := arg.(valueArgument)
+	if !ok {
+		return nil
+	}
+	return varg.getValue().Interface()
+}
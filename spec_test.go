@@ -0,0 +1,92 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddArgumentSpec(t *testing.T) {
+	type Options struct {
+		Debug bool
+	}
+	newParser := func(t *testing.T) (*ArgumentParser, *Options) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		for _, spec := range []ArgumentSpec{
+			{Token: "plugin-mode", Help: "mode of plugin", Default: "fast", Choices: []string{"fast", "safe"}},
+			{Token: "plugin-timeout", Type: reflect.TypeOf(time.Duration(0)), Default: "3s"},
+			{Token: "plugin-tag", Type: reflect.TypeOf([]string{}), Short: "t"},
+			{Token: "plugin-workers", Type: reflect.TypeOf(0), Tags: map[string]string{TAG_MIN: "1"}},
+		} {
+			if err := p.AddArgumentSpec(spec); err != nil {
+				t.Fatalf("AddArgumentSpec %s: %v", spec.Token, err)
+			}
+		}
+		return p, s
+	}
+	t.Run("parse", func(t *testing.T) {
+		p, s := newParser(t)
+		err := p.ParseArgs([]string{"--debug", "--plugin-mode", "safe", "-t", "a", "-t", "b", "--plugin-workers", "2"}, false)
+		if err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if !s.Debug {
+			t.Errorf("want debug set")
+		}
+		for name, want := range map[string]interface{}{
+			"plugin-mode":    "safe",
+			"PluginTimeout":  3 * time.Second,
+			"plugin-tag":     []string{"a", "b"},
+			"plugin-workers": 2,
+			"debug":          true,
+			"plugin-unknown": nil,
+		} {
+			if got := p.GetValue(name); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: want %#v, got %#v", name, want, got)
+			}
+		}
+		if help := p.HelpString(); !strings.Contains(help, "mode of plugin (default: fast)") {
+			t.Errorf("help without plugin-mode:\n%s", help)
+		}
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		p, _ := newParser(t)
+		for _, args := range [][]string{
+			{"--plugin-mode", "slow"},
+			{"--plugin-workers", "0"},
+		} {
+			if err := p.ParseArgs(args, false); err == nil {
+				t.Errorf("%v: want error", args)
+			}
+		}
+	})
+	t.Run("invalid spec", func(t *testing.T) {
+		p, _ := newParser(t)
+		for _, spec := range []ArgumentSpec{
+			{},
+			{Token: "debug"},
+			{Token: "plugin-level", Default: "1", Required: true},
+			{Token: "plugin-conf", Type: reflect.TypeOf(Options{})},
+		} {
+			if err := p.AddArgumentSpec(spec); err == nil {
+				t.Errorf("%#v: want error", spec)
+			}
+		}
+	})
+}
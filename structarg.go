@@ -17,16 +17,21 @@ package structarg
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/nyl1001/pkg/errors"
-	"github.com/nyl1001/pkg/gotypes"
 	"github.com/nyl1001/pkg/jsonutils"
 	"github.com/nyl1001/pkg/util/reflectutils"
 	"github.com/nyl1001/pkg/utils"
@@ -61,38 +66,207 @@ type Argument interface {
 	IsSet() bool
 }
 
+// aliasArgument is implemented by arguments of more than one alias token
+type aliasArgument interface {
+	AliasTokens() []string
+}
+
+func argumentAliasTokens(arg Argument) []string {
+	if aarg, ok := arg.(aliasArgument); ok {
+		return aarg.AliasTokens()
+	}
+	if alias := arg.AliasToken(); len(alias) > 0 {
+		return []string{alias}
+	}
+	return nil
+}
+
+// envArgument is implemented by arguments bound to environment variables
+type envArgument interface {
+	EnvName() string
+}
+
+func argumentEnvName(arg Argument) string {
+	if earg, ok := arg.(envArgument); ok {
+		return earg.EnvName()
+	}
+	return ""
+}
+
+// groupArgument is implemented by arguments shown under groups of help
+type groupArgument interface {
+	Group() string
+}
+
+func argumentGroup(arg Argument) string {
+	if garg, ok := arg.(groupArgument); ok {
+		return garg.Group()
+	}
+	return ""
+}
+
+// requiresArgument is implemented by arguments requiring others to be set
+type requiresArgument interface {
+	Requires() []string
+}
+
+func argumentRequires(arg Argument) []string {
+	if rarg, ok := arg.(requiresArgument); ok {
+		return rarg.Requires()
+	}
+	return nil
+}
+
+// hiddenArgument is implemented by arguments which may be left out of help
+type hiddenArgument interface {
+	IsHidden() bool
+}
+
+func isHiddenArgument(arg Argument) bool {
+	harg, ok := arg.(hiddenArgument)
+	return ok && harg.IsHidden()
+}
+
+// deprecatedArgument is implemented by arguments which may be deprecated,
+// optionally in favor of a replacement
+type deprecatedArgument interface {
+	Deprecated() string
+	Replacement() string
+}
+
+func argumentDeprecated(arg Argument) string {
+	if darg, ok := arg.(deprecatedArgument); ok {
+		return darg.Deprecated()
+	}
+	return ""
+}
+
+func argumentReplacement(arg Argument) string {
+	if darg, ok := arg.(deprecatedArgument); ok {
+		return darg.Replacement()
+	}
+	return ""
+}
+
+// sourceArgument is implemented by arguments tracking where their values
+// come from
+type sourceArgument interface {
+	Source() string
+}
+
+func argumentSource(arg Argument) string {
+	if sarg, ok := arg.(sourceArgument); ok {
+		return sarg.Source()
+	}
+	return ""
+}
+
 type SingleArgument struct {
 	token      string
 	aliasToken string
 	shortToken string
 	negaToken  string
+	// tokens are the normalized forms of the above
+	tokens     sArgumentTokens
 	metavar    string
-	positional bool
-	required   bool
-	help       string
-	choices    []string
-	useDefault bool
-	defValue   reflect.Value
-	value      reflect.Value
-	ovalue     reflect.Value
-	isSet      bool
-	parser     *ArgumentParser
+	env        string
+	group      string
+	requires   []string
+	deprecated string
+	// token of the argument taking over values of the deprecated one
+	replacement string
+	positional  bool
+	required    bool
+	hidden      bool
+	count       bool
+	help        string
+	choices     []string
+	// choicesFunc returns the choices at parse time instead, see
+	// ArgumentParser.SetChoicesFunc
+	choicesFunc func(ctx context.Context) []string
+	// choiceValues are the choices converted to values of non-string
+	// arguments, compared with the converted value
+	choiceValues []reflect.Value
+	// enum tells that choices are names of choiceValues, see TAG_ENUM
+	enum bool
+	// ignoreCase matches choices case insensitively, see
+	// TAG_CHOICES_IGNORECASE
+	ignoreCase bool
+	layouts    []string
+	// allowed schemes of url.URL value
+	schemes []string
+	// unit of integer value, e.g. UNIT_BYTES
+	unit string
+	// check of path value, e.g. FILE_EXIST
+	file string
+	// value of the form @path is read from the file
+	fromFile bool
+	// pattern string values must match
+	pattern *regexp.Regexp
+	// inclusive range of numeric values, invalid if unlimited
+	minValue reflect.Value
+	maxValue reflect.Value
+	// limits of length of string values in characters, 0 if unlimited
+	minLen int
+	maxLen int
+	// secret values are masked, see TAG_SECRET
+	secret bool
+	// prompt for the value if it is not given, see TAG_PROMPT
+	prompt bool
+	// defaultStr is the default value as in the tag or given to
+	// ArgumentParser.SetArgumentDefault, shown in help
+	defaultStr string
+	// parseFunc of the parser tag converts strings into values
+	parseFunc reflect.Value
+	// defaultFunc computes the default value when it is needed first, see
+	// ArgumentParser.SetDefaultFunc
+	defaultFunc func(ctx context.Context) (string, error)
+	useDefault  bool
+	defValue    reflect.Value
+	value       reflect.Value
+	ovalue      reflect.Value
+	isSet       bool
+	// started tells that values are being collected into value, see
+	// MultiArgument.startValue
+	started bool
+	// source of the value when isSet, see ArgumentParser.Source
+	source string
+	parser *ArgumentParser
 }
 
 type MultiArgument struct {
 	SingleArgument
 	minCount int64
 	maxCount int64
+	// separator of key and value for map argument
+	separator string
+	// sliceMode is SLICE_MODE_REPLACE, SLICE_MODE_APPEND or empty
+	sliceMode string
+	// unique drops duplicate values, or reports them with uniqueError
+	unique      bool
+	uniqueError bool
+	// limits of the number of items, 0 if unlimited
+	minItems int
+	maxItems int
+	// itemTemplate is the parser of struct elements, nil for other types.
+	// items are the parsers of the elements given, appended to the first
+	// itemBase elements of the value
+	itemTemplate *ArgumentParser
+	items        []*ArgumentParser
+	itemBase     int
 }
 
 type SubcommandArgumentData struct {
 	parser   *ArgumentParser
 	callback reflect.Value
+	aliases  []string
 }
 
 type SubcommandArgument struct {
 	SingleArgument
 	subcommands map[string]SubcommandArgumentData
+	// aliases maps alias names to the canonical subcommand names
+	aliases map[string]string
 }
 
 type ArgumentParser struct {
@@ -101,17 +275,114 @@ type ArgumentParser struct {
 	description string
 	epilog      string
 	help        bool
+	lenient     bool
+	envPrefix   string
 	optArgs     []Argument
 	posArgs     []Argument
+	// completers of argument values, keyed by argument token in kebab-case
+	// regardless of the naming strategy
+	completers map[string]func(prefix string) []string
+	// rest keeps the unrecognized arguments when unknown ones are ignored
+	rest []string
+	// restField collects the unrecognized options, see TAG_STRUCTARG
+	restField reflect.Value
+	// uncacheable arguments depend on more than the type of the target,
+	// e.g. environment variables in defaults, see newCachedArgumentParser
+	uncacheable bool
+	// tokens indexes optional arguments, see tokenIndex
+	tokens *sTokenIndex
+	// warnWriter receives warnings of deprecated arguments, os.Stderr if nil
+	warnWriter io.Writer
+	// source of the values being set, e.g. SOURCE_COMMAND_LINE
+	source string
+	// @file arguments are expanded into the tokens of the file
+	responseFiles bool
+	// collectErrors keeps parsing after errors to report all of them
+	collectErrors bool
+	// versionSet is set after --version printed the version
+	versionSet bool
+	// noHelpDecorations stops appending defaults, choices, env and the like
+	// to help of arguments
+	noHelpDecorations bool
+	// templates of Usage and HelpString, the default ones if nil
+	usageTemplate *template.Template
+	helpTemplate  *template.Template
+	// confFiles are the .conf files being parsed, the including ones first
+	confFiles []string
+	// confSources are the configuration files parsed so far by ParseFiles
+	// or ParseTornadoFile, values from them are overridden by later ones
+	confSources map[string]bool
+	// strictConfig makes unknown keys of configuration errors
+	strictConfig bool
+	// unknownKeyHandler is called with unknown keys of configuration
+	unknownKeyHandler func(source, key string) error
+	// expandEnv expands environment variables in values of configuration
+	expandEnv bool
+	// configLoads are the calls parsing configuration files, see Reload
+	configLoads []sConfigLoad
+	// optionsLock guards the target against Reload and concurrent parses
+	optionsLock sync.RWMutex
+	// disabledArgs are removed by DisableArgument, still set to defaults
+	disabledArgs []Argument
+	// output receives help, version and completion, os.Stdout if nil
+	output io.Writer
+	// errorHandling decides what ParseArgs2 does with errors
+	errorHandling ErrorHandling
+	// noHelp stops handling --help, see SetHelpEnabled
+	noHelp bool
+	// interactive prompts for missing required arguments, see SetInteractive
+	interactive bool
+	// choicesIgnoreCase matches choices of all arguments case
+	// insensitively, see SetChoicesIgnoreCase
+	choicesIgnoreCase bool
+	// naming derives tokens of arguments, see SetNamingStrategy
+	naming NamingStrategy
+	// abbreviation decides how options abbreviate tokens, see
+	// SetAbbreviationMode
+	abbreviation AbbreviationMode
+	// optionsFirst ends options at the first positional, see
+	// SetInterspersed
+	optionsFirst bool
+	// ctx is that of the parse in progress of ParseArgsContext
+	ctx context.Context
+	// helpCommand registers the help subcommand and makes parses showing
+	// help return ErrHelp, see SetHelpCommand
+	helpCommand bool
+	// sections are the pointers to nested structs, allocated when their
+	// options are set
+	sections []*sStructSection
+	// structPrefixes are the token prefixes of nested structs, e.g. db- of
+	// --db-host, which take inline documents, see findInlineArgument
+	structPrefixes []string
+	// promptIn and promptOut replace stdin and stderr of prompts
+	promptIn  io.Reader
+	promptOut io.Writer
+	promptBuf *bufio.Reader
 }
 
+const (
+	SOURCE_COMMAND_LINE = "command line"
+	SOURCE_ENV          = "environment"
+	SOURCE_DEFAULT      = "default"
+	SOURCE_UPDATE       = "update"
+	SOURCE_QUERY        = "query"
+)
+
+// sHelpArg is the help argument added by the constructor, --help unless
+// SetHelpToken renames it
 type sHelpArg struct {
+	token string
+	short string
 }
 
 func (self *sHelpArg) AliasToken() string {
 	return ""
 }
 
+func (self *sHelpArg) AliasTokens() []string {
+	return nil
+}
+
 func (self *sHelpArg) DoAction(nega bool) error {
 	return nil
 }
@@ -125,11 +396,14 @@ func (self *sHelpArg) NeedData() bool {
 }
 
 func (self *sHelpArg) Token() string {
-	return "help"
+	if len(self.token) == 0 {
+		return "help"
+	}
+	return self.token
 }
 
 func (self *sHelpArg) ShortToken() string {
-	return ""
+	return self.short
 }
 
 func (self *sHelpArg) NegativeToken() string {
@@ -157,7 +431,10 @@ func (self *sHelpArg) IsSubcommand() bool {
 }
 
 func (self *sHelpArg) String() string {
-	return "[--help]"
+	if len(self.ShortToken()) > 0 {
+		return fmt.Sprintf("[--%s|-%s]", self.Token(), self.ShortToken())
+	}
+	return fmt.Sprintf("[--%s]", self.Token())
 }
 
 func (self *sHelpArg) SetValue(val string) error {
@@ -180,15 +457,55 @@ func (self *sHelpArg) IsSet() bool {
 	return false
 }
 
+func (self *sHelpArg) EnvName() string {
+	return ""
+}
+
+func (self *sHelpArg) Group() string {
+	return ""
+}
+
+func (self *sHelpArg) Requires() []string {
+	return nil
+}
+
+func (self *sHelpArg) IsHidden() bool {
+	return false
+}
+
+func (self *sHelpArg) Deprecated() string {
+	return ""
+}
+
+func (self *sHelpArg) Replacement() string {
+	return ""
+}
+
+func (self *sHelpArg) Source() string {
+	return ""
+}
+
 func newArgumentParser(target interface{}, prog, desc, epilog string) (*ArgumentParser, error) {
-	parser := ArgumentParser{prog: prog, description: desc,
-		epilog: epilog, target: target}
 	targetValue := reflect.ValueOf(target)
 	if targetValue.Kind() != reflect.Ptr {
 		return nil, fmt.Errorf("target must be a pointer")
 	}
-	targetValue = targetValue.Elem()
-	e := parser.addStructArgument("", targetValue)
+	if parser := newCachedArgumentParser(target, prog, desc, epilog); parser != nil {
+		return parser, nil
+	}
+	return buildArgumentParser(target, prog, desc, epilog)
+}
+
+// buildArgumentParser builds the parser of target from its fields and tags
+func buildArgumentParser(target interface{}, prog, desc, epilog string) (*ArgumentParser, error) {
+	parser := ArgumentParser{prog: prog, description: desc,
+		epilog: epilog, target: target}
+	targetValue := reflect.ValueOf(target).Elem()
+	e := parser.addStructArgument("", "", targetValue)
+	if e != nil {
+		return nil, e
+	}
+	e = parser.checkReferences()
 	if e != nil {
 		return nil, e
 	}
@@ -219,7 +536,7 @@ const (
 	   if the tag is missing, the variable name will be used as token.
 	   If the variable name is CamelCase, the token will be transformed
 	   into kebab-case, e.g. if the variable is "AuthURL", the token will
-	   be "--auth-url"
+	   be "--auth-url".  token:"-" excludes the field as structarg:"-" does
 	*/
 	TAG_TOKEN = "token"
 	/*
@@ -228,19 +545,27 @@ const (
 	   the tag is optional
 	*/
 	TAG_SHORT_TOKEN = "short-token"
+	/*
+	   Alias of short-token, e.g. short:"v"
+	*/
+	TAG_SHORT = "short"
 	/*
 	   Metavar of the argument
 	   the tag is optional
 	*/
 	TAG_METAVAR = "metavar"
 	/*
-	   The default value of the argument.
+	   The default value of the argument.  References to environment
+	   variables, ${VAR} and $VAR, are expanded and $$ stands for $, e.g.
+	   `default:"$HOME/.prog"`.  Alternatives concatenated by "|" are tried
+	   in order until one is not empty, e.g. `default:"$PROG_HOME|/etc/prog"`
 	   the tag is optional
 	*/
 	TAG_DEFAULT = "default"
 	/*
 	   The possible values of an arguments. All choices are are concatenatd by "|".
-	   e.g. `choices:"1|2|3"`
+	   e.g. `choices:"1|2|3"`.  Choices of non-string arguments must be valid
+	   values, given values are converted before being compared with them
 	   the tag is optional
 	*/
 	TAG_CHOICES = "choices"
@@ -272,7 +597,8 @@ const (
 	*/
 	TAG_NARGS = "nargs"
 	/*
-		Alias name of argument
+		Alias names of argument, concatenated by ",", e.g.
+		`token:"endpoint" alias:"url,server"` also accepts --url and --server
 	*/
 	TAG_ALIAS = "alias"
 	/*
@@ -283,12 +609,257 @@ const (
 	   Token for ignore
 	*/
 	TAG_IGNORE = "ignore"
+	/*
+	   Accepted layouts of time.Time value, concatenated by "|",
+	   e.g. `layout:"2006-01-02|2006-01-02 15:04"`
+	   RFC3339 is always accepted as the last resort.
+	   the tag is optional
+	*/
+	TAG_LAYOUT = "layout"
+	/*
+	   Name of the environment variable to read the value from when the
+	   argument is not given on the command line, e.g. `env:"AUTH_URL"`
+	   The value from environment takes precedence over that from
+	   configuration files and the default value.  If the parser has an
+	   env prefix, the variable name is prefixed by it.
+	   the tag is optional, only applicable to non-positional arguments
+	*/
+	TAG_ENV = "env"
+	/*
+	   A boolean value declares that the integer argument counts the
+	   occurrences of its flag, e.g. -vvv sets 3 to `count:"true"` Verbose
+	   the tag is optional, the default value is false
+	*/
+	TAG_COUNT = "count"
+	/*
+	   Separator of key and value of map argument, e.g. with
+	   `separator:":"`, --label k1:v1 --label k2:v2 sets
+	   map[string]string{"k1": "v1", "k2": "v2"}
+	   the tag is optional, the default value is "="
+	*/
+	TAG_SEPARATOR = "separator"
+	/*
+	   Special roles of a field, e.g. `structarg:"rest"` marks a field of
+	   type []string or map[string]string collecting the unrecognized
+	   options instead of failing on them.  A []string field keeps the
+	   tokens as they are given, e.g. ["--zone", "z1", "--dry-run"], while
+	   a map[string]string field keeps {"zone": "z1", "dry-run": ""}
+	   `structarg:"-"` excludes the field from the arguments, e.g. runtime
+	   only fields of clients and parsed results, nested struct fields
+	   are not walked into either
+	   the tag is optional
+	*/
+	TAG_STRUCTARG = "structarg"
+	/*
+	   Section header of the argument in help, e.g. `group:"Networking"`
+	   A group tag on a struct field applies to all members of the struct
+	   which do not have their own.  Positional arguments are not grouped.
+	   the tag is optional
+	*/
+	TAG_GROUP = "group"
+	/*
+	   Tokens of the arguments that must be given together with this
+	   argument, concatenated by "|", e.g. `requires:"cert-file"` on
+	   KeyFile fails the parsing if --key-file is given without --cert-file
+	   the tag is optional
+	*/
+	TAG_REQUIRES = "requires"
+	/*
+	   A boolean value declares that the argument is parsed as usual but
+	   omitted from usage, help and completion, e.g. internal debug flags
+	   the tag is optional, the default value is false, only applicable to
+	   non-positional arguments
+	*/
+	TAG_HIDDEN = "hidden"
+	/*
+	   Deprecation message of the argument, e.g.
+	   `deprecated:"use --new-flag instead"`.  The argument is still
+	   parsed, but a warning is emitted when it is used and help marks it
+	   as deprecated.
+	   the tag is optional, only applicable to non-positional arguments
+	*/
+	TAG_DEPRECATED = "deprecated"
+	/*
+	   Token of the argument taking over the values given to a deprecated
+	   argument, e.g. `deprecated:"renamed" replacement:"new-flag"`
+	   the tag is optional, only applicable to deprecated arguments
+	*/
+	TAG_REPLACEMENT = "replacement"
+	/*
+	   Name of the function converting the string into the value, e.g.
+	   `parser:"ParseMyThing"` refers to the method of the target struct
+	   or the function registered by RegisterParseFunc, with signature
+	   func(string) (T, error).  T is the type of the field, or that of the
+	   elements of slices and pointers
+	   the tag is optional
+	*/
+	TAG_PARSER = "parser"
+	/*
+	   Allowed schemes of url.URL value, concatenated by "|", e.g.
+	   `schemes:"http|https"`
+	   the tag is optional
+	*/
+	TAG_SCHEMES = "schemes"
+	/*
+	   Unit of integer value, only "bytes" is supported, e.g.
+	   `unit:"bytes"` accepts 512M, 2GiB and 1024k, see Size
+	   the tag is optional
+	*/
+	TAG_UNIT = "unit"
+	/*
+	   Check of path value at parse time, one of
+	       * "exist" the path must exist
+	       * "dir" the path must be an existing directory
+	       * "create" the parent of the path must be a writable directory
+	   The leading ~ of the path is expanded to home directory of the user.
+	   e.g. `file:"exist"`
+	   the tag is optional, only applicable to string values
+	*/
+	TAG_FILE = "file"
+	/*
+	   Whether values of the form @path given on command line or by
+	   environment variable are read from file path, @- reads from stdin.
+	   The trailing newline of the content is removed.
+	   e.g. `fromfile:"allow"` accepts --data @body.json
+	   the tag is optional
+	*/
+	TAG_FROMFILE = "fromfile"
+	/*
+	   Regular expression string values must match, e.g.
+	   `pattern:"^[a-z0-9-]+$"`, anchors are needed to match the whole value
+	   the tag is optional
+	*/
+	TAG_PATTERN = "pattern"
+	/*
+	   Inclusive lower and upper bounds of numeric values, e.g.
+	   `min:"1" max:"65535"`, bounds are parsed as the values, thus
+	   `min:"1s"` for time.Duration and `max:"1G"` with `unit:"bytes"`
+	   the tags are optional
+	*/
+	TAG_MIN = "min"
+	TAG_MAX = "max"
+	/*
+	   Inclusive lower and upper limits of length of string values in
+	   characters, applied to each element of slices, e.g.
+	   `minlen:"8" maxlen:"64"`
+	   the tags are optional
+	*/
+	TAG_MINLEN = "minlen"
+	TAG_MAXLEN = "maxlen"
+	/*
+	   Values of the argument are secret, e.g. passwords, thus masked as
+	   SECRET_MASK in help, generated documents, errors and logs, and read
+	   with echo disabled when prompted for, e.g. `secret:"true"`
+	   the tag is optional
+	*/
+	TAG_SECRET = "secret"
+	/*
+	   Prompt on the terminal for the argument if it is given neither on
+	   the command line nor by environment variable, PROMPT_PASSWORD reads
+	   with echo disabled and implies `secret:"true"`, e.g.
+	   `prompt:"password"`
+	   the tag is optional
+	*/
+	TAG_PROMPT = "prompt"
+	/*
+	   Values match choices regardless of case and are normalized to the
+	   casing of the choice, e.g. `choices:"tcp|udp" choices-ignorecase:"true"`
+	   accepts TCP as tcp
+	   the tag is optional
+	*/
+	TAG_CHOICES_IGNORECASE = "choices-ignorecase"
+	/*
+	   Names of the values of integer arguments, which accept the names
+	   as well as the values, e.g. `enum:"low=1|medium=5|high=10"`.  The
+	   names are the choices of the argument, and values are written out by
+	   their names.  It excludes the choices tag
+	   the tag is optional
+	*/
+	TAG_ENUM = "enum"
+	/*
+	   How values of slices and maps from multiple sources combine.  Values
+	   of the source of the highest priority, i.e. the command line over
+	   environment, environment over configuration files and later files
+	   over earlier ones, replace the default and are appended to the
+	   initial value of the field by default.  SLICE_MODE_REPLACE replaces
+	   the initial value as well, while SLICE_MODE_APPEND collects values of
+	   all sources, after the default or the initial value, in the order
+	   they are parsed, e.g. `slice-mode:"append"`
+	   the tag is optional
+	*/
+	TAG_SLICE_MODE = "slice-mode"
+	/*
+	   Values of slices must be unique, duplicates are dropped, or reported
+	   as errors with UNIQUE_ERROR, e.g. `unique:"true"`
+	   the tag is optional
+	*/
+	TAG_UNIQUE = "unique"
+	/*
+	   Inclusive limits of the number of items of slices and maps given,
+	   e.g. `minitems:"1" maxitems:"5"`
+	   the tags are optional
+	*/
+	TAG_MINITEMS = "minitems"
+	TAG_MAXITEMS = "maxitems"
+	/*
+	   Prefix of the tokens of the members of a nested struct, overriding
+	   the one derived from the field name or token tag, e.g. with
+	   `prefix:"db"` on Database the members take tokens of --db-host.
+	   An empty prefix, `prefix:""`, flattens the members into the tokens
+	   of their own, e.g. --host
+	   the tag is optional, only applicable to nested struct fields
+	*/
+	TAG_PREFIX = "prefix"
+)
+
+const (
+	PROMPT_PASSWORD = "password"
+)
+
+const (
+	SLICE_MODE_REPLACE = "replace"
+	SLICE_MODE_APPEND  = "append"
+)
+
+const (
+	UNIQUE_ERROR = "error"
 )
 
-func (this *ArgumentParser) addStructArgument(prefix string, tpVal reflect.Value) error {
+const (
+	STRUCTARG_REST   = "rest"
+	STRUCTARG_IGNORE = "-"
+)
+
+// addStructArgument adds the fields of struct tpVal as arguments.  group is
+// the group tag of the struct field, inherited by the fields without one
+func (this *ArgumentParser) addStructArgument(prefix string, group string, tpVal reflect.Value) error {
+	walker, err := walkStructFields(prefix, group, tpVal, this.addArgument)
+	this.sections = append(this.sections, walker.sections...)
+	this.structPrefixes = append(this.structPrefixes, walker.prefixes...)
+	return err
+}
+
+// walkStructFields calls visit with the fields of struct tpVal which are
+// not structs, descending into nested structs with their tokens as prefix.
+// It returns the walker holding the sections of pointers to nested structs
+// and the prefixes of nested structs walked into
+func walkStructFields(prefix string, group string, tpVal reflect.Value, visit func(prefix string, group string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error) (*sFieldWalker, error) {
+	walker := &sFieldWalker{visit: visit}
+	err := walker.walk(prefix, group, tpVal)
+	return walker, err
+}
+
+func (w *sFieldWalker) walk(prefix string, group string, tpVal reflect.Value) error {
+	w.types = append(w.types, tpVal.Type())
+	defer func() { w.types = w.types[:len(w.types)-1] }()
 	sets := reflectutils.FetchAllStructFieldValueSetForWrite(tpVal)
 	for i := range sets {
-		if sets[i].Value.Kind() == reflect.Struct && sets[i].Value.Type() != gotypes.TimeType {
+		if isIgnoredField(sets[i].Info.Tags) {
+			continue
+		}
+		_, hasParser := sets[i].Info.Tags[TAG_PARSER]
+		isSection := w.isSection(sets[i].Value.Type())
+		if (isStructType(sets[i].Value.Type()) || isSection) && !hasParser {
 			tagMap := sets[i].Info.Tags
 			if _, ok := tagMap[reflectutils.TAG_DEPRECATED_BY]; ok {
 				// deprecated field, ignore
@@ -298,13 +869,31 @@ func (this *ArgumentParser) addStructArgument(prefix string, tpVal reflect.Value
 			if !ok {
 				token = sets[i].Info.MarshalName()
 			}
-			token = prefix + token + "-"
-			err := this.addStructArgument(token, sets[i].Value)
+			if p, ok := tagMap[TAG_PREFIX]; ok {
+				token = strings.TrimSuffix(p, "-")
+			}
+			if len(token) > 0 {
+				token = prefix + token + "-"
+				w.prefixes = append(w.prefixes, token)
+			} else {
+				token = prefix
+			}
+			subgroup := group
+			if g, ok := tagMap[TAG_GROUP]; ok {
+				subgroup = g
+			}
+			fv := sets[i].Value
+			if isSection {
+				section := newStructSection(fv)
+				w.sections = append(w.sections, section)
+				fv = section.value.Elem()
+			}
+			err := w.walk(token, subgroup, fv)
 			if err != nil {
 				return errors.Wrap(err, "addStructArgument")
 			}
 		} else {
-			err := this.addArgument(prefix, sets[i].Value, sets[i].Info)
+			err := w.visit(prefix, group, sets[i].Value, sets[i].Info)
 			if err != nil {
 				return errors.Wrap(err, "addArgument")
 			}
@@ -313,16 +902,27 @@ func (this *ArgumentParser) addStructArgument(prefix string, tpVal reflect.Value
 	return nil
 }
 
-func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error {
+// isIgnoredField tells whether the field of tags is excluded from arguments
+func isIgnoredField(tagMap map[string]string) bool {
+	return tagMap[TAG_IGNORE] == "true" || tagMap[TAG_STRUCTARG] == STRUCTARG_IGNORE || tagMap[TAG_TOKEN] == "-"
+}
+
+func (this *ArgumentParser) addArgument(prefix string, group string, fv reflect.Value, info *reflectutils.SStructFieldInfo) error {
 	tagMap := info.Tags
 	if _, ok := tagMap[reflectutils.TAG_DEPRECATED_BY]; ok {
 		// deprecated field, ignore
 		return nil
 	}
-	if val, ok := tagMap[TAG_IGNORE]; ok && val == "true" {
+	if isIgnoredField(tagMap) {
 		// ignore field
 		return nil
 	}
+	if _, ok := tagMap[TAG_PREFIX]; ok {
+		return fmt.Errorf("%s is applicable to nested struct ONLY", TAG_PREFIX)
+	}
+	if tagMap[TAG_STRUCTARG] == STRUCTARG_REST {
+		return this.setRestField(fv, info)
+	}
 	help := tagMap[TAG_HELP]
 	token, ok := tagMap[TAG_TOKEN]
 	if !ok {
@@ -330,14 +930,26 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	}
 	token = prefix + token
 	shorttoken := tagMap[TAG_SHORT_TOKEN]
+	if len(shorttoken) == 0 {
+		shorttoken = tagMap[TAG_SHORT]
+	}
 	alias := tagMap[TAG_ALIAS]
 	negative := tagMap[TAG_NEGATIVE_TOKEN]
 	metavar := tagMap[TAG_METAVAR]
+	env := tagMap[TAG_ENV]
+	if g, ok := tagMap[TAG_GROUP]; ok {
+		group = g
+	}
+	var requires []string
+	if requiresStr, ok := tagMap[TAG_REQUIRES]; ok && len(requiresStr) > 0 {
+		requires = strings.Split(requiresStr, "|")
+	}
 	defval := tagMap[TAG_DEFAULT]
 	if len(defval) > 0 {
 		for _, dv := range strings.Split(defval, "|") {
-			if dv[0] == '$' {
-				dv = os.Getenv(strings.TrimLeft(dv, "$"))
+			if strings.IndexByte(dv, '$') >= 0 {
+				dv = expandEnv(dv)
+				this.uncacheable = true
 			}
 			defval = dv
 			if len(defval) > 0 {
@@ -348,14 +960,85 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	if len(negative) > 0 && !valueIsBool(fv) {
 		return fmt.Errorf("negative token is applicable to boolean option ONLY")
 	}
+	hidden, _ := strconv.ParseBool(tagMap[TAG_HIDDEN])
+	secret, _ := strconv.ParseBool(tagMap[TAG_SECRET])
+	ignoreCase, _ := strconv.ParseBool(tagMap[TAG_CHOICES_IGNORECASE])
+	promptPassword := false
+	if promptTag, ok := tagMap[TAG_PROMPT]; ok {
+		if promptTag != PROMPT_PASSWORD {
+			return fmt.Errorf("Invalid prompt tag %q of %s, expecting %q", promptTag, token, PROMPT_PASSWORD)
+		}
+		promptPassword = true
+		secret = true
+	}
+	deprecated := tagMap[TAG_DEPRECATED]
+	replacement := tagMap[TAG_REPLACEMENT]
+	if len(replacement) > 0 && len(deprecated) == 0 {
+		return fmt.Errorf("replacement is applicable to deprecated option ONLY")
+	}
+	count, _ := strconv.ParseBool(tagMap[TAG_COUNT])
+	if count && !valueIsInt(fv) {
+		return fmt.Errorf("count is applicable to integer option ONLY")
+	}
+	if _, ok := tagMap[TAG_SEPARATOR]; ok && !valueIsMap(fv) {
+		return fmt.Errorf("separator is applicable to map option ONLY")
+	}
 	use_default := true
 	if len(defval) == 0 {
 		use_default = false
 	}
+	defaultStr := defval
 	var choices []string
 	if choices_str, ok := tagMap[TAG_CHOICES]; ok {
 		choices = strings.Split(choices_str, "|")
 	}
+	var layouts []string
+	if layout, ok := tagMap[TAG_LAYOUT]; ok && len(layout) > 0 {
+		layouts = strings.Split(layout, "|")
+	}
+	file := tagMap[TAG_FILE]
+	if len(file) > 0 {
+		if !utils.IsInStringArray(file, []string{FILE_EXIST, FILE_DIR, FILE_CREATE}) {
+			return fmt.Errorf("Invalid file tag %q of %s", file, token)
+		}
+		if elemType(fv.Type()).Kind() != reflect.String {
+			return fmt.Errorf("file is applicable to string option ONLY")
+		}
+	}
+	fromFile := false
+	if from, ok := tagMap[TAG_FROMFILE]; ok {
+		if from != FROMFILE_ALLOW {
+			return fmt.Errorf("Invalid fromfile tag %q of %s, expecting %q", from, token, FROMFILE_ALLOW)
+		}
+		fromFile = true
+	}
+	var pattern *regexp.Regexp
+	if patternStr, ok := tagMap[TAG_PATTERN]; ok && len(patternStr) > 0 {
+		if elemType(fv.Type()).Kind() != reflect.String {
+			return fmt.Errorf("pattern is applicable to string option ONLY")
+		}
+		var err error
+		pattern, err = regexp.Compile(patternStr)
+		if err != nil {
+			return fmt.Errorf("Invalid pattern of %s: %v", token, err)
+		}
+	}
+	unit := tagMap[TAG_UNIT]
+	if len(unit) > 0 {
+		if unit != UNIT_BYTES {
+			return fmt.Errorf("Unsupported unit %s of %s", unit, token)
+		}
+		if !valueIsInt(reflect.New(elemType(fv.Type())).Elem()) {
+			return fmt.Errorf("unit is applicable to integer option ONLY")
+		}
+	}
+	var schemes []string
+	if schemesStr, ok := tagMap[TAG_SCHEMES]; ok && len(schemesStr) > 0 {
+		if !valueIsURL(fv) {
+			return fmt.Errorf("schemes is applicable to url.URL option ONLY")
+		}
+		schemes = strings.Split(strings.ToLower(schemesStr), "|")
+	}
 	// heuristic guessing "positional"
 	var positional bool
 	if info.FieldName == strings.ToUpper(info.FieldName) {
@@ -391,6 +1074,15 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 		if use_default {
 			return fmt.Errorf("positional %s must not have default value", token)
 		}
+		if len(env) > 0 {
+			return fmt.Errorf("positional %s must not have env", token)
+		}
+		if hidden {
+			return fmt.Errorf("positional %s must not be hidden", token)
+		}
+		if len(deprecated) > 0 {
+			return fmt.Errorf("positional %s must not be deprecated", token)
+		}
 	}
 	if !positional && use_default && required {
 		return fmt.Errorf("non-positional argument with default value should not have required:true set")
@@ -399,13 +1091,6 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	if err != nil {
 		subcommand = false
 	}
-	var defval_t reflect.Value
-	if use_default {
-		defval_t, err = gotypes.ParseValue(defval, fv.Type())
-		if err != nil {
-			return err
-		}
-	}
 	if subcommand {
 		positional = true
 	}
@@ -413,26 +1098,77 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	ovalue := reflect.New(fv.Type()).Elem()
 	ovalue.Set(fv)
 	sarg := SingleArgument{
-		token:      token,
-		shortToken: shorttoken,
-		aliasToken: alias,
-		negaToken:  negative,
-		positional: positional,
-		required:   required,
-		metavar:    metavar,
-		help:       help,
-		choices:    choices,
-		useDefault: use_default,
-		defValue:   defval_t,
-		value:      fv,
-		ovalue:     ovalue,
-		parser:     this,
+		token:       token,
+		shortToken:  shorttoken,
+		aliasToken:  alias,
+		negaToken:   negative,
+		positional:  positional,
+		required:    required,
+		hidden:      hidden,
+		secret:      secret,
+		prompt:      promptPassword,
+		count:       count,
+		metavar:     metavar,
+		env:         env,
+		group:       group,
+		requires:    requires,
+		help:        help,
+		deprecated:  deprecated,
+		replacement: replacement,
+		choices:     choices,
+		ignoreCase:  ignoreCase,
+		layouts:     layouts,
+		schemes:     schemes,
+		unit:        unit,
+		file:        file,
+		fromFile:    fromFile,
+		pattern:     pattern,
+		defaultStr:  defaultStr,
+		useDefault:  use_default,
+		value:       fv,
+		ovalue:      ovalue,
+		parser:      this,
+	}
+	sarg.normalizeTokens()
+	if parseFuncName := tagMap[TAG_PARSER]; len(parseFuncName) > 0 {
+		sarg.parseFunc, err = findParseFunc(this.target, parseFuncName)
+		if reflect.ValueOf(this.target).MethodByName(parseFuncName).IsValid() {
+			// bound to the target
+			this.uncacheable = true
+		}
+		if err != nil {
+			return fmt.Errorf("parser of %s: %v", token, err)
+		}
+	}
+	if err := sarg.parseRange(tagMap[TAG_MIN], tagMap[TAG_MAX]); err != nil {
+		return fmt.Errorf("range of %s: %v", token, err)
+	}
+	if err := sarg.parseLength(tagMap[TAG_MINLEN], tagMap[TAG_MAXLEN]); err != nil {
+		return fmt.Errorf("length of %s: %v", token, err)
+	}
+	if enum, ok := tagMap[TAG_ENUM]; ok {
+		if len(choices) > 0 {
+			return fmt.Errorf("enum and choices of %s are exclusive", token)
+		}
+		if err := sarg.parseEnum(enum); err != nil {
+			return fmt.Errorf("enum of %s: %v", token, err)
+		}
+	}
+	if err := sarg.parseChoices(); err != nil {
+		return fmt.Errorf("choices of %s: %v", token, err)
+	}
+	if use_default {
+		sarg.defValue, err = sarg.parseValue(defval, fv.Type())
+		if err != nil {
+			return err
+		}
 	}
 	// fmt.Println(token, f.Type, f.Type.Kind())
 	if subcommand {
 		arg = &SubcommandArgument{SingleArgument: sarg,
-			subcommands: make(map[string]SubcommandArgumentData)}
-	} else if fv.Kind() == reflect.Array || fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map {
+			subcommands: make(map[string]SubcommandArgumentData),
+			aliases:     make(map[string]string)}
+	} else if (fv.Kind() == reflect.Array || fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && !sarg.isScalar(fv.Type()) {
 		var min, max int64
 		var err error
 		nargs := tagMap[TAG_NARGS]
@@ -457,9 +1193,33 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 				max = -1
 			}
 		}
-		arg = &MultiArgument{SingleArgument: sarg,
-			minCount: min, maxCount: max}
+		separator := "="
+		if sep, ok := tagMap[TAG_SEPARATOR]; ok {
+			if len(sep) == 0 {
+				return fmt.Errorf("separator of %s must not be empty", token)
+			}
+			separator = sep
+		}
+		sliceMode := tagMap[TAG_SLICE_MODE]
+		if len(sliceMode) > 0 && sliceMode != SLICE_MODE_REPLACE && sliceMode != SLICE_MODE_APPEND {
+			return fmt.Errorf("Invalid slice-mode %q of %s, expecting %q or %q", sliceMode, token, SLICE_MODE_REPLACE, SLICE_MODE_APPEND)
+		}
+		marg := &MultiArgument{SingleArgument: sarg,
+			minCount: min, maxCount: max, separator: separator,
+			sliceMode: sliceMode}
+		if err := marg.parseItems(tagMap[TAG_UNIQUE], tagMap[TAG_MINITEMS], tagMap[TAG_MAXITEMS]); err != nil {
+			return fmt.Errorf("items of %s: %v", token, err)
+		}
+		if err := marg.initStructItems(); err != nil {
+			return fmt.Errorf("struct items of %s: %v", token, err)
+		}
+		arg = marg
 	} else {
+		for _, tag := range []string{TAG_SLICE_MODE, TAG_UNIQUE, TAG_MINITEMS, TAG_MAXITEMS} {
+			if _, ok := tagMap[tag]; ok {
+				return fmt.Errorf("%s is applicable to slice or map option ONLY", tag)
+			}
+		}
 		arg = &sarg
 	}
 	err = this.AddArgument(arg)
@@ -482,17 +1242,22 @@ func (this *ArgumentParser) AddArgument(arg Argument) error {
 		}
 		this.posArgs = append(this.posArgs, arg)
 	} else {
+		defer this.invalidateTokens()
 		for _, argOld := range this.optArgs {
 			if argOld.Token() == arg.Token() {
 				if arg.Token() == "help" {
 					// silently ignore help arguments
 					return nil
 				}
-				rt := reflect.TypeOf(this.target)
-				if rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Interface {
-					rt = rt.Elem()
+				return fmt.Errorf("%s: Duplicate argument %s", this.targetName(), argOld.Token())
+			}
+			for _, token := range longTokens(arg) {
+				if utils.IsInStringArray(token, longTokens(argOld)) {
+					return fmt.Errorf("%s: Duplicate token --%s of %s and %s", this.targetName(), token, argOld.Token(), arg.Token())
 				}
-				return fmt.Errorf("%s: Duplicate argument %s", rt.Name(), argOld.Token())
+			}
+			if len(arg.ShortToken()) > 0 && argOld.ShortToken() == arg.ShortToken() {
+				return fmt.Errorf("%s: Duplicate short token -%s of %s and %s", this.targetName(), arg.ShortToken(), argOld.Token(), arg.Token())
 			}
 		}
 		// Put required at the end and try to be stable
@@ -514,23 +1279,143 @@ func (this *ArgumentParser) AddArgument(arg Argument) error {
 	return nil
 }
 
-func (this *ArgumentParser) SetDefault() {
-	for _, arg := range this.posArgs {
-		arg.SetDefault()
-	}
-	for _, arg := range this.optArgs {
-		arg.SetDefault()
-	}
+// RemoveArgument removes the argument of token name as if its field did not
+// exist.  The field is left untouched and keys of configuration files
+// naming it are unknown
+func (this *ArgumentParser) RemoveArgument(name string) error {
+	_, err := this.removeArgument(name)
+	return err
 }
 
-func (this *ArgumentParser) Options() interface{} {
-	return this.target
+// DisableArgument removes the argument of token name from the command line,
+// environment, help and completion, e.g. options of a shared struct not
+// applicable to the program.  Unlike RemoveArgument, the field is still set
+// to the default value and keys of configuration files naming it are
+// ignored silently, as such files are often shared as well
+func (this *ArgumentParser) DisableArgument(name string) error {
+	arg, err := this.removeArgument(name)
+	if err != nil {
+		return err
+	}
+	this.disabledArgs = append(this.disabledArgs, arg)
+	return nil
 }
 
-func valueIsBool(rv reflect.Value) bool {
-	if rv.Kind() == reflect.Bool {
-		return true
-	}
+func (this *ArgumentParser) removeArgument(name string) (Argument, error) {
+	token := splitCamelString(name)
+	for _, args := range []*[]Argument{&this.optArgs, &this.posArgs} {
+		for i, arg := range *args {
+			if arg.Token() != token {
+				continue
+			}
+			if arg.IsSubcommand() {
+				return nil, fmt.Errorf("Cannot remove subcommand argument %s", token)
+			}
+			if err := this.checkReferenced(arg); err != nil {
+				return nil, err
+			}
+			*args = append((*args)[:i], (*args)[i+1:]...)
+			this.invalidateTokens()
+			return arg, nil
+		}
+	}
+	return nil, fmt.Errorf("No such argument %s", name)
+}
+
+// checkReferenced fails if arg is still named by the requires or
+// replacement tag of another argument
+func (this *ArgumentParser) checkReferenced(arg Argument) error {
+	for _, other := range this.optArgs {
+		if other == arg {
+			continue
+		}
+		for _, token := range argumentRequires(other) {
+			if this.findArgumentByToken(token) == arg {
+				return fmt.Errorf("Cannot remove argument %s required by %s", arg.Token(), other.Token())
+			}
+		}
+		if replacement := argumentReplacement(other); len(replacement) > 0 && this.findArgumentByToken(replacement) == arg {
+			return fmt.Errorf("Cannot remove argument %s replacing %s", arg.Token(), other.Token())
+		}
+	}
+	return nil
+}
+
+// isDisabledToken tells whether token names an argument of DisableArgument
+func (this *ArgumentParser) isDisabledToken(token string) bool {
+	for _, arg := range this.disabledArgs {
+		if utils.IsInStringArray(token, longTokens(arg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// longTokens returns the token, alias tokens and negative token of arg
+func longTokens(arg Argument) []string {
+	tokens := append([]string{arg.Token()}, argumentAliasTokens(arg)...)
+	if len(arg.NegativeToken()) > 0 {
+		tokens = append(tokens, arg.NegativeToken())
+	}
+	return tokens
+}
+
+func (this *ArgumentParser) targetName() string {
+	rt := reflect.TypeOf(this.target)
+	if rt.Kind() == reflect.Ptr || rt.Kind() == reflect.Interface {
+		rt = rt.Elem()
+	}
+	return rt.Name()
+}
+
+// SetDefault sets the arguments not set to their default values, including
+// those of the chosen subcommand.  Errors of the functions registered by
+// SetDefaultFunc are logged, see ApplyDefaults for them to be returned
+func (this *ArgumentParser) SetDefault() {
+	if err := this.ApplyDefaults(); err != nil {
+		log.Errorf("SetDefault: %v", err)
+	}
+}
+
+// ApplyDefaults sets default values as SetDefault does, and returns the
+// errors of the functions registered by SetDefaultFunc, whose arguments fall
+// back to the default tag
+func (this *ArgumentParser) ApplyDefaults() error {
+	err := this.resolveDefaultFuncs()
+	for _, arg := range this.posArgs {
+		arg.SetDefault()
+	}
+	for _, arg := range this.optArgs {
+		arg.SetDefault()
+	}
+	for _, arg := range this.disabledArgs {
+		arg.SetDefault()
+	}
+	return err
+}
+
+func (this *ArgumentParser) Options() interface{} {
+	return this.target
+}
+
+// SetEnvPrefix sets the prefix of environment variable names bound to
+// arguments, e.g. with prefix "PROG", `env:"AUTH_URL"` reads PROG_AUTH_URL.
+// Non-positional arguments without env tag are bound automatically to the
+// prefixed upper snake case form of their token, e.g. --auth-url reads
+// PROG_AUTH_URL.  The prefix is also applied to the parsers of subcommands
+func (this *ArgumentParser) SetEnvPrefix(prefix string) {
+	this.envPrefix = prefix
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetEnvPrefix(prefix)
+		}
+	}
+}
+
+func valueIsBool(rv reflect.Value) bool {
+	if rv.Kind() == reflect.Bool {
+		return true
+	}
 
 	if rv.Kind() == reflect.Ptr && rv.Type().Elem().Kind() == reflect.Bool {
 		return true
@@ -538,6 +1423,28 @@ func valueIsBool(rv reflect.Value) bool {
 	return false
 }
 
+func valueIsInt(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// elemType returns the type of elements of pointers and slices
+func elemType(tp reflect.Type) reflect.Type {
+	for tp.Kind() == reflect.Ptr || tp.Kind() == reflect.Slice {
+		tp = tp.Elem()
+	}
+	return tp
+}
+
+// valueIsURL tells whether rv is url.URL, or pointer or slice of it
+func valueIsURL(rv reflect.Value) bool {
+	return elemType(rv.Type()) == urlType
+}
+
 func valueIsMap(rv reflect.Value) bool {
 	if rv.Kind() == reflect.Map {
 		return true
@@ -558,7 +1465,7 @@ func (this *SingleArgument) defaultBoolValue() bool {
 }
 
 func (this *SingleArgument) NeedData() bool {
-	if valueIsBool(this.value) {
+	if valueIsBool(this.value) || this.count {
 		return false
 	} else {
 		return true
@@ -571,7 +1478,7 @@ func (this *SingleArgument) MetaVar() string {
 	} else if len(this.choices) > 0 {
 		return fmt.Sprintf("{%s}", strings.Join(this.choices, ","))
 	} else {
-		return strings.ToUpper(strings.Replace(this.Token(), "-", "_", -1))
+		return strings.ToUpper(strings.Replace(this.kebabToken(), "-", "_", -1))
 	}
 }
 
@@ -581,8 +1488,8 @@ func splitCamelString(str string) string {
 
 func (this *SingleArgument) AllToken() string {
 	ret := this.Token()
-	if len(this.AliasToken()) != 0 {
-		ret = fmt.Sprintf("%s|--%s", ret, this.AliasToken())
+	for _, alias := range this.AliasTokens() {
+		ret = fmt.Sprintf("%s|--%s", ret, alias)
 	}
 	if len(this.ShortToken()) != 0 {
 		ret = fmt.Sprintf("%s|-%s", ret, this.ShortToken())
@@ -594,11 +1501,51 @@ func (this *SingleArgument) AllToken() string {
 }
 
 func (this *SingleArgument) Token() string {
-	return splitCamelString(this.token)
+	return this.tokens.token
+}
+
+// sArgumentTokens are the tokens of an argument in the command line form,
+// computed once by normalizeTokens since they are looked up for every word
+// of command lines
+type sArgumentTokens struct {
+	token string
+	// kebab is the token in kebab-case before the naming strategy applies
+	kebab    string
+	aliases  []string
+	negative string
+}
+
+func (this *SingleArgument) normalizeTokens() {
+	named := splitCamelString
+	if this.parser != nil && this.parser.naming != nil {
+		named = this.parser.namedToken
+	}
+	this.tokens.kebab = splitCamelString(this.token)
+	this.tokens.token = named(this.token)
+	this.tokens.aliases = nil
+	for _, alias := range strings.Split(this.aliasToken, ",") {
+		if alias = strings.TrimSpace(alias); len(alias) > 0 {
+			this.tokens.aliases = append(this.tokens.aliases, named(alias))
+		}
+	}
+	this.tokens.negative = strings.ReplaceAll(this.negaToken, "_", "-")
+	if len(this.negaToken) > 0 && this.parser != nil && this.parser.naming != nil {
+		this.tokens.negative = named(this.negaToken)
+	}
 }
 
+// AliasToken returns the first alias token
 func (this *SingleArgument) AliasToken() string {
-	return splitCamelString(this.aliasToken)
+	aliases := this.AliasTokens()
+	if len(aliases) == 0 {
+		return ""
+	}
+	return aliases[0]
+}
+
+// AliasTokens returns all alias tokens, concatenated by "," in alias tag
+func (this *SingleArgument) AliasTokens() []string {
+	return this.tokens.aliases
 }
 
 func (this *SingleArgument) ShortToken() string {
@@ -606,7 +1553,7 @@ func (this *SingleArgument) ShortToken() string {
 }
 
 func (this *SingleArgument) NegativeToken() string {
-	return strings.ReplaceAll(this.negaToken, "_", "-")
+	return this.tokens.negative
 }
 
 func (this *SingleArgument) String() string {
@@ -645,60 +1592,193 @@ func (this *SingleArgument) IsSubcommand() bool {
 	return false
 }
 
+func (this *SingleArgument) EnvName() string {
+	var prefix string
+	if this.parser != nil {
+		prefix = this.parser.envPrefix
+	}
+	env := this.env
+	if len(env) == 0 {
+		if len(prefix) == 0 || this.positional {
+			return ""
+		}
+		env = strings.ToUpper(strings.Replace(this.kebabToken(), "-", "_", -1))
+	}
+	if len(prefix) > 0 {
+		return prefix + "_" + env
+	}
+	return env
+}
+
+func (this *SingleArgument) Group() string {
+	return this.group
+}
+
+func (this *SingleArgument) Requires() []string {
+	return this.requires
+}
+
+func (this *SingleArgument) IsHidden() bool {
+	return this.hidden
+}
+
+func (this *SingleArgument) Deprecated() string {
+	return this.deprecated
+}
+
+func (this *SingleArgument) Replacement() string {
+	return splitCamelString(this.replacement)
+}
+
 func (this *SingleArgument) HelpString(indent string) string {
-	return indent + strings.Join(strings.Split(this.help, "\n"), "\n"+indent)
+	help := this.help
+	if this.parser == nil || !this.parser.noHelpDecorations {
+		if this.useDefault && len(this.defaultStr) > 0 {
+			help = strings.TrimLeft(fmt.Sprintf("%s (default: %s)", help, this.shownValue(this.defaultStr)), " ")
+		}
+		if len(this.choices) > 0 {
+			help = strings.TrimLeft(fmt.Sprintf("%s (choices: %s)", help, strings.Join(this.choices, "|")), " ")
+		}
+		if this.pattern != nil {
+			help = strings.TrimLeft(fmt.Sprintf("%s (pattern: %s)", help, this.pattern), " ")
+		}
+		if rng := this.rangeString(); len(rng) > 0 {
+			help = strings.TrimLeft(fmt.Sprintf("%s (range: %s)", help, rng), " ")
+		}
+		if length := this.lengthString(); len(length) > 0 {
+			help = strings.TrimLeft(fmt.Sprintf("%s (length: %s)", help, length), " ")
+		}
+		if envName := this.EnvName(); len(envName) > 0 {
+			help = strings.TrimLeft(fmt.Sprintf("%s (env: %s)", help, envName), " ")
+		}
+	}
+	if len(this.deprecated) > 0 {
+		help = strings.TrimLeft(fmt.Sprintf("%s (deprecated: %s)", help, this.deprecated), " ")
+	}
+	return indent + strings.Join(strings.Split(help, "\n"), "\n"+indent)
 }
 
 func (this *SingleArgument) InChoices(val string) bool {
-	if len(this.choices) > 0 {
-		for _, s := range this.choices {
-			if s == val {
-				return true
+	_, ok := this.matchChoice(val)
+	return ok
+}
+
+// matchChoice returns the choice val matches, val itself if there are no
+// choices.  Choices of other case are matched if case is ignored
+func (this *SingleArgument) matchChoice(val string) (string, bool) {
+	choices := this.Choices()
+	if len(choices) == 0 {
+		return val, true
+	}
+	for _, s := range choices {
+		if s == val {
+			return s, true
+		}
+	}
+	if this.ignoreCase || (this.parser != nil && this.parser.choicesIgnoreCase) {
+		for _, s := range choices {
+			if strings.EqualFold(s, val) {
+				return s, true
 			}
 		}
-		return false
-	} else {
-		return true
 	}
+	if this.typedChoices() {
+		return this.matchTypedChoice(val, choices)
+	}
+	return val, false
 }
 
 func (this *SingleArgument) Choices() []string {
+	if this.choicesFunc != nil {
+		return this.choicesFunc(this.context())
+	}
 	return this.choices
 }
 
 func (this *SingleArgument) SetValue(val string) error {
-	if !this.InChoices(val) {
+	val, err := this.readFromFile(val)
+	if err != nil {
+		return err
+	}
+	val, ok := this.matchChoice(val)
+	if !ok {
 		return this.choicesErr(val)
 	}
-	e := gotypes.SetValue(this.value, val)
+	e := this.setValue(this.value, val)
 	if e != nil {
 		return e
 	}
-	this.isSet = true
+	this.markSet()
 	return nil
 }
 
 func (this *SingleArgument) choicesErr(val string) error {
-	cands := FindSimilar(val, this.choices, -1, 0.5)
-	if len(cands) > 3 {
-		cands = cands[:3]
+	choices := this.Choices()
+	cands := FindSimilar(val, choices, -1, 0.5)
+	if len(cands) > maxSuggestions {
+		cands = cands[:maxSuggestions]
 	}
-	msg := fmt.Sprintf("Unknown argument '%s' for %s", val, this.Token())
-	if len(cands) > 0 {
-		msg += fmt.Sprintf(", did you mean %s?", quotedChoicesString(cands))
-	} else if len(this.choices) > 0 {
-		msg += fmt.Sprintf(", accepts %s", quotedChoicesString(this.choices))
+	return &InvalidChoiceError{
+		Argument:    this.Token(),
+		Value:       this.shownValue(val),
+		Choices:     choices,
+		Suggestions: cands,
 	}
-	return fmt.Errorf("%s", msg)
 }
 
 func (this *SingleArgument) Reset() {
-	this.value.Set(this.ovalue)
+	// values set later are not to land in the original value, which is
+	// shared with clones of the parser
+	switch {
+	case this.ovalue.Kind() == reflect.Slice && !this.ovalue.IsNil():
+		n := this.ovalue.Len()
+		this.value.Set(this.ovalue.Slice3(0, n, n))
+	case this.ovalue.Kind() == reflect.Map && this.ovalue.Len() > 0:
+		value := reflect.MakeMapWithSize(this.ovalue.Type(), this.ovalue.Len())
+		for _, key := range this.ovalue.MapKeys() {
+			value.SetMapIndex(key, this.ovalue.MapIndex(key))
+		}
+		this.value.Set(value)
+	default:
+		this.value.Set(this.ovalue)
+	}
 	this.isSet = false
+	this.started = false
+	this.source = ""
+}
+
+// markSet marks the argument set from the current source of the parser
+func (this *SingleArgument) markSet() {
+	this.isSet = true
+	if this.parser != nil {
+		this.source = this.parser.source
+		this.parser.allocSections(this.value)
+	}
+}
+
+// Source returns where the value of the argument comes from, which is
+// SOURCE_COMMAND_LINE, SOURCE_ENV, path of configuration file, SOURCE_UPDATE,
+// SOURCE_QUERY or SOURCE_DEFAULT, empty if none of them
+func (this *SingleArgument) Source() string {
+	if this.isSet {
+		return this.source
+	}
+	if this.useDefault {
+		return SOURCE_DEFAULT
+	}
+	return ""
 }
 
 func (this *SingleArgument) DoAction(nega bool) error {
-	if valueIsBool(this.value) {
+	if this.count {
+		switch this.value.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			this.value.SetUint(this.value.Uint() + 1)
+		default:
+			this.value.SetInt(this.value.Int() + 1)
+		}
+		this.markSet()
+	} else if valueIsBool(this.value) {
 		var v bool
 		if this.useDefault {
 			v = !this.defaultBoolValue()
@@ -707,21 +1787,22 @@ func (this *SingleArgument) DoAction(nega bool) error {
 		} else {
 			v = true
 		}
-		gotypes.SetValue(this.value, fmt.Sprintf("%t", v))
-		this.isSet = true
+		this.setValue(this.value, strconv.FormatBool(v))
+		this.markSet()
 	}
 	return nil
 }
 
 func (this *SingleArgument) SetDefault() {
 	if !this.isSet && this.useDefault {
-		this.value.Set(this.defValue)
+		// defaults are shared by parses and clones of the parser
+		this.value.Set(copyValue(this.defValue))
 	}
 }
 
 func (this *SingleArgument) Validate() error {
-	if this.required && !this.isSet && !this.useDefault {
-		return fmt.Errorf("Non-optional argument %s not set", this.token)
+	if this.required && !this.isSet && !this.useDefault && this.defaultFunc == nil {
+		return &MissingRequiredError{Argument: this.token}
 	}
 	return nil
 }
@@ -735,45 +1816,67 @@ func (this *MultiArgument) IsMulti() bool {
 }
 
 func (this *MultiArgument) setKeyValue(val string) error {
-	pos := strings.IndexByte(val, '=')
+	if isInlineDict(val) {
+		return this.setInlineDict(val)
+	}
+	pos := strings.Index(val, this.separator)
 	var key, value string
 	if pos >= 0 {
 		key = val[:pos]
-		value = val[pos+1:]
+		value = val[pos+len(this.separator):]
 	} else {
 		key = val
 	}
+	return this.setMapIndex(key, value)
+}
+
+func (this *MultiArgument) setMapIndex(key, value string) error {
 	keyType := this.value.Type().Key()
-	keyValue, err := gotypes.ParseValue(key, keyType)
+	keyValue, err := this.parseValue(key, keyType)
 	if err != nil {
 		return errors.Wrapf(err, "ParseValue for key %s", key)
 	}
 	valType := this.value.Type().Elem()
-	valValue, err := gotypes.ParseValue(value, valType)
+	valValue, err := this.parseValue(value, valType)
 	if err != nil {
 		return errors.Wrapf(err, "ParseValue for value %s", value)
 	}
+	this.startValue()
 	if this.value.Len() == 0 {
 		this.value.Set(reflect.MakeMap(this.value.Type()))
 	}
 	this.value.SetMapIndex(keyValue, valValue)
-	this.isSet = true
+	this.markSet()
 	return nil
 }
 
 func (this *MultiArgument) SetValue(val string) error {
+	val, err := this.readFromFile(val)
+	if err != nil {
+		return err
+	}
 	if valueIsMap(this.value) {
 		return this.setKeyValue(val)
 	}
-	if !this.InChoices(val) {
+	if this.itemTemplate != nil {
+		return this.addStructItem(val)
+	}
+	val, ok := this.matchChoice(val)
+	if !ok {
 		return this.choicesErr(val)
 	}
+	this.startValue()
 	var e error = nil
-	e = gotypes.AppendValue(this.value, val)
+	e = this.appendValue(this.value, val)
 	if e != nil {
 		return e
 	}
-	this.isSet = true
+	if this.unique {
+		if err := this.dropDuplicate(val); err != nil {
+			return err
+		}
+	}
+	this.markSet()
 	return nil
 }
 
@@ -783,19 +1886,27 @@ func (this *MultiArgument) Validate() error {
 		return e
 	}
 	var vallen int64 = int64(this.value.Len())
-	if this.minCount >= 0 && vallen < this.minCount {
-		return fmt.Errorf("Argument count requires at least %d", this.minCount)
+	if (this.minCount >= 0 && vallen < this.minCount) || (this.maxCount >= 0 && vallen > this.maxCount) {
+		return &ArgumentCountError{Argument: this.token, Count: vallen, Min: this.minCount, Max: this.maxCount}
 	}
-	if this.maxCount >= 0 && vallen > this.maxCount {
-		return fmt.Errorf("Argument count requires at most %d", this.maxCount)
+	if err := this.checkItems(); err != nil {
+		return err
 	}
-	return nil
+	return this.validateStructItems()
 }
 
 func (this *SubcommandArgument) IsSubcommand() bool {
 	return true
 }
 
+// SetDefault also sets default values of the chosen subcommand
+func (this *SubcommandArgument) SetDefault() {
+	this.SingleArgument.SetDefault()
+	if subparser := this.GetSubParser(); subparser != nil {
+		subparser.SetDefault()
+	}
+}
+
 func (this *SubcommandArgument) String() string {
 	return fmt.Sprintf("<%s>", strings.ToUpper(this.token))
 }
@@ -808,13 +1919,105 @@ func (this *SubcommandArgument) AddSubParserWithHelp(target interface{}, command
 	return this.addSubParser(target, command, desc, callback)
 }
 
+// AddAliases registers alias names of a subcommand, e.g. rm for delete.
+// Either name dispatches to the same sub parser
+func (this *SubcommandArgument) AddAliases(command string, aliases ...string) error {
+	data, ok := this.subcommands[command]
+	if !ok {
+		return fmt.Errorf("No such command %s", command)
+	}
+	for _, alias := range aliases {
+		if this.hasCommand(alias) {
+			return fmt.Errorf("Duplicate subcommand %s", alias)
+		}
+	}
+	for _, alias := range aliases {
+		this.aliases[alias] = command
+		data.aliases = append(data.aliases, alias)
+	}
+	this.subcommands[command] = data
+	return nil
+}
+
+func (this *SubcommandArgument) hasCommand(command string) bool {
+	if _, ok := this.subcommands[command]; ok {
+		return true
+	}
+	_, ok := this.aliases[command]
+	return ok
+}
+
+// canonical returns the subcommand name of an alias
+func (this *SubcommandArgument) canonical(command string) string {
+	if canon, ok := this.aliases[command]; ok {
+		return canon
+	}
+	return command
+}
+
+// SetValue resolves aliases to the canonical subcommand name
+func (this *SubcommandArgument) SetValue(val string) error {
+	if !this.hasCommand(val) {
+		// aliases are also suggested
+		names := argumentChoices(this)
+		cands := FindSimilar(val, names, -1, 0.5)
+		if len(cands) > maxSuggestions {
+			cands = cands[:maxSuggestions]
+		}
+		return &InvalidChoiceError{
+			Argument:    this.Token(),
+			Value:       val,
+			Choices:     this.subcommandNames(),
+			Suggestions: cands,
+		}
+	}
+	return this.SingleArgument.SetValue(this.canonical(val))
+}
+
 func (this *SubcommandArgument) addSubParser(target interface{}, command string, desc string, callback interface{}) (*ArgumentParser, error) {
+	if this.hasCommand(command) {
+		return nil, fmt.Errorf("Duplicate subcommand %s", command)
+	}
+	if callback != nil {
+		cbType := reflect.TypeOf(callback)
+		if cbType.Kind() != reflect.Func {
+			return nil, fmt.Errorf("Callback of subcommand %s must be a function, got %s", command, cbType)
+		}
+		if cbType.NumOut() != 1 || !cbType.Out(0).Implements(errorType) {
+			return nil, fmt.Errorf("Callback of subcommand %s must return exactly an error", command)
+		}
+	}
 	prog := fmt.Sprintf("%s %s", this.parser.prog, command)
 	parser, e := newArgumentParser(target, prog, desc, "")
 	if e != nil {
 		return nil, e
 	}
 	cbfunc := reflect.ValueOf(callback)
+	parser.SetEnvPrefix(this.parser.envPrefix)
+	parser.SetWarningWriter(this.parser.warnWriter)
+	parser.SetResponseFiles(this.parser.responseFiles)
+	parser.SetCollectErrors(this.parser.collectErrors)
+	parser.setHelpTemplates(this.parser.usageTemplate, this.parser.helpTemplate)
+	parser.SetHelpDecorations(!this.parser.noHelpDecorations)
+	parser.SetOutput(this.parser.output)
+	parser.SetInteractive(this.parser.interactive)
+	parser.SetChoicesIgnoreCase(this.parser.choicesIgnoreCase)
+	parser.SetAbbreviationMode(this.parser.abbreviation)
+	parser.SetInterspersed(!this.parser.optionsFirst)
+	if this.parser.naming != nil {
+		if err := parser.SetNamingStrategy(this.parser.naming); err != nil {
+			return nil, err
+		}
+	}
+	parser.promptIn, parser.promptOut = this.parser.promptIn, this.parser.promptOut
+	if err := parser.inheritHelp(this.parser); err != nil {
+		return nil, err
+	}
+	if this.parser.helpCommand {
+		if err := parser.SetHelpCommand(true); err != nil {
+			return nil, err
+		}
+	}
 	this.subcommands[command] = SubcommandArgumentData{parser: parser,
 		callback: cbfunc}
 	this.choices = append(this.choices, command)
@@ -823,9 +2026,15 @@ func (this *SubcommandArgument) addSubParser(target interface{}, command string,
 
 func (this *SubcommandArgument) HelpString(indent string) string {
 	var buf bytes.Buffer
-	for k, data := range this.subcommands {
+	for _, k := range this.subcommandNames() {
+		data := this.subcommands[k]
 		buf.WriteString(indent)
 		buf.WriteString(k)
+		if len(data.aliases) > 0 {
+			buf.WriteString(" (")
+			buf.WriteString(strings.Join(data.aliases, ", "))
+			buf.WriteByte(')')
+		}
 		buf.WriteByte('\n')
 		buf.WriteString(indent)
 		buf.WriteString("  ")
@@ -835,8 +2044,17 @@ func (this *SubcommandArgument) HelpString(indent string) string {
 	return buf.String()
 }
 
+func (this *SubcommandArgument) subcommandNames() []string {
+	names := make([]string, 0, len(this.subcommands))
+	for k := range this.subcommands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (this *SubcommandArgument) SubHelpString(cmd string) (string, error) {
-	val, ok := this.subcommands[cmd]
+	val, ok := this.subcommands[this.canonical(cmd)]
 	if ok {
 		return val.parser.HelpString(), nil
 	} else {
@@ -864,13 +2082,18 @@ func (this *SubcommandArgument) Invoke(args ...interface{}) error {
 	if !ok {
 		return fmt.Errorf("Unknown subcommand %s", cmd)
 	}
+	if !val.callback.IsValid() {
+		return fmt.Errorf("No callback for subcommand %s", cmd)
+	}
 	out := val.callback.Call(inargs)
 	if len(out) == 1 {
-		if out[0].IsNil() {
-			return nil
-		} else {
-			return out[0].Interface().(error)
+		switch out[0].Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			if out[0].IsNil() {
+				return nil
+			}
 		}
+		return out[0].Interface().(error)
 	} else {
 		return fmt.Errorf("Callback return %d unknown outputs", len(out))
 	}
@@ -881,59 +2104,21 @@ func (this *ArgumentParser) ShortDescription() string {
 }
 
 func (this *ArgumentParser) Usage() string {
-	var buf bytes.Buffer
-	buf.WriteString("Usage: ")
-	buf.WriteString(this.prog)
-	for _, arg := range this.optArgs {
-		buf.WriteByte(' ')
-		buf.WriteString(arg.String())
-	}
-	for _, arg := range this.posArgs {
-		buf.WriteByte(' ')
-		buf.WriteString(arg.String())
-		if arg.IsSubcommand() || arg.IsMulti() {
-			buf.WriteString(" ...")
-		}
+	tmpl := this.usageTemplate
+	if tmpl == nil {
+		tmpl = defaultUsageTemplate
 	}
-	buf.WriteByte('\n')
-	buf.WriteByte('\n')
-	return buf.String()
+	return executeHelpTemplate(tmpl, this.helpData())
 }
 
 func (this *ArgumentParser) HelpString() string {
-	var buf bytes.Buffer
-	buf.WriteString(this.Usage())
-	buf.WriteString(this.description)
-	buf.WriteByte('\n')
-	buf.WriteByte('\n')
-	if len(this.posArgs) > 0 {
-		buf.WriteString("Positional arguments:\n")
-		for _, arg := range this.posArgs {
-			buf.WriteString("    ")
-			buf.WriteString(arg.String())
-			buf.WriteByte('\n')
-			buf.WriteString(arg.HelpString("        "))
-			buf.WriteByte('\n')
-		}
-		buf.WriteByte('\n')
-	}
-	if len(this.optArgs) > 0 {
-		buf.WriteString("Optional arguments:\n")
-		for _, arg := range this.optArgs {
-			buf.WriteString("    ")
-			buf.WriteString(arg.String())
-			buf.WriteByte('\n')
-			buf.WriteString(arg.HelpString("        "))
-			buf.WriteByte('\n')
-		}
-		buf.WriteByte('\n')
-	}
-	if len(this.epilog) > 0 {
-		buf.WriteString(this.epilog)
-		buf.WriteByte('\n')
-		buf.WriteByte('\n')
+	tmpl := this.helpTemplate
+	if tmpl == nil {
+		tmpl = defaultHelpTemplate
 	}
-	return buf.String()
+	data := this.helpData()
+	data.Usage = this.Usage()
+	return executeHelpTemplate(tmpl, data)
 }
 
 func tokenMatch(argToken, input string, exactMatch bool) bool {
@@ -944,7 +2129,70 @@ func tokenMatch(argToken, input string, exactMatch bool) bool {
 	}
 }
 
+// sTokenIndex maps the tokens of optional arguments to them, so that words
+// of command lines are looked up in constant time.  It is built on demand
+// and dropped by invalidateTokens whenever optional arguments change
+type sTokenIndex struct {
+	// tokens are the long, short, alias and negative tokens, the first
+	// argument in order wins for duplicates as findOptionalArgument does
+	tokens map[string]sTokenMatch
+	short  map[string]Argument
+	help   *sHelpArg
+}
+
+type sTokenMatch struct {
+	arg      Argument
+	negative bool
+}
+
+func (this *ArgumentParser) tokenIndex() *sTokenIndex {
+	if this.tokens != nil {
+		return this.tokens
+	}
+	index := &sTokenIndex{
+		tokens: make(map[string]sTokenMatch, 2*len(this.optArgs)),
+		short:  make(map[string]Argument),
+	}
+	add := func(token string, arg Argument, negative bool) {
+		if _, ok := index.tokens[token]; !ok && len(token) > 0 {
+			index.tokens[token] = sTokenMatch{arg: arg, negative: negative}
+		}
+	}
+	for _, arg := range this.optArgs {
+		add(arg.Token(), arg, false)
+		add(arg.ShortToken(), arg, false)
+		for _, alias := range argumentAliasTokens(arg) {
+			add(alias, arg, false)
+		}
+		add(arg.NegativeToken(), arg, true)
+		if short := arg.ShortToken(); len(short) > 0 {
+			if _, ok := index.short[short]; !ok {
+				index.short[short] = arg
+			}
+		}
+		if harg, ok := arg.(*sHelpArg); ok && index.help == nil {
+			index.help = harg
+		}
+	}
+	this.tokens = index
+	return index
+}
+
+// invalidateTokens drops the index of tokens after changes of arguments
+func (this *ArgumentParser) invalidateTokens() {
+	this.tokens = nil
+}
+
+// findOptionalArgument finds the argument of token, or the one of the
+// shortest token token abbreviates unless exactMatch.  Exact matches take
+// precedence over abbreviations
 func (this *ArgumentParser) findOptionalArgument(token string, exactMatch bool) (Argument, bool) {
+	if match, ok := this.tokenIndex().tokens[token]; ok {
+		return match.arg, match.negative
+	}
+	if exactMatch {
+		return this.findItemArgument(token)
+	}
 	var match_arg Argument = nil
 	match_len := -1
 	negative := false
@@ -961,141 +2209,561 @@ func (this *ArgumentParser) findOptionalArgument(token string, exactMatch bool)
 				match_arg = arg
 				negative = false
 			}
-		} else if tokenMatch(arg.AliasToken(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.AliasToken()) {
-				match_len = len(arg.AliasToken())
+		} else if alias := matchAliasToken(arg, token, exactMatch); len(alias) > 0 {
+			if match_len < 0 || match_len > len(alias) {
+				match_len = len(alias)
 				match_arg = arg
 				negative = false
 			}
 		} else if tokenMatch(arg.NegativeToken(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.AliasToken()) {
-				match_len = len(arg.AliasToken())
+			if match_len < 0 || match_len > len(arg.NegativeToken()) {
+				match_len = len(arg.NegativeToken())
 				match_arg = arg
 				negative = true
 			}
 		}
 	}
+	if match_arg == nil {
+		return this.findItemArgument(token)
+	}
 	return match_arg, negative
 }
 
-func validateArgs(args []Argument) error {
-	for _, arg := range args {
-		e := arg.Validate()
-		if e != nil {
-			return fmt.Errorf("%s error: %s", arg.Token(), e)
+// matchAliasToken returns the shortest alias token of arg matching token
+func matchAliasToken(arg Argument, token string, exactMatch bool) string {
+	var match string
+	for _, alias := range argumentAliasTokens(arg) {
+		if tokenMatch(alias, token, exactMatch) && (len(match) == 0 || len(alias) < len(match)) {
+			match = alias
 		}
 	}
-	return nil
+	return match
 }
 
-func (this *ArgumentParser) Validate() error {
-	var e error = nil
-	e = validateArgs(this.posArgs)
-	if e != nil {
-		return e
+// findShortArgument finds the argument whose short token is exactly token
+func (this *ArgumentParser) findShortArgument(token string) Argument {
+	if len(token) == 0 {
+		return nil
 	}
-	e = validateArgs(this.optArgs)
-	if e != nil {
-		return e
+	return this.tokenIndex().short[token]
+}
+
+// findShortBundle resolves each character of tokens as a short token.  It
+// returns nil if any of them is not a short token or any but the last one
+// needs data
+func (this *ArgumentParser) findShortBundle(tokens string) []Argument {
+	if len(tokens) < 2 {
+		return nil
 	}
-	return nil
+	bundle := make([]Argument, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		arg := this.findShortArgument(tokens[i : i+1])
+		if arg == nil {
+			return nil
+		}
+		if i < len(tokens)-1 && arg.NeedData() {
+			return nil
+		}
+		bundle = append(bundle, arg)
+	}
+	return bundle
 }
 
-func (this *ArgumentParser) reset() {
-	for _, arg := range this.posArgs {
-		arg.Reset()
+// isNegativeNumber tells whether argStr, e.g. -5 or -0.5, is a value of the
+// numeric positional of pos_idx instead of short options.  Short tokens of
+// digits, e.g. -1, take precedence
+func (this *ArgumentParser) isNegativeNumber(argStr string, pos_idx int) bool {
+	if len(argStr) < 2 || argStr[0] != '-' || (argStr[1] != '.' && (argStr[1] < '0' || argStr[1] > '9')) {
+		return false
 	}
-	for _, arg := range this.optArgs {
-		arg.Reset()
+	if _, err := strconv.ParseFloat(argStr, 64); err != nil {
+		return false
 	}
-	this.help = false
+	if this.findShortArgument(argStr[1:]) != nil || this.findShortBundle(argStr[1:]) != nil {
+		return false
+	}
+	var arg Argument
+	if pos_idx < len(this.posArgs) {
+		arg = this.posArgs[pos_idx]
+	} else if len(this.posArgs) > 0 && this.posArgs[len(this.posArgs)-1].IsMulti() {
+		// the trailing slice positional takes all the rest
+		arg = this.posArgs[len(this.posArgs)-1]
+	}
+	numeric, ok := arg.(interface{ isNumeric() bool })
+	return ok && numeric.isNumeric()
 }
 
-func (this *ArgumentParser) ParseArgs(args []string, ignore_unknown bool) error {
-	return this.ParseArgs2(args, ignore_unknown, true)
+// isNumeric tells whether the argument takes numbers
+func (this *SingleArgument) isNumeric() bool {
+	tp := elemType(this.value.Type())
+	return isNumberKind(tp.Kind()) && !this.isScalar(tp)
 }
 
-func (this *ArgumentParser) ParseArgs2(args []string, ignore_unknown bool, setDefaults bool) error {
-	var pos_idx int
-	var err error
+func validateArgs(args []Argument, all bool) []error {
+	var errs []error
+	for _, arg := range args {
+		e := arg.Validate()
+		if e != nil {
+			errs = append(errs, errors.Wrapf(e, "%s error", arg.Token()))
+			if !all {
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func (this *ArgumentParser) Validate() error {
+	if errs := this.validate(this.posArgs, false); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validate validates posArgs and all the optional arguments, stops at the
+// first error unless all is set
+func (this *ArgumentParser) validate(posArgs []Argument, all bool) []error {
+	var errs []error
+	for _, args := range [][]Argument{posArgs, this.optArgs} {
+		errs = append(errs, validateArgs(args, all)...)
+		if len(errs) > 0 && !all {
+			return errs
+		}
+	}
+	return append(errs, this.validateRequires(all)...)
+}
+
+func (this *ArgumentParser) findArgumentByToken(name string) Argument {
+	// name is the token, or one to derive the token from by naming strategy
+	named := this.namedToken(name)
+	for _, args := range [][]Argument{this.optArgs, this.posArgs} {
+		for _, arg := range args {
+			if arg.Token() == name || arg.Token() == named {
+				return arg
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferences makes sure that the requires and replacement tags refer
+// to known arguments
+func (this *ArgumentParser) checkReferences() error {
+	for _, arg := range this.optArgs {
+		for _, token := range argumentRequires(arg) {
+			if this.findArgumentByToken(token) == nil {
+				return fmt.Errorf("Argument %s requires unknown argument %s", arg.Token(), token)
+			}
+		}
+		if token := argumentReplacement(arg); len(token) > 0 {
+			replacement := this.findArgumentByToken(token)
+			if replacement == nil || replacement.IsPositional() || replacement == arg {
+				return fmt.Errorf("Argument %s has invalid replacement %s", arg.Token(), token)
+			}
+			if len(argumentReplacement(replacement)) > 0 {
+				return fmt.Errorf("Replacement %s of argument %s must not be replaced", token, arg.Token())
+			}
+		}
+	}
+	return nil
+}
+
+// SetWarningWriter sets where the warnings of deprecated arguments go, the
+// default is os.Stderr.  The writer is also applied to the parsers of
+// subcommands
+func (this *ArgumentParser) SetWarningWriter(w io.Writer) {
+	this.warnWriter = w
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.SetWarningWriter(w)
+		}
+	}
+}
+
+// useArgument warns about the use of a deprecated argument and returns the
+// argument to take the value, i.e. its replacement if there is one
+func (this *ArgumentParser) useArgument(arg Argument, argStr string) Argument {
+	msg := argumentDeprecated(arg)
+	if len(msg) == 0 {
+		return arg
+	}
+	w := this.warnWriter
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "Warning: %s is deprecated: %s\n", argStr, msg)
+	if replacement := argumentReplacement(arg); len(replacement) > 0 {
+		if rarg := this.findArgumentByToken(replacement); rarg != nil {
+			return rarg
+		}
+	}
+	return arg
+}
+
+func (this *ArgumentParser) validateRequires(all bool) []error {
+	var errs []error
+	for _, arg := range this.optArgs {
+		if !arg.IsSet() {
+			continue
+		}
+		for _, token := range argumentRequires(arg) {
+			if req := this.findArgumentByToken(token); req == nil || !req.IsSet() {
+				errs = append(errs, &RequiresError{Argument: arg.Token(), Required: token})
+				if !all {
+					return errs
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func (this *ArgumentParser) reset() {
+	for _, arg := range this.posArgs {
+		arg.Reset()
+	}
+	for _, arg := range this.optArgs {
+		arg.Reset()
+	}
+	this.resetSections()
+	this.help = false
+	this.versionSet = false
+	this.rest = nil
+	if this.restField.IsValid() {
+		this.restField.Set(reflect.Zero(this.restField.Type()))
+	}
+}
+
+var (
+	restSliceType = reflect.TypeOf([]string{})
+	restMapType   = reflect.TypeOf(map[string]string{})
+)
+
+func (this *ArgumentParser) setRestField(fv reflect.Value, info *reflectutils.SStructFieldInfo) error {
+	if fv.Type() != restSliceType && fv.Type() != restMapType {
+		return fmt.Errorf("rest field %s must be []string or map[string]string", info.FieldName)
+	}
+	if this.restField.IsValid() {
+		return fmt.Errorf("Duplicate rest field %s", info.FieldName)
+	}
+	this.restField = fv
+	return nil
+}
+
+// collectRest keeps the unrecognized option args[i] in the rest field.  The
+// next argument is taken as its value unless the option has an inline value
+// or the next one is an option too.  It returns the index of the last
+// argument consumed
+func (this *ArgumentParser) collectRest(args []string, i int, key string, value string, hasValue bool) int {
+	last := i
+	if !hasValue && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+		last = i + 1
+		value = args[last]
+	}
+	if this.restField.Type() == restMapType {
+		if this.restField.IsNil() {
+			this.restField.Set(reflect.MakeMap(restMapType))
+		}
+		this.restField.SetMapIndex(reflect.ValueOf(strings.TrimLeft(key, "-")), reflect.ValueOf(value))
+	} else {
+		for _, arg := range args[i : last+1] {
+			this.restField.Set(reflect.Append(this.restField, reflect.ValueOf(arg)))
+		}
+	}
+	return last
+}
+
+func (this *ArgumentParser) ParseArgs(args []string, ignore_unknown bool) error {
+	return this.ParseArgs2(args, ignore_unknown, true)
+}
+
+// ParseString splits line into words the way shell does, honoring single
+// and double quotes and backslash escapes but without expansions, and parses
+// them as ParseArgs does, e.g. command lines of interactive shells or those
+// stored in configurations
+func (this *ArgumentParser) ParseString(line string) error {
+	args, err := splitShellWords(line)
+	if err != nil {
+		return this.handleError(fmt.Errorf("Cannot split command line: %v", err))
+	}
+	return this.ParseArgs(args, false)
+}
+
+// ParseArgs2 parses args into the target.  Parses of a parser shared by
+// goroutines are serialized, see ResetTarget
+func (this *ArgumentParser) ParseArgs2(args []string, ignore_unknown bool, setDefaults bool) error {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+	return this.handleError(this.parseArgs2(args, ignore_unknown, setDefaults))
+}
+
+func (this *ArgumentParser) parseArgs2(args []string, ignore_unknown bool, setDefaults bool) error {
+	var pos_idx int
+	var err error
 	var argStr string
+	// a bare -- ends options, the rest are all positionals
+	var endOfOptions bool
 
 	this.reset()
+	this.source = SOURCE_COMMAND_LINE
 
-	for i := 0; i < len(args) && err == nil; i++ {
+	if len(args) > 0 && args[0] == COMPLETE_COMMAND {
+		// hidden completion mode driven by shell completion scripts
+		for _, cand := range this.Complete(args[1:]) {
+			fmt.Fprintln(this.out(), cand)
+		}
+		this.help = true
+		return nil
+	}
+
+	expanded := 0
+	// errors of the arguments, all of them if collectErrors is set
+	var errs []error
+	for i := 0; i < len(args) && (err == nil || this.collectErrors); i++ {
+		if err != nil {
+			errs = append(errs, err)
+			err = nil
+		}
+		if err = this.Context().Err(); err != nil {
+			break
+		}
 		argStr = args[i]
-		if argStr == "--help" {
+		if !endOfOptions && this.responseFiles && len(argStr) > 1 && argStr[0] == '@' {
+			// values of options are taken before reaching here, thus
+			// --data @body.json is left to the argument
+			if expanded >= maxResponseFiles {
+				err = fmt.Errorf("Too many response files, more than %d", maxResponseFiles)
+				continue
+			}
+			expanded++
+			var tokens []string
+			tokens, err = readResponseFile(argStr[1:])
+			if err != nil {
+				continue
+			}
+			args = append(append(append([]string{}, args[:i]...), tokens...), args[i+1:]...)
+			i--
+			continue
+		}
+		if !endOfOptions && argStr == "--" {
+			endOfOptions = true
+			continue
+		}
+		if !endOfOptions && this.isHelpToken(argStr) {
 			// shortcut to show help
-			fmt.Println(this.HelpString())
+			fmt.Fprintln(this.out(), this.HelpString())
 			this.help = true
 			continue
 		}
-		if strings.HasPrefix(argStr, "-") {
-			arg, nega := this.findOptionalArgument(strings.TrimLeft(argStr, "-"), false)
+		if !endOfOptions && strings.HasPrefix(argStr, "-") && !this.isNegativeNumber(argStr, pos_idx) {
+			var arg Argument
+			var nega bool
+			// --name=value
+			var value string
+			var hasValue bool
+			if strings.HasPrefix(argStr, "--") {
+				if pos := strings.IndexByte(argStr, '='); pos > 0 {
+					value = argStr[pos+1:]
+					argStr = argStr[:pos]
+					hasValue = true
+				}
+			} else {
+				arg = this.findShortArgument(argStr[1:])
+				if arg == nil {
+					// bundled short flags, e.g. -rf, only the last
+					// one may take data
+					if bundle := this.findShortBundle(argStr[1:]); len(bundle) > 0 {
+						for _, barg := range bundle[:len(bundle)-1] {
+							barg = this.useArgument(barg, "-"+barg.ShortToken())
+							if err = barg.DoAction(false); err != nil {
+								break
+							}
+						}
+						if err != nil {
+							continue
+						}
+						arg = bundle[len(bundle)-1]
+					}
+				}
+			}
+			if arg == nil && strings.HasPrefix(argStr, "--") {
+				val := value
+				if !hasValue && i+1 < len(args) {
+					val = args[i+1]
+				}
+				arg = this.findInlineArgument(argStr[2:], val)
+			}
+			if arg == nil {
+				arg, nega, err = this.matchOptionalArgument(strings.TrimLeft(argStr, "-"))
+				if err != nil {
+					continue
+				}
+			}
 			if arg != nil {
-				if arg.NeedData() {
+				arg = this.useArgument(arg, argStr)
+				if hasValue {
+					if nega {
+						err = &NegativeValueError{Argument: argStr}
+						continue
+					}
+					err = arg.SetValue(value)
+					if err != nil {
+						continue
+					}
+				} else if arg.NeedData() {
 					if i+1 < len(args) {
-						err = arg.SetValue(args[i+1])
+						i++
+						err = arg.SetValue(args[i])
 						if err != nil {
-							break
+							continue
 						}
-						i++
 					} else {
-						err = fmt.Errorf("Missing arguments for %s", argStr)
-						break
+						err = &MissingValueError{Argument: argStr}
+						continue
 					}
 				} else {
 					err = arg.DoAction(nega)
 					if err != nil {
-						break
+						continue
 					}
 				}
+			} else if this.restField.IsValid() {
+				i = this.collectRest(args, i, argStr, value, hasValue)
 			} else if !ignore_unknown {
-				err = fmt.Errorf("Unknown optional argument %s", argStr)
-				break
+				err = &UnknownArgumentError{Argument: argStr, Suggestions: this.suggestTokens(argStr)}
+				continue
+			} else {
+				this.rest = append(this.rest, args[i])
 			}
 		} else {
+			if this.optionsFirst && (pos_idx >= len(this.posArgs) || !this.posArgs[pos_idx].IsSubcommand()) {
+				// the rest are all positionals as after --
+				endOfOptions = true
+			}
 			if pos_idx >= len(this.posArgs) {
 				if len(this.posArgs) > 0 {
 					last_arg := this.posArgs[len(this.posArgs)-1]
 					if last_arg.IsMulti() {
-						last_arg.SetValue(argStr)
+						// the trailing slice positional takes all the rest
+						err = last_arg.SetValue(argStr)
+						if err != nil {
+							continue
+						}
 					} else if !ignore_unknown {
-						err = fmt.Errorf("Unknown positional argument %s", argStr)
-						break
+						err = &UnknownArgumentError{Argument: argStr, Positional: true}
+						continue
+					} else {
+						this.rest = append(this.rest, argStr)
 					}
 				} else if !ignore_unknown {
-					err = fmt.Errorf("Unknown positional argument %s", argStr)
-					break
+					err = &UnknownArgumentError{Argument: argStr, Positional: true}
+					continue
+				} else {
+					this.rest = append(this.rest, argStr)
 				}
 			} else {
 				arg := this.posArgs[pos_idx]
 				pos_idx += 1
 				err = arg.SetValue(argStr)
 				if err != nil {
-					break
+					continue
 				}
 				if arg.IsSubcommand() {
 					subarg := arg.(*SubcommandArgument)
 					var subparser = subarg.GetSubParser()
-					err = subparser.ParseArgs(args[i+1:], ignore_unknown)
+					if subparser == nil {
+						err = fmt.Errorf("Unknown subcommand %s", argStr)
+						continue
+					}
+					subargs := args[i+1:]
+					if endOfOptions {
+						subargs = append([]string{"--"}, subargs...)
+					}
+					opts, isHelp := subparser.Options().(*sHelpCommandOptions)
+					if isHelp && len(subargs) == 0 {
+						// positionals are never optional, help alone
+						// shows the help of the parser
+						subparser.reset()
+						err = this.showCommandHelp(nil)
+						break
+					}
+					err = subparser.parseArgs2(subargs, ignore_unknown, setDefaults)
+					this.rest = append(this.rest, subparser.rest...)
+					if isHelp && err == nil && !subparser.help {
+						err = this.showCommandHelp(opts.COMMAND)
+					}
 					break
 				}
 			}
 		}
 	}
-	if err == nil && pos_idx < len(this.posArgs) {
-		err = &NotEnoughArgumentsError{argument: this.posArgs[pos_idx]}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 && pos_idx < len(this.posArgs) && this.canPrompt() {
+		if pos_idx, err = this.promptPositionals(pos_idx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (len(errs) == 0 || this.collectErrors) && pos_idx < len(this.posArgs) {
+		errs = append(errs, &NotEnoughArgumentsError{argument: this.posArgs[pos_idx]})
 	}
-	if err == nil {
-		err = this.Validate()
+	if len(errs) == 0 || this.collectErrors {
+		if e := this.parseEnv(); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == 0 {
+		if e := this.promptOptionals(); e != nil {
+			errs = append(errs, e)
+		}
 	}
+	if len(errs) == 0 || this.collectErrors {
+		// the missing positionals are reported above already
+		errs = append(errs, this.validate(this.posArgs[:pos_idx], this.collectErrors)...)
+	}
+	err = joinErrors(errs)
 	if setDefaults {
-		this.SetDefault()
+		if e := this.ApplyDefaults(); e != nil && err == nil {
+			err = e
+		}
+		if err == nil && !this.help {
+			err = this.postParse()
+		}
+	}
+	if this.helpCommand && this.helpShown() {
+		// errors of the arguments missing are of no concern
+		return ErrHelp
 	}
 	return err
 }
 
+// parseEnv sets the arguments not given on the command line from their bound
+// environment variables
+func (this *ArgumentParser) parseEnv() error {
+	defer this.setSource(SOURCE_ENV)()
+	for _, arg := range this.optArgs {
+		envName := argumentEnvName(arg)
+		if len(envName) == 0 || (arg.IsSet() && !appendsValues(arg)) {
+			continue
+		}
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		if arg.IsMulti() {
+			for _, v := range utils.FindWords([]byte(value), 0) {
+				if err := arg.SetValue(v); err != nil {
+					return errors.Wrapf(err, "env %s", envName)
+				}
+			}
+		} else if err := arg.SetValue(value); err != nil {
+			return errors.Wrapf(err, "env %s", envName)
+		}
+	}
+	return nil
+}
+
 func isQuotedByChar(str string, quoteChar byte) bool {
 	return len(str) >= 2 && str[0] == quoteChar && str[len(str)-1] == quoteChar
 }
@@ -1104,53 +2772,107 @@ func isQuoted(str string) bool {
 	return isQuotedByChar(str, '"') || isQuotedByChar(str, '\'')
 }
 
-func (this *ArgumentParser) parseKeyValue(key, value string) error {
-	arg, nega := this.findOptionalArgument(key, true)
-	if arg != nil {
-		if nega {
-			log.Warningf("Ignore negative token when parse %s=%v", key, value)
-			return nil
+// parseKeyValue sets value of key in configuration to arg.  Values of
+// multi arguments are lists of [a, b], (a b) or a, b.  Quoted values are
+// unescaped, the others are taken as is
+func (this *ArgumentParser) parseKeyValue(arg Argument, key, value string) error {
+	if arg.IsMulti() {
+		if len(value) > 0 && value[0] == '(' {
+			value = strings.TrimSuffix(value[1:], ")")
+		} else if len(value) > 0 && value[0] == '[' {
+			value = strings.TrimSuffix(value[1:], "]")
 		}
-		if arg.IsSet() {
-			return nil
+		values, err := splitConfigList(value)
+		if err != nil {
+			return errors.Wrapf(err, "value of %s", key)
 		}
-		if arg.IsMulti() {
-			if value[0] == '(' {
-				value = strings.Trim(value, "()")
-			} else {
-				value = strings.Trim(value, "[]")
-			}
-			values := utils.FindWords([]byte(value), 0)
-			for _, v := range values {
-				e := arg.SetValue(v)
-				if e != nil {
-					return e
-				}
+		for _, v := range values {
+			e := arg.SetValue(this.expandConfigEnv(v))
+			if e != nil {
+				return e
 			}
-		} else {
-			if !isQuoted(value) {
-				value = fmt.Sprintf("\"%s\"", value)
+		}
+	} else {
+		if isQuoted(value) {
+			values, err := splitConfigList(value)
+			if err != nil {
+				return errors.Wrapf(err, "value of %s", key)
 			}
-			values := utils.FindWords([]byte(value), 0)
-			if len(values) == 1 {
-				return arg.SetValue(values[0])
-			} else {
+			if len(values) != 1 {
+				if isSecretArgument(arg) {
+					values = []string{SECRET_MASK}
+				}
 				log.Warningf("too many arguments %#v for %s", values, key)
+				return nil
 			}
+			value = values[0]
 		}
-	} else {
-		log.Warningf("Cannot find argument %s", key)
+		return arg.SetValue(this.expandConfigEnv(value))
 	}
 	return nil
 }
 
+// overrideValue tells whether arg takes the value being parsed from
+// configuration.  Arguments already set are kept, unless with update or
+// set by the files parsed earlier by ParseFiles or ParseTornadoFile.  Slices
+// and maps being overridden are cleared to be replaced instead of appended
+// to, except those of SLICE_MODE_APPEND, which are always appended to
+// without update
+func (this *ArgumentParser) overrideValue(arg Argument, update bool) bool {
+	if !arg.IsSet() && !update {
+		return true
+	}
+	if !update && appendsValues(arg) {
+		return true
+	}
+	if !update && !this.confSources[argumentSource(arg)] {
+		return false
+	}
+	if marg, ok := arg.(*MultiArgument); ok {
+		marg.clearValue()
+	}
+	return true
+}
+
+// removeComments strips the comment starting with #, except inside quoted
+// strings.  Quotes after space, =, comma and brackets start strings, so
+// that apostrophes of unquoted values are plain characters
 func removeComments(line string) string {
-	pos := strings.IndexByte(line, '#')
-	if pos >= 0 {
-		return line[:pos]
-	} else {
-		return line
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '#':
+			return line[:i]
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t=,[(", line[i-1]) >= 0):
+			quote = c
+		}
 	}
+	return line
+}
+
+// isContinuedLine tells whether line ends with a backslash, comments aside
+func isContinuedLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasSuffix(line, "\\") && !strings.HasPrefix(line, "#")
+}
+
+// heredocMarker returns EOF of value <<EOF
+func heredocMarker(val string) (string, bool) {
+	if !strings.HasPrefix(val, "<<") {
+		return "", false
+	}
+	marker := strings.TrimSpace(val[2:])
+	if len(marker) == 0 || strings.ContainsAny(marker, " \t\"'") {
+		return "", false
+	}
+	return marker, true
 }
 
 func line2KeyValue(line string) (string, string, error) {
@@ -1175,7 +2897,24 @@ func removeCharacters(input, charSet string) string {
 	return strings.Map(filter, input)
 }
 
+// sectionPrefix returns the token prefix of [section] header, dots
+// separating nested sections, e.g. [db.replica] as db-replica-
+func sectionPrefix(line string) string {
+	name := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+	if len(name) == 0 {
+		return ""
+	}
+	return keyToToken(strings.Replace(name, ".", "_", -1)) + "-"
+}
+
+// ParseYAMLFile parses configuration file in YAML format.  Maps map onto
+// members of nested struct and sequences map onto slice arguments
 func (this *ArgumentParser) ParseYAMLFile(filepath string) error {
+	return this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseYAMLFile, false, filepath))
+}
+
+func (this *ArgumentParser) parseYAMLFile(filepath string) error {
+	defer this.setSource(filepath)()
 	content, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return fmt.Errorf("read file %s: %v", filepath, err)
@@ -1191,38 +2930,257 @@ func (this *ArgumentParser) ParseYAMLFile(filepath string) error {
 	return this.parseJSONDict(dict)
 }
 
+// ParseJSONFile parses configuration file in JSON format.  Keys in
+// camelCase, snake_case and kebab-case are all accepted.  Keys that match no
+// argument are errors unless the parser is lenient
+func (this *ArgumentParser) ParseJSONFile(filepath string) error {
+	return this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseJSONFile, false, filepath))
+}
+
+func (this *ArgumentParser) parseJSONFile(filepath string) error {
+	defer this.setSource(filepath)()
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("read file %s: %v", filepath, err)
+	}
+	obj, err := jsonutils.Parse(content)
+	if err != nil {
+		return fmt.Errorf("parse json %s: %v", filepath, err)
+	}
+	dict, ok := obj.(*jsonutils.JSONDict)
+	if !ok {
+		return fmt.Errorf("object %s is not JSONDict", obj.String())
+	}
+	return this.parseJSONDictWithPrefix("", dict, !this.lenient, false)
+}
+
+// SetLenient controls whether unknown keys in JSON configuration file are
+// ignored with a warning instead of being reported as errors
+func (this *ArgumentParser) SetLenient(lenient bool) {
+	this.lenient = lenient
+}
+
+// JSONDict exports values of the arguments into a JSONDict, the inverse of
+// parsing configuration, e.g. to forward parsed options to REST APIs.  Keys
+// are snake_case form of the tokens, e.g. --dns-domain as dns_domain.  With
+// explicitOnly, only the arguments given explicitly are exported, otherwise
+// all but nil and empty values are.  Positional arguments are exported too,
+// though configuration never sets them.  Values of secret arguments are
+// exported as SECRET_MASK, see UnredactedJSONDict
+func (this *ArgumentParser) JSONDict(explicitOnly bool) *jsonutils.JSONDict {
+	return this.jsonDict(explicitOnly, true)
+}
+
+// UnredactedJSONDict exports values as JSONDict does, but with the real
+// values of secret arguments, e.g. to pass credentials on to clients.  The
+// result must not be logged
+func (this *ArgumentParser) UnredactedJSONDict(explicitOnly bool) *jsonutils.JSONDict {
+	return this.jsonDict(explicitOnly, false)
+}
+
+func (this *ArgumentParser) jsonDict(explicitOnly bool, redact bool) *jsonutils.JSONDict {
+	dict := jsonutils.NewDict()
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if arg.IsSubcommand() || (explicitOnly && !arg.IsSet()) {
+				continue
+			}
+			varg, ok := arg.(valueArgument)
+			if !ok {
+				continue
+			}
+			value := varg.getValue()
+			switch value.Kind() {
+			case reflect.Ptr, reflect.Interface:
+				if value.IsNil() {
+					continue
+				}
+			case reflect.Slice, reflect.Map:
+				if value.Len() == 0 {
+					continue
+				}
+			}
+			if redact && isSecretArgument(arg) {
+				dict.Set(configKey(arg), jsonutils.NewString(SECRET_MASK))
+				continue
+			}
+			dict.Set(configKey(arg), exportArgumentValue(arg, value))
+		}
+	}
+	return dict
+}
+
+// exportValue converts value into JSONObject.  Durations and types parsed as
+// a whole, e.g. net.IP, are kept in their string form so that they can be
+// parsed back
+func exportValue(value reflect.Value) jsonutils.JSONObject {
+	tp := value.Type()
+	if tp == durationType || isScalarType(tp) {
+		if stringer, ok := value.Interface().(fmt.Stringer); ok {
+			return jsonutils.NewString(stringer.String())
+		}
+		if value.CanAddr() {
+			if stringer, ok := value.Addr().Interface().(fmt.Stringer); ok {
+				return jsonutils.NewString(stringer.String())
+			}
+		}
+	}
+	switch tp.Kind() {
+	case reflect.Ptr:
+		return exportValue(value.Elem())
+	case reflect.Slice:
+		if !isScalarType(tp) {
+			array := jsonutils.NewArray()
+			for i := 0; i < value.Len(); i++ {
+				array.Add(exportValue(value.Index(i)))
+			}
+			return array
+		}
+	}
+	return jsonutils.Marshal(value.Interface())
+}
+
+// valueArgument is implemented by arguments backed by a field of the target
+type valueArgument interface {
+	getValue() reflect.Value
+}
+
+func (this *SingleArgument) getValue() reflect.Value {
+	return this.value
+}
+
 func (this *ArgumentParser) parseJSONDict(dict *jsonutils.JSONDict) error {
+	return this.parseJSONDictWithPrefix("", dict, false, false)
+}
+
+// UpdateJSONDict applies dict as a partial update of the parsed values, e.g.
+// a PATCH of options at runtime.  Arguments present in dict are overwritten,
+// even if already set, slices and maps are replaced instead of appended to,
+// and the rest are left untouched.  With strict set, keys that match no
+// argument are errors and nothing is updated
+func (this *ArgumentParser) UpdateJSONDict(dict *jsonutils.JSONDict, strict bool) error {
+	if strict {
+		if err := this.checkJSONDictKeys("", dict); err != nil {
+			return err
+		}
+	}
+	defer this.setSource(SOURCE_UPDATE)()
+	return this.parseJSONDictWithPrefix("", dict, strict, true)
+}
+
+// checkJSONDictKeys makes sure that all keys of dict match arguments
+func (this *ArgumentParser) checkJSONDictKeys(prefix string, dict *jsonutils.JSONDict) error {
 	mapJson, err := dict.GetMap()
 	if err != nil {
 		return errors.Wrap(err, "GetMap")
 	}
 	for key, obj := range mapJson {
-		if err := this.parseJSONKeyValue(key, obj); err != nil {
-			return fmt.Errorf("parse json %s: %s: %v", key, obj.String(), err)
+		if arg, _ := this.findOptionalArgument(this.keyToken(prefix+key), true); arg != nil || this.isDisabledToken(this.keyToken(prefix+key)) {
+			continue
+		}
+		subdict, ok := obj.(*jsonutils.JSONDict)
+		if !ok {
+			token := this.keyToken(prefix + key)
+			return &UnknownArgumentError{Argument: token, Suggestions: this.suggestTokens(token)}
+		}
+		if err := this.checkJSONDictKeys(prefix+key+"-", subdict); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// parseJSONDictWithPrefix parses dict whose keys are prefixed by prefix.
+// Nested dicts map onto members of nested struct, the same way as tokens of
+// their command line arguments are prefixed, e.g. {"db":{"host":"x"}} sets
+// --db-host.  With strict set, keys that match no argument are errors.  With
+// update set, values already set are overwritten
+func (this *ArgumentParser) parseJSONDictWithPrefix(prefix string, dict *jsonutils.JSONDict, strict bool, update bool) error {
+	mapJson, err := dict.GetMap()
+	if err != nil {
+		return errors.Wrap(err, "GetMap")
+	}
+	for key, obj := range mapJson {
+		if subdict, ok := obj.(*jsonutils.JSONDict); ok {
+			if arg, _ := this.findOptionalArgument(this.keyToken(prefix+key), true); arg == nil {
+				if err := this.parseJSONDictWithPrefix(prefix+key+"-", subdict, strict, update); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := this.parseJSONKeyValue(prefix+key, obj, strict, update); err != nil {
+			return errors.Wrapf(err, "parse json %s: %s", prefix+key, obj.String())
+		}
+	}
+	return nil
+}
+
+// keyToToken normalizes snake_case and camelCase key into kebab-case token,
+// e.g. dns_domain, dnsDomain and DNSDomain are all converted to dns-domain
 func keyToToken(key string) string {
-	return strings.Replace(strings.Trim(key, " "), "_", "-", -1)
+	key = strings.Trim(key, " ")
+	var buf bytes.Buffer
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == '_':
+			buf.WriteByte('-')
+		case c >= 'A' && c <= 'Z':
+			if i > 0 {
+				prev := key[i-1]
+				if (prev >= 'a' && prev <= 'z') || (prev >= '0' && prev <= '9') ||
+					(prev >= 'A' && prev <= 'Z' && i+1 < len(key) && key[i+1] >= 'a' && key[i+1] <= 'z') {
+					buf.WriteByte('-')
+				}
+			}
+			buf.WriteByte(c + 'a' - 'A')
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
 }
 
-func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObject) error {
-	token := keyToToken(key)
+func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObject, strict bool, update bool) error {
+	token := this.keyToken(key)
 	arg, nega := this.findOptionalArgument(token, true)
 	if arg == nil {
-		log.Warningf("Cannot find argument %s", token)
-		return nil
+		return this.unknownConfigKey(token, strict)
 	}
 	if nega {
 		log.Warningf("Ignore negative token when parse JSONKeyValue %s", token)
 		return nil
 	}
-	if arg.IsSet() {
+	arg = this.useArgument(arg, key)
+	if !this.overrideValue(arg, update) {
 		return nil
 	}
 	// process multi argument
+	if dict, ok := obj.(*jsonutils.JSONDict); ok {
+		// key values of map argument
+		marg, ok := arg.(*MultiArgument)
+		if !ok || !valueIsMap(marg.value) {
+			return fmt.Errorf("%s is not a map argument", token)
+		}
+		mapJson, err := dict.GetMap()
+		if err != nil {
+			return errors.Wrap(err, "GetMap")
+		}
+		for k, v := range mapJson {
+			str, err := v.GetString()
+			if err != nil {
+				return err
+			}
+			if !update {
+				str = this.expandConfigEnv(str)
+			}
+			if err := marg.setMapIndex(k, str); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if arg.IsMulti() {
 		array, err := obj.GetArray()
 		if err != nil {
@@ -1233,6 +3191,9 @@ func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObje
 			if err != nil {
 				return err
 			}
+			if !update {
+				str = this.expandConfigEnv(str)
+			}
 			if err := arg.SetValue(str); err != nil {
 				return err
 			}
@@ -1244,32 +3205,139 @@ func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObje
 	if err != nil {
 		return err
 	}
+	if !update {
+		str = this.expandConfigEnv(str)
+	}
 	return arg.SetValue(str)
 }
 
+// ParseFile parses configuration file.  Files with .yaml, .yml or .toml
+// extension are parsed as YAML or TOML respectively, otherwise YAML is tried
+// first before falling back to the tornado style key = value format.  The
+// hooks of Validator and PostParser implemented by the target are called
+// afterwards
 func (this *ArgumentParser) ParseFile(filepath string) error {
-	if err := this.ParseYAMLFile(filepath); err == nil {
-		return nil
+	if err := this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseFile, false, filepath)); err != nil {
+		return err
+	}
+	return this.postParse()
+}
+
+// ParseFiles parses configuration files in order, as ParseFile does, and
+// calls the hooks once afterwards.  Values from later files override those
+// from earlier ones, slices and maps being replaced instead of appended to,
+// while values from command line and environment are kept.  Source reports
+// the file supplying the value of each argument
+func (this *ArgumentParser) ParseFiles(paths ...string) error {
+	if err := this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseFile, true, paths...)); err != nil {
+		return err
+	}
+	return this.postParse()
+}
+
+func (this *ArgumentParser) parseFile(filepath string) error {
+	var err error
+	switch strings.ToLower(path.Ext(filepath)) {
+	case ".yaml", ".yml":
+		err = this.parseYAMLFile(filepath)
+	case ".toml":
+		err = this.parseTOMLFile(filepath)
+	default:
+		if err = this.parseYAMLFile(filepath); err != nil {
+			err = this.parseTornadoFile(filepath)
+		}
 	}
-	return this.ParseTornadoFile(filepath)
+	return err
 }
 
+// parseReader parses lines of key = value.  Keys under a [section] header
+// are prefixed by the section as arguments of nested struct are, e.g. host
+// under [database] sets --database-host, falling back to the key itself if
+// there is no such argument.  Lines ending with a backslash continue on the
+// next line, and values of "key = <<EOF" are the following lines verbatim
+// up to the line of EOF, e.g. certificates.
+//
+// Repeated keys of a list append to it, and those of the other arguments
+// override the earlier values.  "key += value" appends to the list from
+// files parsed earlier, or its default, instead of replacing it
 func (this *ArgumentParser) parseReader(r io.Reader) error {
 	scanner := bufio.NewScanner(r)
+	prefix := ""
+	// tokens of arguments set by the lines so far
+	seen := make(map[string]bool)
 	for scanner.Scan() {
 		line := scanner.Text()
+		for isContinuedLine(line) && scanner.Scan() {
+			line = strings.TrimSuffix(strings.TrimRight(line, " \t"), "\\") + strings.TrimLeft(scanner.Text(), " \t")
+		}
 		line = strings.TrimSpace(removeComments(line))
 		// line = removeCharacters(line, `"'`)
 		if len(line) > 0 {
 			if line[0] == '[' {
+				prefix = sectionPrefix(line)
+				continue
+			}
+			if pattern, ok := includeDirective(line); ok {
+				if err := this.parseInclude(pattern); err != nil {
+					return err
+				}
 				continue
 			}
 			key, val, e := line2KeyValue(line)
-			if e == nil {
-				this.parseKeyValue(key, val)
-			} else {
+			if e != nil {
 				return e
 			}
+			appendOp := false
+			if strings.HasSuffix(key, "+") {
+				appendOp = true
+				key = strings.TrimSpace(strings.TrimSuffix(key, "+"))
+			}
+			if marker, ok := heredocMarker(val); ok {
+				var lines []string
+				for {
+					if !scanner.Scan() {
+						if err := scanner.Err(); err != nil {
+							return err
+						}
+						return fmt.Errorf("Unterminated value of %s, expecting %s", key, marker)
+					}
+					if strings.TrimSpace(scanner.Text()) == marker {
+						break
+					}
+					lines = append(lines, scanner.Text())
+				}
+				val = configQuote(strings.Join(lines, "\n"))
+			}
+			if len(prefix) > 0 {
+				if arg, _ := this.findOptionalArgument(this.namedToken(prefix+key), true); arg != nil {
+					key = prefix + key
+				}
+			}
+			key = this.namedToken(key)
+			arg, nega := this.findOptionalArgument(key, true)
+			if arg == nil {
+				if e := this.unknownConfigKey(key, false); e != nil {
+					return e
+				}
+				continue
+			}
+			if nega {
+				log.Warningf("Ignore negative token when parse %s=%v", key, val)
+				continue
+			}
+			switch {
+			case seen[arg.Token()]:
+			case appendOp && arg.IsMulti() && (!arg.IsSet() || this.confSources[argumentSource(arg)]):
+				if !arg.IsSet() {
+					if darg, ok := arg.(defaultCopier); ok {
+						darg.copyDefault()
+					}
+				}
+			case !this.overrideValue(arg, false):
+				continue
+			}
+			seen[arg.Token()] = true
+			this.parseKeyValue(arg, key, val)
 		}
 	}
 
@@ -1280,14 +3348,23 @@ func (this *ArgumentParser) parseReader(r io.Reader) error {
 	return nil
 }
 
+// ParseTornadoFile parses configuration file of key = value lines.  Lines
+// of "include PATTERN" parse the files matching the glob pattern in place,
+// relative to the directory of the including file.  Values from later
+// lines and files override earlier ones, but not those from command line
+// or environment
 func (this *ArgumentParser) ParseTornadoFile(filepath string) error {
-	file, e := os.Open(filepath)
-	if e != nil {
-		return e
-	}
-	defer file.Close()
+	return this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseTornadoFile, false, filepath))
+}
 
-	return this.parseReader(file)
+func (this *ArgumentParser) parseTornadoFile(filepath string) error {
+	if this.confSources == nil {
+		this.confSources = make(map[string]bool)
+		defer func() {
+			this.confSources = nil
+		}()
+	}
+	return this.parseConfFile(filepath)
 }
 
 func (this *ArgumentParser) GetSubcommand() *SubcommandArgument {
@@ -1300,8 +3377,43 @@ func (this *ArgumentParser) GetSubcommand() *SubcommandArgument {
 	return nil
 }
 
-func (this *ArgumentParser) ParseKnownArgs(args []string) error {
-	return this.ParseArgs(args, true)
+// GetLeafSubcommand returns the deepest chosen subcommand argument of nested
+// subcommands, e.g. for "prog server instance create", it is the
+// subcommand argument of "prog server instance" whose chosen sub parser is
+// that of "create".  It returns nil if the parser has no subcommand
+func (this *ArgumentParser) GetLeafSubcommand() *SubcommandArgument {
+	subcmd := this.GetSubcommand()
+	for subcmd != nil {
+		subparser := subcmd.GetSubParser()
+		if subparser == nil {
+			break
+		}
+		next := subparser.GetSubcommand()
+		if next == nil || next.GetSubParser() == nil {
+			break
+		}
+		subcmd = next
+	}
+	return subcmd
+}
+
+// AddSubParser adds a subcommand to the subcommand argument of the parser
+func (this *ArgumentParser) AddSubParser(target interface{}, command string, desc string, callback interface{}) (*ArgumentParser, error) {
+	subcmd := this.GetSubcommand()
+	if subcmd == nil {
+		return nil, fmt.Errorf("%s: no subcommand argument", this.targetName())
+	}
+	return subcmd.AddSubParser(target, command, desc, callback)
+}
+
+// ParseKnownArgs parses the recognized arguments and returns the rest in
+// their original order instead of failing on them, so that they can be
+// handed over to another parser
+func (this *ArgumentParser) ParseKnownArgs(args []string) (rest []string, err error) {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+	err = this.handleError(this.parseArgs2(args, true, true))
+	return this.rest, err
 }
 
 func (this *ArgumentParser) GetOptArgs() []Argument {
@@ -1315,3 +3427,59 @@ func (this *ArgumentParser) GetPosArgs() []Argument {
 func (this *ArgumentParser) IsHelpSet() bool {
 	return this.help
 }
+
+// IsSet tells whether the argument of token name, e.g. "port" or "Port", is
+// given explicitly by command line, environment or configuration files
+// instead of being left at its default value
+func (this *ArgumentParser) IsSet(name string) bool {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	return arg != nil && arg.IsSet()
+}
+
+// Source returns where the value of the argument of token name comes from,
+// i.e. SOURCE_COMMAND_LINE, SOURCE_ENV, path of the configuration file or
+// SOURCE_DEFAULT.  It is empty if the argument is unknown or left unset
+func (this *ArgumentParser) Source(name string) string {
+	arg := this.findArgumentByToken(splitCamelString(name))
+	if arg == nil {
+		return ""
+	}
+	return argumentSource(arg)
+}
+
+// Sources returns sources of all arguments having one, keyed by token
+func (this *ArgumentParser) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if source := argumentSource(arg); len(source) > 0 {
+				sources[arg.Token()] = source
+			}
+		}
+	}
+	return sources
+}
+
+// setSource sets the source of the values set afterwards, it returns the
+// function restoring the previous one
+func (this *ArgumentParser) setSource(source string) func() {
+	old := this.source
+	this.source = source
+	return func() {
+		this.source = old
+	}
+}
+
+// ExplicitArgs returns tokens of the arguments given explicitly, positional
+// ones first
+func (this *ArgumentParser) ExplicitArgs() []string {
+	var tokens []string
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if arg.IsSet() {
+				tokens = append(tokens, arg.Token())
+			}
+		}
+	}
+	return tokens
+}
@@ -16,10 +16,15 @@ package structarg
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/nyl1001/pkg/errors"
 	"github.com/nyl1001/pkg/jsonutils"
 )
 
@@ -410,6 +415,31 @@ func TestIgnoreUnexported(t *testing.T) {
 	p.ParseArgs(args, true)
 }
 
+func TestIgnoreField(t *testing.T) {
+	type Client struct {
+		Endpoint string
+	}
+	s := &struct {
+		Name    string
+		Client  *Client  `structarg:"-"`
+		Session Client   `structarg:"-"`
+		Result  chan int `token:"-"`
+		Legacy  string   `ignore:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	for _, token := range []string{"client-endpoint", "session-endpoint", "result", "-", "legacy"} {
+		if p.findArgumentByToken(token) != nil {
+			t.Errorf("field of %s is not ignored", token)
+		}
+	}
+	if err := p.ParseArgs([]string{"--name", "a"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if s.Client != nil {
+		t.Errorf("ignored client is allocated")
+	}
+}
+
 func TestStructMember(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		type L struct {
@@ -445,6 +475,31 @@ func TestStructMember(t *testing.T) {
 			t.Errorf("something does not match\npassed: %#v\ngot: %#v", args, s)
 		}
 	})
+	t.Run("prefix", func(t *testing.T) {
+		type DB struct {
+			Host string
+		}
+		s := &struct {
+			Database DB `prefix:"db"`
+			Replica  DB `token:"replica" prefix:"ro-"`
+			Flat     *struct {
+				Port int
+			} `prefix:""`
+		}{}
+		p := mustNewParser(t, s)
+		args := []string{"--db-host", "h", "--ro-host", "r", "--port", "1"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Database.Host != "h" || s.Replica.Host != "r" || s.Flat == nil || s.Flat.Port != 1 {
+			t.Errorf("something does not match\npassed: %#v\ngot: %#v", args, s)
+		}
+		if _, err := newParser(&struct {
+			Host string `prefix:"db"`
+		}{}); err == nil {
+			t.Errorf("expecting error of prefix on non-struct field")
+		}
+	})
 	t.Run("name duplicate (embedded vs. non-embedded)", func(t *testing.T) {
 		type L struct {
 			MNonPos string `token:"m-non-pos"`
@@ -565,6 +620,16 @@ func Test_keyToToken(t *testing.T) {
 			args: args{"test-case_1"},
 			want: "test-case-1",
 		},
+		{
+			name: "dnsDomainA",
+			args: args{"dnsDomainA"},
+			want: "dns-domain-a",
+		},
+		{
+			name: "DNSDomain",
+			args: args{"DNSDomain"},
+			want: "dns-domain",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -574,3 +639,1470 @@ func Test_keyToToken(t *testing.T) {
 		})
 	}
 }
+
+func TestDurationField(t *testing.T) {
+	t.Run("cli and default", func(t *testing.T) {
+		s := &struct {
+			Timeout    time.Duration
+			Interval   time.Duration `default:"5m"`
+			TimeoutPtr *time.Duration
+			Backoffs   []time.Duration
+		}{}
+		p := mustNewParser(t, s)
+		args := []string{
+			"--timeout", "30s",
+			"--timeout-ptr", "1h30m",
+			"--backoffs", "1s",
+			"--backoffs", "2s",
+		}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Timeout != 30*time.Second {
+			t.Errorf("timeout: want 30s, got %s", s.Timeout)
+		}
+		if s.Interval != 5*time.Minute {
+			t.Errorf("interval: want 5m, got %s", s.Interval)
+		}
+		if s.TimeoutPtr == nil || *s.TimeoutPtr != 90*time.Minute {
+			t.Errorf("timeout-ptr: want 1h30m, got %v", s.TimeoutPtr)
+		}
+		if !reflect.DeepEqual(s.Backoffs, []time.Duration{time.Second, 2 * time.Second}) {
+			t.Errorf("backoffs: got %v", s.Backoffs)
+		}
+	})
+	t.Run("bad value", func(t *testing.T) {
+		s := &struct {
+			Timeout time.Duration
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--timeout", "30"}, false); err == nil {
+			t.Errorf("expecting error for duration without unit")
+		}
+	})
+	t.Run("bad default", func(t *testing.T) {
+		_, err := newParser(&struct {
+			Timeout time.Duration `default:"forever"`
+		}{})
+		if err == nil {
+			t.Errorf("expecting error for bad default duration")
+		}
+	})
+	t.Run(".conf", func(t *testing.T) {
+		s := &struct {
+			Timeout time.Duration `default:"1s"`
+		}{}
+		p := mustNewParser(t, s)
+		r := bytes.NewBufferString(`timeout = 5m`)
+		if err := p.parseReader(r); err != nil {
+			t.Fatalf("parse reader: %v", err)
+		}
+		if s.Timeout != 5*time.Minute {
+			t.Errorf("timeout: want 5m, got %s", s.Timeout)
+		}
+	})
+}
+
+func TestTimeField(t *testing.T) {
+	day := time.Date(2023, 12, 29, 0, 0, 0, 0, time.UTC)
+	t.Run("layout", func(t *testing.T) {
+		s := &struct {
+			Since   time.Time  `layout:"2006-01-02"`
+			Until   *time.Time `layout:"2006-01-02|2006-01-02 15:04"`
+			Created time.Time  `layout:"2006-01-02" default:"2023-12-29"`
+		}{}
+		p := mustNewParser(t, s)
+		args := []string{
+			"--since", "2023-12-29",
+			"--until", "2023-12-29 08:30",
+		}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Since.Equal(day) {
+			t.Errorf("since: got %s", s.Since)
+		}
+		if s.Until == nil || !s.Until.Equal(day.Add(8*time.Hour+30*time.Minute)) {
+			t.Errorf("until: got %v", s.Until)
+		}
+		if !s.Created.Equal(day) {
+			t.Errorf("created: got %s", s.Created)
+		}
+	})
+	t.Run("fallback to RFC3339", func(t *testing.T) {
+		s := &struct {
+			Since time.Time `layout:"2006-01-02"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--since", "2023-12-29T00:00:00Z"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Since.Equal(day) {
+			t.Errorf("since: got %s", s.Since)
+		}
+		if err := p.ParseArgs([]string{"--since", "12/29/2023"}, false); err == nil {
+			t.Errorf("expecting error for unknown layout")
+		}
+	})
+	t.Run(".conf and json", func(t *testing.T) {
+		s := &struct {
+			Since time.Time `layout:"2006-01-02"`
+			Until time.Time `layout:"2006-01-02"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.parseReader(bytes.NewBufferString(`since = 2023-12-29`)); err != nil {
+			t.Fatalf("parse reader: %v", err)
+		}
+		dict := jsonutils.NewDict()
+		dict.Set("until", jsonutils.NewString("2023-12-29"))
+		if err := p.parseJSONDict(dict); err != nil {
+			t.Fatalf("parse json dict: %v", err)
+		}
+		if !s.Since.Equal(day) || !s.Until.Equal(day) {
+			t.Errorf("got since %s, until %s", s.Since, s.Until)
+		}
+	})
+}
+
+func TestEnv(t *testing.T) {
+	os.Setenv("STRUCTARG_TEST_REGION", "region-env")
+	os.Setenv("STRUCTARG_TEST_PORT", "8080")
+	os.Setenv("STRUCTARG_TEST_HOSTS", "h1,h2")
+	os.Setenv("PROG_STRUCTARG_TEST_REGION", "region-prefixed")
+	defer func() {
+		os.Unsetenv("STRUCTARG_TEST_REGION")
+		os.Unsetenv("STRUCTARG_TEST_PORT")
+		os.Unsetenv("STRUCTARG_TEST_HOSTS")
+		os.Unsetenv("PROG_STRUCTARG_TEST_REGION")
+	}()
+	type Options struct {
+		Region  string   `env:"STRUCTARG_TEST_REGION" default:"region-default"`
+		Port    int      `env:"STRUCTARG_TEST_PORT" required:"true"`
+		Hosts   []string `env:"STRUCTARG_TEST_HOSTS"`
+		Missing string   `env:"STRUCTARG_TEST_MISSING" default:"missing-default"`
+	}
+	t.Run("env over config and default", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs2([]string{}, false, false); err != nil {
+			t.Fatalf("ParseArgs2 failed: %s", err)
+		}
+		if err := p.parseReader(bytes.NewBufferString("region = region-conf\nmissing = missing-conf")); err != nil {
+			t.Fatalf("parse reader: %v", err)
+		}
+		p.SetDefault()
+		want := &Options{
+			Region:  "region-env",
+			Port:    8080,
+			Hosts:   []string{"h1", "h2"},
+			Missing: "missing-conf",
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+	})
+	t.Run("cli over env", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--region", "region-cli"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Region != "region-cli" {
+			t.Errorf("want region-cli, got %s", s.Region)
+		}
+	})
+	t.Run("prefix", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		p.SetEnvPrefix("PROG")
+		if err := p.ParseArgs([]string{"--port", "1"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Region != "region-prefixed" {
+			t.Errorf("want region-prefixed, got %s", s.Region)
+		}
+	})
+	t.Run("automatic with prefix", func(t *testing.T) {
+		os.Setenv("PROG_AUTH_URL", "http://auth")
+		os.Setenv("PROG_DEBUG", "true")
+		defer os.Unsetenv("PROG_AUTH_URL")
+		defer os.Unsetenv("PROG_DEBUG")
+		s := &struct {
+			AuthURL string
+			Debug   bool
+			Region  string `env:"STRUCTARG_TEST_REGION"`
+			POS     string
+		}{}
+		p := mustNewParser(t, s)
+		p.SetEnvPrefix("PROG")
+		if err := p.ParseArgs([]string{"pos"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.AuthURL != "http://auth" || !s.Debug || s.Region != "region-prefixed" || s.POS != "pos" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if help := p.HelpString(); !strings.Contains(help, "(env: PROG_AUTH_URL)") {
+			t.Errorf("env not shown in help:\n%s", help)
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		p := mustNewParser(t, &Options{})
+		if help := p.HelpString(); !strings.Contains(help, "(env: STRUCTARG_TEST_REGION)") {
+			t.Errorf("env not shown in help:\n%s", help)
+		}
+	})
+	t.Run("positional", func(t *testing.T) {
+		_, err := newParser(&struct {
+			POS string `env:"STRUCTARG_TEST_POS"`
+		}{})
+		if err == nil {
+			t.Errorf("expecting error for positional with env")
+		}
+	})
+}
+
+func TestParseYAMLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "test.yml")
+	content := `
+region: region-yaml
+port: 8080
+hosts:
+  - h1
+  - h2
+labels:
+  k1: v1
+database:
+  host: db-host
+  port: 3306
+`
+	if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := &struct {
+		Region   string
+		Port     int
+		Hosts    []string
+		Labels   map[string]string
+		Database struct {
+			Host string
+			Port int
+		}
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseFile(fpath); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if s.Region != "region-yaml" || s.Port != 8080 ||
+		!reflect.DeepEqual(s.Hosts, []string{"h1", "h2"}) ||
+		!reflect.DeepEqual(s.Labels, map[string]string{"k1": "v1"}) ||
+		s.Database.Host != "db-host" || s.Database.Port != 3306 {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+}
+
+func TestParseJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	type Options struct {
+		AuthURL   string
+		DNSDomain string
+		AdminUser string
+		Database  struct {
+			Host string
+		}
+	}
+	write := func(content string) string {
+		fpath := filepath.Join(dir, "test.json")
+		if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		return fpath
+	}
+	t.Run("key normalization", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		fpath := write(`{"authUrl":"http://auth","dns_domain":"example.com","admin-user":"admin","database":{"host":"db"}}`)
+		if err := p.ParseJSONFile(fpath); err != nil {
+			t.Fatalf("ParseJSONFile: %v", err)
+		}
+		if s.AuthURL != "http://auth" || s.DNSDomain != "example.com" || s.AdminUser != "admin" || s.Database.Host != "db" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("unknown key", func(t *testing.T) {
+		fpath := write(`{"auth_url":"http://auth","unknown_key":"x"}`)
+		p := mustNewParser(t, &Options{})
+		if err := p.ParseJSONFile(fpath); err == nil || !strings.Contains(err.Error(), "unknown-key") {
+			t.Errorf("expecting error for unknown key, got %v", err)
+		}
+		s := &Options{}
+		p = mustNewParser(t, s)
+		p.SetLenient(true)
+		if err := p.ParseJSONFile(fpath); err != nil {
+			t.Errorf("lenient ParseJSONFile: %v", err)
+		}
+		if s.AuthURL != "http://auth" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+}
+
+func TestShortToken(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		s := &struct {
+			Verbose bool   `short:"v"`
+			Version bool   `short-token:"V"`
+			Output  string `short:"o"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"-v", "-o", "out.txt"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Verbose || s.Version || s.Output != "out.txt" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if err := p.ParseArgs([]string{"-V", "--output", "out2.txt"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Verbose || !s.Version || s.Output != "out2.txt" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("usage", func(t *testing.T) {
+		p := mustNewParser(t, &struct {
+			Output string `short:"o"`
+		}{})
+		want := "[--output|-o OUTPUT]"
+		if usage := p.Usage(); !strings.Contains(usage, want) {
+			t.Errorf("usage %q does not contain %q", usage, want)
+		}
+		if help := p.HelpString(); !strings.Contains(help, want) {
+			t.Errorf("help %q does not contain %q", help, want)
+		}
+	})
+	t.Run("bundle", func(t *testing.T) {
+		s := &struct {
+			Recursive bool   `short:"r"`
+			Force     bool   `short:"f"`
+			Verbose   bool   `short:"v"`
+			Output    string `short:"o"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"-rf"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Recursive || !s.Force || s.Verbose || s.Output != "" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if err := p.ParseArgs([]string{"-vro", "out.txt"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Recursive || s.Force || !s.Verbose || s.Output != "out.txt" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if err := p.ParseArgs([]string{"-orf", "out.txt"}, false); err == nil {
+			t.Errorf("expecting error when flag taking data is not the last one")
+		}
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		_, err := newParser(&struct {
+			Verbose bool `short:"v"`
+			Version bool `short:"v"`
+		}{})
+		if err == nil {
+			t.Errorf("expecting error for duplicate short token")
+		}
+	})
+}
+
+func TestEqualSignValue(t *testing.T) {
+	s := &struct {
+		Name    string
+		Query   string
+		Empty   *string
+		Hosts   []string
+		Debug   bool
+		Enabled bool `default:"true" negative:"disabled"`
+	}{}
+	p := mustNewParser(t, s)
+	args := []string{
+		"--name=hello",
+		"--query=a=b&c=d",
+		"--empty=",
+		"--hosts=h1",
+		"--hosts", "h2",
+		"--debug=true",
+		"--enabled=false",
+	}
+	if err := p.ParseArgs(args, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Name != "hello" || s.Query != "a=b&c=d" || s.Empty == nil || *s.Empty != "" ||
+		!reflect.DeepEqual(s.Hosts, []string{"h1", "h2"}) || !s.Debug || s.Enabled {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.ParseArgs([]string{"--disabled=true"}, false); err == nil {
+		t.Errorf("expecting error for negative token with value")
+	}
+}
+
+func TestFindOptionalArgument(t *testing.T) {
+	s := &struct {
+		Log     bool
+		Logging bool `negative:"log-off"`
+		Region  string
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--log", "--reg", "r1"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if !s.Log || s.Logging || s.Region != "r1" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.RemoveArgument("region"); err != nil {
+		t.Fatalf("RemoveArgument: %v", err)
+	}
+	if err := p.ParseArgs([]string{"--region", "r1"}, false); err == nil {
+		t.Errorf("expecting error for removed argument")
+	}
+	if err := p.SetHelpToken("usage", ""); err != nil {
+		t.Fatalf("SetHelpToken: %v", err)
+	}
+	if err := p.ParseArgs([]string{"--usage"}, false); err != nil || !p.IsHelpSet() {
+		t.Errorf("expecting help of renamed token, got %v", err)
+	}
+}
+
+func TestEndOfOptions(t *testing.T) {
+	s := &struct {
+		Debug bool
+		NAME  string
+		ARGS  []string
+	}{}
+	p := mustNewParser(t, s)
+	args := []string{"--debug", "--", "--literal-string", "-x", "--", "--help"}
+	if err := p.ParseArgs(args, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if !s.Debug || s.NAME != "--literal-string" || !reflect.DeepEqual(s.ARGS, []string{"-x", "--", "--help"}) {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if p.IsHelpSet() {
+		t.Errorf("--help after -- should not be regarded as help")
+	}
+}
+
+func TestCountField(t *testing.T) {
+	s := &struct {
+		Verbose int  `short:"v" count:"true"`
+		Quiet   uint `short:"q" count:"true"`
+		Debug   bool `short:"d"`
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"-vvv", "-q", "--verbose", "-dq"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Verbose != 4 || s.Quiet != 2 || !s.Debug {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.ParseArgs([]string{}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Verbose != 0 || s.Quiet != 0 {
+		t.Errorf("count not reset: %#v", s)
+	}
+	if err := p.parseReader(bytes.NewBufferString("verbose = 2")); err != nil {
+		t.Fatalf("parse reader: %v", err)
+	}
+	if s.Verbose != 2 {
+		t.Errorf("verbose from conf: want 2, got %d", s.Verbose)
+	}
+	_, err := newParser(&struct {
+		Verbose string `count:"true"`
+	}{})
+	if err == nil {
+		t.Errorf("expecting error for count on string field")
+	}
+}
+
+func TestMapField(t *testing.T) {
+	s := &struct {
+		Label      map[string]string
+		Annotation map[string]string `separator:":"`
+		Weight     map[string]int
+	}{}
+	p := mustNewParser(t, s)
+	args := []string{
+		"--label", "k1=v1",
+		"--label", "k2=v=2",
+		"--annotation", "a1:http://x",
+		"--weight", "w1=10",
+	}
+	if err := p.ParseArgs(args, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if !reflect.DeepEqual(s.Label, map[string]string{"k1": "v1", "k2": "v=2"}) ||
+		!reflect.DeepEqual(s.Annotation, map[string]string{"a1": "http://x"}) ||
+		!reflect.DeepEqual(s.Weight, map[string]int{"w1": 10}) {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.ParseArgs([]string{"--weight", "w1=heavy"}, false); err == nil {
+		t.Errorf("expecting error for bad map value")
+	}
+	t.Run("json", func(t *testing.T) {
+		s := &struct {
+			Annotation map[string]string `separator:":"`
+		}{}
+		p := mustNewParser(t, s)
+		dict := jsonutils.NewDict()
+		dict.Set("annotation", jsonutils.Marshal(map[string]string{"a1": "x:y"}))
+		if err := p.parseJSONDict(dict); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		if !reflect.DeepEqual(s.Annotation, map[string]string{"a1": "x:y"}) {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("separator on non-map", func(t *testing.T) {
+		_, err := newParser(&struct {
+			Label []string `separator:":"`
+		}{})
+		if err == nil {
+			t.Errorf("expecting error for separator on slice")
+		}
+	})
+}
+
+func TestVariadicPositional(t *testing.T) {
+	t.Run("trailing", func(t *testing.T) {
+		s := &struct {
+			DEST  string
+			SRC   []string
+			Force bool
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"dst", "s1", "--force", "s2", "s3"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.DEST != "dst" || !reflect.DeepEqual(s.SRC, []string{"s1", "s2", "s3"}) || !s.Force {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if err := p.ParseArgs([]string{"dst"}, false); err == nil {
+			t.Errorf("expecting error for missing variadic positional")
+		}
+		if usage := p.Usage(); !strings.Contains(usage, "<DEST> <SRC> ...") {
+			t.Errorf("wrong usage %q", usage)
+		}
+	})
+	t.Run("typed", func(t *testing.T) {
+		s := &struct {
+			PORT []int
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"80", "443"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !reflect.DeepEqual(s.PORT, []int{80, 443}) {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if err := p.ParseArgs([]string{"80", "https"}, false); err == nil {
+			t.Errorf("expecting error for bad trailing value")
+		}
+	})
+	t.Run("not last", func(t *testing.T) {
+		_, err := newParser(&struct {
+			SRC  []string
+			DEST string
+		}{})
+		if err == nil {
+			t.Errorf("expecting error for variadic positional not being the last")
+		}
+	})
+}
+
+func TestSubcommand(t *testing.T) {
+	type CreateOptions struct {
+		NAME string
+		Size int `default:"10"`
+	}
+	type DeleteOptions struct {
+		ID    []string
+		Force bool
+	}
+	s := &struct {
+		Debug      bool
+		Region     string
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	if subcmd == nil {
+		t.Fatalf("no subcommand argument")
+	}
+	var created *CreateOptions
+	if _, err := subcmd.AddSubParser(&CreateOptions{}, "create", "Create", func(opts *CreateOptions) error {
+		created = opts
+		return nil
+	}); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if _, err := p.AddSubParser(&DeleteOptions{}, "delete", "Delete", func(opts *DeleteOptions) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	t.Run("dispatch", func(t *testing.T) {
+		if err := p.ParseArgs2([]string{"--debug", "--region", "r1", "create", "vm1"}, false, false); err != nil {
+			t.Fatalf("ParseArgs2 failed: %s", err)
+		}
+		subparser := subcmd.GetSubParser()
+		if subparser == nil {
+			t.Fatalf("no sub parser")
+		}
+		opts := subparser.Options().(*CreateOptions)
+		if opts.Size != 0 {
+			t.Errorf("default set before SetDefault: %#v", opts)
+		}
+		p.SetDefault()
+		if !s.Debug || s.Region != "r1" || s.SUBCOMMAND != "create" || opts.NAME != "vm1" || opts.Size != 10 {
+			t.Errorf("wrong parse result: %#v %#v", s, opts)
+		}
+		if err := subcmd.Invoke(opts); err != nil {
+			t.Fatalf("Invoke: %v", err)
+		}
+		if created != opts {
+			t.Errorf("callback not invoked with sub options")
+		}
+	})
+	t.Run("concrete error", func(t *testing.T) {
+		p := mustNewParser(t, &struct {
+			SUBCOMMAND string `subcommand:"true"`
+		}{})
+		for _, c := range []struct {
+			command  string
+			callback interface{}
+			err      bool
+		}{
+			{"ok", func(opts *DeleteOptions) *UnknownArgumentError { return nil }, false},
+			{"fail", func(opts *DeleteOptions) *UnknownArgumentError { return &UnknownArgumentError{Argument: "x"} }, true},
+		} {
+			if _, err := p.AddSubParser(&DeleteOptions{}, c.command, c.command, c.callback); err != nil {
+				t.Fatalf("AddSubParser %s: %v", c.command, err)
+			}
+			if err := p.ParseArgs([]string{c.command, "id1"}, false); err != nil {
+				t.Fatalf("ParseArgs %s: %v", c.command, err)
+			}
+			subcmd := p.GetSubcommand()
+			if err := subcmd.Invoke(subcmd.GetSubParser().Options()); (err != nil) != c.err {
+				t.Errorf("%s: unexpected error %v", c.command, err)
+			}
+		}
+	})
+	t.Run("unknown subcommand", func(t *testing.T) {
+		if err := p.ParseArgs([]string{"creat", "vm1"}, false); err == nil || !strings.Contains(err.Error(), "did you mean") {
+			t.Errorf("expecting suggestion error, got %v", err)
+		}
+	})
+	t.Run("bad sub parser", func(t *testing.T) {
+		if _, err := subcmd.AddSubParser(&DeleteOptions{}, "delete", "Delete", nil); err == nil {
+			t.Errorf("expecting error for duplicate subcommand")
+		}
+		if _, err := subcmd.AddSubParser(&DeleteOptions{}, "remove", "Remove", func(opts *DeleteOptions) {}); err == nil {
+			t.Errorf("expecting error for callback without error output")
+		}
+		if _, err := mustNewParser(t, &DeleteOptions{}).AddSubParser(&DeleteOptions{}, "remove", "Remove", nil); err == nil {
+			t.Errorf("expecting error for parser without subcommand argument")
+		}
+	})
+}
+
+func TestNestedSubcommand(t *testing.T) {
+	type CreateOptions struct {
+		NAME string
+	}
+	type InstanceOptions struct {
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	type ServerOptions struct {
+		Zone       string
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	s := &struct {
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	server, err := p.AddSubParser(&ServerOptions{}, "server", "Server commands", nil)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	instance, err := server.AddSubParser(&InstanceOptions{}, "instance", "Instance commands", nil)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	var created string
+	create, err := instance.AddSubParser(&CreateOptions{}, "create", "Create an instance", func(opts *CreateOptions) error {
+		created = opts.NAME
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if _, err := instance.AddSubParser(&CreateOptions{}, "delete", "Delete an instance", nil); err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := p.ParseArgs([]string{"server", "--zone", "z1", "instance", "create", "vm1"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if zone := server.Options().(*ServerOptions).Zone; zone != "z1" {
+		t.Errorf("zone: want z1, got %s", zone)
+	}
+	leaf := p.GetLeafSubcommand()
+	if leaf == nil || leaf.GetSubParser() != create {
+		t.Fatalf("wrong leaf subcommand")
+	}
+	if err := leaf.Invoke(leaf.GetSubParser().Options()); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if created != "vm1" {
+		t.Errorf("callback got %q", created)
+	}
+	if usage := create.Usage(); !strings.HasPrefix(usage, "Usage: prog server instance create ") {
+		t.Errorf("wrong usage %q", usage)
+	}
+	help := instance.HelpString()
+	if !strings.Contains(help, "        create\n          Create an instance\n        delete\n") {
+		t.Errorf("children not listed in help:\n%s", help)
+	}
+}
+
+func TestSubcommandAlias(t *testing.T) {
+	type DeleteOptions struct {
+		ID string
+	}
+	s := &struct {
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	deleted := ""
+	del, err := subcmd.AddSubParser(&DeleteOptions{}, "delete", "Delete", func(opts *DeleteOptions) error {
+		deleted = opts.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	if err := subcmd.AddAliases("delete", "rm", "del"); err != nil {
+		t.Fatalf("AddAliases: %v", err)
+	}
+	t.Run("dispatch", func(t *testing.T) {
+		if err := p.ParseArgs([]string{"rm", "vm1"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.SUBCOMMAND != "delete" || subcmd.GetSubParser() != del {
+			t.Fatalf("alias not resolved: %q", s.SUBCOMMAND)
+		}
+		if err := subcmd.Invoke(del.Options()); err != nil {
+			t.Fatalf("Invoke: %v", err)
+		}
+		if deleted != "vm1" {
+			t.Errorf("callback got %q", deleted)
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		if help := p.HelpString(); !strings.Contains(help, "delete (rm, del)\n") {
+			t.Errorf("aliases not listed in help:\n%s", help)
+		}
+		if _, err := subcmd.SubHelpString("rm"); err != nil {
+			t.Errorf("SubHelpString of alias: %v", err)
+		}
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		if err := subcmd.AddAliases("delete", "rm"); err == nil {
+			t.Errorf("expecting duplicate alias error")
+		}
+		if _, err := subcmd.AddSubParser(&DeleteOptions{}, "del", "Delete", nil); err == nil {
+			t.Errorf("expecting error for subcommand shadowing alias")
+		}
+		if err := subcmd.AddAliases("list", "ls"); err == nil {
+			t.Errorf("expecting error for unknown subcommand")
+		}
+	})
+}
+
+func TestParseKnownArgs(t *testing.T) {
+	s := &struct {
+		Debug bool
+		Name  string
+		IMAGE string
+	}{}
+	p := mustNewParser(t, s)
+	rest, err := p.ParseKnownArgs([]string{"--debug", "--extra=1", "img", "--name", "n1", "--verbose", "more"})
+	if err != nil {
+		t.Fatalf("ParseKnownArgs failed: %s", err)
+	}
+	if !s.Debug || s.Name != "n1" || s.IMAGE != "img" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	want := []string{"--extra=1", "--verbose", "more"}
+	if !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest: want %q, got %q", want, rest)
+	}
+	rest, err = p.ParseKnownArgs([]string{"img"})
+	if err != nil {
+		t.Fatalf("ParseKnownArgs failed: %s", err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("rest not reset: %q", rest)
+	}
+}
+
+func TestRestField(t *testing.T) {
+	t.Run("slice", func(t *testing.T) {
+		s := &struct {
+			Debug bool
+			Extra []string `structarg:"rest"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--zone", "z1", "--debug", "--dry-run", "--size=10", "-x"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		want := []string{"--zone", "z1", "--dry-run", "--size=10", "-x"}
+		if !s.Debug || !reflect.DeepEqual(s.Extra, want) {
+			t.Errorf("want %q, got %#v", want, s)
+		}
+		if err := p.ParseArgs([]string{"--debug"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if len(s.Extra) != 0 {
+			t.Errorf("rest field not reset: %q", s.Extra)
+		}
+	})
+	t.Run("map", func(t *testing.T) {
+		s := &struct {
+			Debug bool
+			Extra map[string]string `structarg:"rest"`
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--zone", "z1", "--dry-run", "--debug", "--size=10"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		want := map[string]string{"zone": "z1", "dry-run": "", "size": "10"}
+		if !s.Debug || !reflect.DeepEqual(s.Extra, want) {
+			t.Errorf("want %v, got %#v", want, s)
+		}
+	})
+	t.Run("bad type", func(t *testing.T) {
+		s := &struct {
+			Extra []int `structarg:"rest"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for rest field of []int")
+		}
+	})
+}
+
+func TestGroupHelp(t *testing.T) {
+	type NetworkOptions struct {
+		Ip      string `help:"IP address"`
+		Gateway string `help:"Gateway"`
+		Dns     string `help:"DNS server" group:"Name service"`
+	}
+	s := &struct {
+		Debug   bool           `help:"Debug mode"`
+		Net     NetworkOptions `group:"Networking"`
+		Timeout int            `help:"Timeout" group:"Networking"`
+		Region  string         `help:"Region"`
+	}{}
+	p := mustNewParser(t, s)
+	help := p.HelpString()
+	want := "Optional arguments:\n" +
+		"    [--region REGION]\n        Region\n" +
+		"    [--help]\n        Print usage and this help message and exit.\n" +
+		"    [--debug]\n        Debug mode\n" +
+		"\n" +
+		"Networking:\n" +
+		"    [--net-ip NET_IP]\n        IP address\n" +
+		"    [--net-gateway NET_GATEWAY]\n        Gateway\n" +
+		"    [--timeout TIMEOUT]\n        Timeout\n" +
+		"\n" +
+		"Name service:\n" +
+		"    [--net-dns NET_DNS]\n        DNS server\n"
+	if !strings.Contains(help, want) {
+		t.Errorf("want groups\n%s\ngot\n%s", want, help)
+	}
+}
+
+func TestRequires(t *testing.T) {
+	s := &struct {
+		KeyFile  string `requires:"cert-file"`
+		CertFile string
+		CaFile   string `requires:"cert-file|key-file"`
+	}{}
+	p := mustNewParser(t, s)
+	cases := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{}, ""},
+		{[]string{"--cert-file", "c"}, ""},
+		{[]string{"--key-file", "k", "--cert-file", "c"}, ""},
+		{[]string{"--key-file", "k"}, "Argument --key-file requires --cert-file"},
+		{[]string{"--ca-file", "a", "--cert-file", "c"}, "Argument --ca-file requires --key-file"},
+	}
+	for _, c := range cases {
+		err := p.ParseArgs(c.args, false)
+		if len(c.wantErr) == 0 {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", c.args, err)
+			}
+		} else if err == nil || err.Error() != c.wantErr {
+			t.Errorf("%q: want error %q, got %v", c.args, c.wantErr, err)
+		}
+	}
+	t.Run("unknown", func(t *testing.T) {
+		s := &struct {
+			KeyFile string `requires:"cert"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for unknown required argument")
+		}
+	})
+}
+
+func TestHidden(t *testing.T) {
+	s := &struct {
+		Name      string `help:"Name"`
+		DebugDump bool   `help:"Dump internal states" hidden:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--debug-dump"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if !s.DebugDump {
+		t.Errorf("hidden argument not parsed")
+	}
+	if help := p.HelpString(); strings.Contains(help, "debug-dump") || !strings.Contains(help, "--name") {
+		t.Errorf("hidden argument in help:\n%s", help)
+	}
+	if cands := p.Complete([]string{"--d"}); len(cands) != 0 {
+		t.Errorf("hidden argument completed: %q", cands)
+	}
+	t.Run("positional", func(t *testing.T) {
+		s := &struct {
+			NAME string `hidden:"true"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for hidden positional")
+		}
+	})
+}
+
+func TestDeprecated(t *testing.T) {
+	s := &struct {
+		Endpoint string `help:"Service endpoint"`
+		Url      string `help:"Service URL" deprecated:"use --endpoint instead" replacement:"endpoint"`
+		Insecure bool   `deprecated:"always verified"`
+	}{}
+	p := mustNewParser(t, s)
+	var buf bytes.Buffer
+	p.SetWarningWriter(&buf)
+	if err := p.ParseArgs([]string{"--url", "http://a", "--insecure"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Endpoint != "http://a" || s.Url != "" || !s.Insecure {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	want := "Warning: --url is deprecated: use --endpoint instead\nWarning: --insecure is deprecated: always verified\n"
+	if buf.String() != want {
+		t.Errorf("warnings: want %q, got %q", want, buf.String())
+	}
+	if help := p.HelpString(); !strings.Contains(help, "Service URL (deprecated: use --endpoint instead)") {
+		t.Errorf("deprecation not in help:\n%s", help)
+	}
+	t.Run("config", func(t *testing.T) {
+		buf.Reset()
+		p.reset()
+		if err := p.parseJSONDict(jsonutils.Marshal(map[string]string{"url": "http://b"}).(*jsonutils.JSONDict)); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		if s.Endpoint != "http://b" || !strings.Contains(buf.String(), "url is deprecated") {
+			t.Errorf("config value not forwarded: %#v %q", s, buf.String())
+		}
+	})
+	t.Run("bad replacement", func(t *testing.T) {
+		s := &struct {
+			Url string `deprecated:"renamed" replacement:"endpoint"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for unknown replacement")
+		}
+	})
+}
+
+func TestAliasTokens(t *testing.T) {
+	s := &struct {
+		Endpoint string `alias:"url,server"`
+		Debug    bool
+	}{}
+	p := mustNewParser(t, s)
+	for _, token := range []string{"--endpoint", "--url", "--server"} {
+		if err := p.ParseArgs([]string{token, "http://a"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Endpoint != "http://a" {
+			t.Errorf("%s: got %q", token, s.Endpoint)
+		}
+		s.Endpoint = ""
+	}
+	if usage := p.Usage(); !strings.Contains(usage, "[--endpoint|--url|--server ENDPOINT]") {
+		t.Errorf("aliases not in usage: %s", usage)
+	}
+	t.Run("duplicate", func(t *testing.T) {
+		s := &struct {
+			Endpoint string `alias:"url,server"`
+			Server   string
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil || !strings.Contains(err.Error(), "Duplicate token --server") {
+			t.Errorf("expecting duplicate token error, got %v", err)
+		}
+		s2 := &struct {
+			Endpoint string `alias:"url"`
+			Address  string `alias:"url"`
+		}{}
+		if _, err := NewArgumentParser(s2, "prog", "", ""); err == nil {
+			t.Errorf("expecting duplicate alias error")
+		}
+	})
+}
+
+func TestExplicitArgs(t *testing.T) {
+	s := &struct {
+		Port    int `default:"8080"`
+		Debug   bool
+		Region  string `env:"TEST_EXPLICIT_REGION"`
+		Timeout int    `default:"30"`
+		NAME    string
+	}{}
+	p := mustNewParser(t, s)
+	os.Setenv("TEST_EXPLICIT_REGION", "r1")
+	defer os.Unsetenv("TEST_EXPLICIT_REGION")
+	if err := p.ParseArgs([]string{"--port", "0", "n1"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if err := p.parseJSONDict(jsonutils.Marshal(map[string]int{"timeout": 60}).(*jsonutils.JSONDict)); err != nil {
+		t.Fatalf("parseJSONDict: %v", err)
+	}
+	for name, want := range map[string]bool{"port": true, "Port": true, "debug": false, "region": true, "timeout": true, "NAME": true, "nonexist": false} {
+		if got := p.IsSet(name); got != want {
+			t.Errorf("IsSet(%s): want %v, got %v", name, want, got)
+		}
+	}
+	if s.Port != 0 {
+		t.Errorf("explicit zero port overridden: %d", s.Port)
+	}
+	want := map[string]bool{"name": true, "port": true, "region": true, "timeout": true}
+	got := p.ExplicitArgs()
+	if len(got) != len(want) || got[0] != "name" {
+		t.Errorf("ExplicitArgs: got %q", got)
+	}
+	for _, token := range got {
+		if !want[token] {
+			t.Errorf("unexpected explicit arg %s", token)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	s := &struct {
+		Port    int `default:"8080"`
+		Region  string
+		Zone    string `env:"TEST_SOURCE_ZONE"`
+		Timeout int
+		Debug   bool
+	}{}
+	p := mustNewParser(t, s)
+	os.Setenv("TEST_SOURCE_ZONE", "z1")
+	defer os.Unsetenv("TEST_SOURCE_ZONE")
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "conf.yaml")
+	if err := ioutil.WriteFile(conf, []byte("timeout: 10\nregion: r2\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := p.ParseArgs2([]string{"--region", "r1"}, false, false); err != nil {
+		t.Fatalf("ParseArgs2 failed: %s", err)
+	}
+	if err := p.ParseFile(conf); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	p.SetDefault()
+	want := map[string]string{
+		"port":    SOURCE_DEFAULT,
+		"region":  SOURCE_COMMAND_LINE,
+		"zone":    SOURCE_ENV,
+		"timeout": conf,
+	}
+	if got := p.Sources(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Sources: want %v, got %v", want, got)
+	}
+	if got := p.Source("Timeout"); got != conf {
+		t.Errorf("Source(Timeout): want %s, got %s", conf, got)
+	}
+	if got := p.Source("debug"); got != "" {
+		t.Errorf("Source(debug): want empty, got %s", got)
+	}
+}
+
+func TestJSONDict(t *testing.T) {
+	type DBOptions struct {
+		Host string
+		Port int `default:"3306"`
+	}
+	type Options struct {
+		DnsDomain string
+		Debug     bool
+		Timeout   time.Duration `default:"30s"`
+		Labels    map[string]string
+		Tags      []string
+		Db        DBOptions
+		NAME      string
+	}
+	s := &Options{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--dns-domain", "example.com", "--label", "k=v", "--tags", "a", "--db-host", "h1", "n1"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	t.Run("explicit only", func(t *testing.T) {
+		want := `{"db_host":"h1","dns_domain":"example.com","labels":{"k":"v"},"name":"n1","tags":["a"]}`
+		if got := p.JSONDict(true).String(); got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+	})
+	t.Run("round trip", func(t *testing.T) {
+		dict := p.JSONDict(false)
+		if !dict.Contains("db_port") || !dict.Contains("debug") || !dict.Contains("timeout") {
+			t.Errorf("missing values: %s", dict)
+		}
+		s2 := &Options{}
+		p2 := mustNewParser(t, s2)
+		// positional arguments are not parsed from configuration
+		dict.Remove("name")
+		if err := p2.parseJSONDict(dict); err != nil {
+			t.Fatalf("parseJSONDict: %v", err)
+		}
+		s2.NAME = s.NAME
+		if !reflect.DeepEqual(s, s2) {
+			t.Errorf("round trip: want %#v, got %#v", s, s2)
+		}
+	})
+}
+
+func TestUpdateJSONDict(t *testing.T) {
+	s := &struct {
+		Region string
+		Port   int `default:"80"`
+		Tags   []string
+		Labels map[string]string
+		Debug  bool
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--region", "r1", "--tags", "a", "--labels", "k1=v1", "--debug"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	update, _ := jsonutils.ParseString(`{"region":"r2","tags":["b","c"],"labels":{"k2":"v2"}}`)
+	if err := p.UpdateJSONDict(update.(*jsonutils.JSONDict), true); err != nil {
+		t.Fatalf("UpdateJSONDict: %v", err)
+	}
+	if s.Region != "r2" || s.Port != 80 || !s.Debug ||
+		!reflect.DeepEqual(s.Tags, []string{"b", "c"}) ||
+		!reflect.DeepEqual(s.Labels, map[string]string{"k2": "v2"}) {
+		t.Errorf("wrong update result: %#v", s)
+	}
+	if src := p.Source("region"); src != SOURCE_UPDATE {
+		t.Errorf("source of updated value: %s", src)
+	}
+	if src := p.Source("debug"); src != SOURCE_COMMAND_LINE {
+		t.Errorf("source of untouched value: %s", src)
+	}
+	t.Run("strict", func(t *testing.T) {
+		update, _ := jsonutils.ParseString(`{"region":"r3","zone":"z1"}`)
+		if err := p.UpdateJSONDict(update.(*jsonutils.JSONDict), true); err == nil {
+			t.Errorf("expecting error for unknown key")
+		}
+		if s.Region != "r2" {
+			t.Errorf("region updated on error: %s", s.Region)
+		}
+		if err := p.UpdateJSONDict(update.(*jsonutils.JSONDict), false); err != nil {
+			t.Errorf("UpdateJSONDict non-strict: %v", err)
+		}
+		if s.Region != "r3" {
+			t.Errorf("region not updated: %s", s.Region)
+		}
+	})
+}
+
+func TestDefaultEnv(t *testing.T) {
+	os.Setenv("STRUCTARG_TEST_HOME", "/home/test")
+	os.Unsetenv("STRUCTARG_TEST_UNSET")
+	defer os.Unsetenv("STRUCTARG_TEST_HOME")
+	type Options struct {
+		Data     string `default:"$STRUCTARG_TEST_HOME/.prog"`
+		Cache    string `default:"${STRUCTARG_TEST_HOME}/cache"`
+		Conf     string `default:"$STRUCTARG_TEST_UNSET|/etc/prog"`
+		Price    string `default:"$$5"`
+		Empty    string `default:"$STRUCTARG_TEST_UNSET"`
+		Fallback string `default:"|fallback"`
+	}
+	s := &Options{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs(nil, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	want := &Options{
+		Data:     "/home/test/.prog",
+		Cache:    "/home/test/cache",
+		Conf:     "/etc/prog",
+		Price:    "$5",
+		Fallback: "fallback",
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("want %#v, got %#v", want, s)
+	}
+}
+
+func TestRemoveArgument(t *testing.T) {
+	type Options struct {
+		Region string `default:"region-1"`
+		Zone   string `default:"zone-1"`
+		Debug  bool
+	}
+	newParser := func(t *testing.T) (*ArgumentParser, *Options) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.RemoveArgument("region"); err != nil {
+			t.Fatalf("RemoveArgument: %v", err)
+		}
+		if err := p.DisableArgument("Zone"); err != nil {
+			t.Fatalf("DisableArgument: %v", err)
+		}
+		return p, s
+	}
+	t.Run("command line", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--region", "r"},
+			{"--zone", "z"},
+		} {
+			p, _ := newParser(t)
+			err := p.ParseArgs(args, false)
+			if _, ok := err.(*UnknownArgumentError); !ok {
+				t.Errorf("%v: want UnknownArgumentError, got %v", args, err)
+			}
+		}
+	})
+	t.Run("default", func(t *testing.T) {
+		p, s := newParser(t)
+		if err := p.ParseArgs([]string{"--debug"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		want := &Options{Zone: "zone-1", Debug: true}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("want %#v, got %#v", want, s)
+		}
+		help := p.HelpString()
+		if strings.Contains(help, "--region") || strings.Contains(help, "--zone") {
+			t.Errorf("help with removed arguments:\n%s", help)
+		}
+	})
+	t.Run("config", func(t *testing.T) {
+		p, s := newParser(t)
+		p.SetStrictConfig(true)
+		if err := p.ParseArgs2(nil, false, false); err != nil {
+			t.Fatalf("ParseArgs2: %v", err)
+		}
+		dict := jsonutils.NewDict()
+		dict.Set("zone", jsonutils.NewString("zone-2"))
+		if err := p.parseJSONDict(dict); err != nil {
+			t.Errorf("disabled key: %v", err)
+		}
+		dict.Set("region", jsonutils.NewString("region-2"))
+		if err := p.parseJSONDict(dict); err == nil {
+			t.Errorf("removed key: want error")
+		}
+		p.SetDefault()
+		if s.Zone != "zone-1" || s.Region != "" {
+			t.Errorf("unexpected %#v", s)
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		p, _ := newParser(t)
+		for _, name := range []string{"region", "zone", "debug2"} {
+			if err := p.RemoveArgument(name); err == nil {
+				t.Errorf("%s: want error", name)
+			}
+		}
+	})
+	t.Run("referenced", func(t *testing.T) {
+		type Options struct {
+			CertFile string
+			KeyFile  string `requires:"cert-file"`
+			New      string
+			Old      string `deprecated:"use --new" replacement:"new"`
+		}
+		p := mustNewParser(t, &Options{})
+		p.SetWarningWriter(&bytes.Buffer{})
+		for _, name := range []string{"cert-file", "new"} {
+			if err := p.RemoveArgument(name); err == nil {
+				t.Errorf("%s: want error of referenced argument", name)
+			}
+			if err := p.DisableArgument(name); err == nil {
+				t.Errorf("%s: want error of referenced argument", name)
+			}
+		}
+		if err := p.ParseArgs([]string{"--key-file", "k"}, false); err == nil || !strings.Contains(err.Error(), "requires --cert-file") {
+			t.Errorf("want error of requires, got %v", err)
+		}
+		if err := p.ParseArgs([]string{"--old", "k"}, false); err != nil {
+			t.Errorf("ParseArgs: %v", err)
+		}
+		for _, name := range []string{"key-file", "cert-file", "old", "new"} {
+			if err := p.RemoveArgument(name); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	})
+}
+
+// testExternalArgument implements only the methods of Argument, not the
+// optional interfaces of SingleArgument
+type testExternalArgument struct {
+	value string
+	isSet bool
+}
+
+func (a *testExternalArgument) NeedData() bool        { return true }
+func (a *testExternalArgument) Token() string         { return "external" }
+func (a *testExternalArgument) AliasToken() string    { return "ext" }
+func (a *testExternalArgument) ShortToken() string    { return "" }
+func (a *testExternalArgument) NegativeToken() string { return "" }
+func (a *testExternalArgument) MetaVar() string       { return "EXTERNAL" }
+func (a *testExternalArgument) IsPositional() bool    { return false }
+func (a *testExternalArgument) IsRequired() bool      { return false }
+func (a *testExternalArgument) IsMulti() bool         { return false }
+func (a *testExternalArgument) IsSubcommand() bool    { return false }
+func (a *testExternalArgument) HelpString(indent string) string {
+	return indent + "external argument"
+}
+func (a *testExternalArgument) String() string { return "[--external EXTERNAL]" }
+func (a *testExternalArgument) SetValue(val string) error {
+	a.value, a.isSet = val, true
+	return nil
+}
+func (a *testExternalArgument) Reset()                   { a.value, a.isSet = "", false }
+func (a *testExternalArgument) DoAction(nega bool) error { return nil }
+func (a *testExternalArgument) Validate() error          { return nil }
+func (a *testExternalArgument) SetDefault()              {}
+func (a *testExternalArgument) IsSet() bool              { return a.isSet }
+
+func TestExternalArgument(t *testing.T) {
+	p := mustNewParser(t, &struct{ Name string }{})
+	arg := &testExternalArgument{}
+	if err := p.AddArgument(arg); err != nil {
+		t.Fatalf("AddArgument: %v", err)
+	}
+	if err := p.ParseArgs([]string{"--ext", "x", "--name", "n"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if arg.value != "x" {
+		t.Errorf("want x, got %q", arg.value)
+	}
+	if help := p.HelpString(); !strings.Contains(help, "external argument") {
+		t.Errorf("help missing external argument:\n%s", help)
+	}
+	if source := p.Source("external"); source != "" {
+		t.Errorf("want no source, got %q", source)
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	type Options struct {
+		Offset float64
+		Level  int    `short-token:"1"`
+		Name   string `short-token:"n"`
+		DELTA  int
+		VALUES []float64
+	}
+	cases := []struct {
+		name string
+		args []string
+		want Options
+		err  bool
+	}{
+		{"positionals", []string{"-5", "-0.5", "2", "-.25"}, Options{DELTA: -5, VALUES: []float64{-0.5, 2, -0.25}}, false},
+		{"option values", []string{"--offset", "-0.5", "-n", "-3", "4", "-1.5"}, Options{Offset: -0.5, Name: "-3", DELTA: 4, VALUES: []float64{-1.5}}, false},
+		{"after --", []string{"--", "-5", "-6"}, Options{DELTA: -5, VALUES: []float64{-6}}, false},
+		{"short token", []string{"-1", "2", "3", "4"}, Options{Level: 2, DELTA: 3, VALUES: []float64{4}}, false},
+		{"not number", []string{"-5x"}, Options{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Options{}
+			p := mustNewParser(t, s)
+			err := p.ParseArgs(c.args, false)
+			if c.err {
+				if _, ok := errors.Cause(err).(*UnknownArgumentError); !ok {
+					t.Errorf("expecting UnknownArgumentError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			if !reflect.DeepEqual(*s, c.want) {
+				t.Errorf("want %#v, got %#v", c.want, *s)
+			}
+		})
+	}
+
+	t.Run("string positional", func(t *testing.T) {
+		type Options struct {
+			NAME string
+		}
+		p := mustNewParser(t, &Options{})
+		if _, ok := errors.Cause(p.ParseArgs([]string{"-5"}, false)).(*UnknownArgumentError); !ok {
+			t.Errorf("expecting UnknownArgumentError for -5 of string positional")
+		}
+	})
+}
+
+func TestParseString(t *testing.T) {
+	type Options struct {
+		Name  string
+		Tags  []string
+		Debug bool
+		CMD   []string
+	}
+	s := &Options{}
+	p := mustNewParser(t, s)
+	if err := p.ParseString(`--name 'web server' --tags "a \"b\"" --tags c\ d --debug run -- --x`); err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	want := Options{Name: "web server", Tags: []string{`a "b"`, "c d"}, Debug: true, CMD: []string{"run", "--x"}}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("want %#v, got %#v", want, *s)
+	}
+	if err := p.ParseString(`--name 'web`); err == nil || !strings.Contains(err.Error(), "unterminated single quote") {
+		t.Errorf("expecting error of unterminated quote, got %v", err)
+	}
+}
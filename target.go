@@ -0,0 +1,122 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ResetTarget restores the target, subcommands included, to the values it
+// had before parsing, and forgets about arguments given so far.
+//
+// ParseArgs, ParseKnownArgs and ParseQuery of a parser shared by goroutines
+// are serialized so that their writes to the target do not interleave.  The
+// target is still shared though, values read after one parse may be those
+// of another concurrent one.  Readers should take RLockOptions and copy
+// what they need, e.g. by CopyTarget, while handlers of concurrent requests
+// are better off with targets of their own, see ParseArgsInto
+func (this *ArgumentParser) ResetTarget() {
+	this.optionsLock.Lock()
+	defer this.optionsLock.Unlock()
+	this.reset()
+	if subcmd := this.GetSubcommand(); subcmd != nil {
+		for _, data := range subcmd.subcommands {
+			data.parser.ResetTarget()
+		}
+	}
+}
+
+// CopyTarget returns a pointer to a copy of the target taken under the
+// lock of RLockOptions.  Slices, maps and pointers are copied as well, so
+// that the copy is not affected by later parses
+func (this *ArgumentParser) CopyTarget() interface{} {
+	this.optionsLock.RLock()
+	defer this.optionsLock.RUnlock()
+	target := reflect.ValueOf(this.target)
+	copied := reflect.New(target.Type().Elem())
+	copied.Elem().Set(copyValue(target.Elem()))
+	return copied.Interface()
+}
+
+// copyValue returns a deep copy of exported contents of value, values of
+// unexported fields and interfaces are shared with the original
+func copyValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(copyValue(value.Elem()))
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(copyValue(value.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() {
+				field.Set(copyValue(value.Field(i)))
+			}
+		}
+		return copied
+	}
+	return value
+}
+
+// ParseArgsInto parses args into target, a pointer of the same type as the
+// target of the parser, leaving the parser and its target untouched.  One
+// parser may thus serve concurrent parses, each filling a target of its
+// own.  As with ParseArgs, fields of target are overwritten by values of the
+// target of the parser before parsing, then by defaults and args.  Help is
+// not handled, --help is taken as an unknown argument, and parsers with
+// subcommands are not supported since their targets are not reachable
+func (this *ArgumentParser) ParseArgsInto(args []string, target interface{}) error {
+	if reflect.TypeOf(target) != reflect.TypeOf(this.target) {
+		return fmt.Errorf("Target of type %T expected, got %T", this.target, target)
+	}
+	if reflect.ValueOf(target).IsNil() {
+		return fmt.Errorf("Nil target")
+	}
+	if this.GetSubcommand() != nil {
+		return fmt.Errorf("ParseArgsInto does not support subcommands")
+	}
+	this.optionsLock.RLock()
+	parser, err := this.cloneInto(target)
+	this.optionsLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	parser.SetHelpEnabled(false)
+	return parser.ParseArgs(args, false)
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	type Options struct {
+		Zone   string `default:"z1"`
+		Tags   []string
+		Labels map[string]string
+		Count  *int
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--zone", "z2", "--tags", "a"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		p.ResetTarget()
+		if s.Zone != "" || s.Tags != nil {
+			t.Errorf("target not reset: %#v", s)
+		}
+		if p.IsSet("zone") {
+			t.Errorf("zone still set after ResetTarget")
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		s := &Options{Labels: map[string]string{"k": "v"}}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--tags", "a", "--count", "3"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		c := p.CopyTarget().(*Options)
+		if !reflect.DeepEqual(c, s) {
+			t.Fatalf("copy %#v differs from %#v", c, s)
+		}
+		s.Tags[0] = "b"
+		s.Labels["k"] = "w"
+		*s.Count = 4
+		if c.Tags[0] != "a" || c.Labels["k"] != "v" || *c.Count != 3 {
+			t.Errorf("copy shares values with target: %#v", c)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		s := &Options{}
+		p := mustNewParser(t, s)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tag := fmt.Sprintf("t%d", i)
+				if err := p.ParseArgs([]string{"--tags", tag, "--tags", tag}, false); err != nil {
+					t.Errorf("ParseArgs: %v", err)
+				}
+				p.RLockOptions()
+				defer p.RUnlockOptions()
+				if len(s.Tags) != 2 || s.Tags[0] != s.Tags[1] {
+					t.Errorf("torn tags %v", s.Tags)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func TestParseArgsInto(t *testing.T) {
+	type Options struct {
+		Zone   string `default:"z1"`
+		Tags   []string
+		Labels map[string]string
+		Debug  bool
+	}
+	s := &Options{Tags: make([]string, 1, 8), Labels: map[string]string{"k": "v"}}
+	p := mustNewParser(t, s)
+
+	t.Run("into", func(t *testing.T) {
+		o := &Options{Zone: "z0"}
+		if err := p.ParseArgsInto([]string{"--tags", "a", "--labels", "x=y", "--debug"}, o); err != nil {
+			t.Fatalf("ParseArgsInto: %v", err)
+		}
+		want := &Options{Zone: "z1", Tags: []string{"", "a"}, Labels: map[string]string{"k": "v", "x": "y"}, Debug: true}
+		if !reflect.DeepEqual(o, want) {
+			t.Errorf("want %#v, got %#v", want, o)
+		}
+		if len(s.Tags) != 1 || len(s.Labels) != 1 || s.Debug || s.Zone != "" {
+			t.Errorf("original target changed: %#v", s)
+		}
+		if p.IsSet("debug") {
+			t.Errorf("original parser changed")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if err := p.ParseArgsInto(nil, &struct{ Zone string }{}); err == nil {
+			t.Errorf("expecting error of target type")
+		}
+		if err := p.ParseArgsInto(nil, (*Options)(nil)); err == nil {
+			t.Errorf("expecting error of nil target")
+		}
+		if err := p.ParseArgsInto([]string{"--help"}, &Options{}); err == nil {
+			t.Errorf("expecting --help unknown")
+		}
+		if err := p.ParseArgsInto([]string{"--zone"}, &Options{}); err == nil {
+			t.Errorf("expecting error of missing value")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				o := &Options{}
+				tag := fmt.Sprintf("t%d", i)
+				if err := p.ParseArgsInto([]string{"--tags", tag, "--labels", tag + "=1"}, o); err != nil {
+					t.Errorf("ParseArgsInto: %v", err)
+					return
+				}
+				if len(o.Tags) != 2 || o.Tags[1] != tag || len(o.Labels) != 2 || o.Labels[tag] != "1" {
+					t.Errorf("wrong values %#v", o)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
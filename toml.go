@@ -0,0 +1,418 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/nyl1001/pkg/jsonutils"
+)
+
+// ParseTOMLFile parses configuration file in TOML format.  Tables map onto
+// members of nested struct and arrays map onto slice arguments
+func (this *ArgumentParser) ParseTOMLFile(filepath string) error {
+	return this.loadConfigFiles(this.recordConfigLoad((*ArgumentParser).parseTOMLFile, false, filepath))
+}
+
+func (this *ArgumentParser) parseTOMLFile(filepath string) error {
+	defer this.setSource(filepath)()
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("read file %s: %v", filepath, err)
+	}
+	dict, err := parseTOML(string(content))
+	if err != nil {
+		return fmt.Errorf("parse toml %s: %v", filepath, err)
+	}
+	return this.parseJSONDict(dict)
+}
+
+// sTOMLParser is a minimal TOML parser which converts the document into
+// JSONDict.  It supports tables, dotted keys, strings, numbers, booleans,
+// arrays and inline tables.  Date times are kept as strings.  Arrays of
+// tables are not supported as there is no argument they can map onto
+type sTOMLParser struct {
+	str  []byte
+	pos  int
+	line int
+}
+
+func parseTOML(content string) (*jsonutils.JSONDict, error) {
+	p := &sTOMLParser{str: []byte(content), line: 1}
+	root := jsonutils.NewDict()
+	current := root
+	for {
+		p.skipSpacesAndNewlines()
+		if p.eof() {
+			break
+		}
+		switch p.peek() {
+		case '[':
+			if p.hasPrefix("[[") {
+				return nil, p.errorf("array of tables is not supported")
+			}
+			p.pos++
+			keys, err := p.parseKeys()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpaces()
+			if p.eof() || p.peek() != ']' {
+				return nil, p.errorf("expecting ] for table header")
+			}
+			p.pos++
+			current, err = tomlTable(root, keys)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		default:
+			if err := p.parseKeyValue(current); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// tomlTable returns the dict of dotted keys, creating it when necessary
+func tomlTable(dict *jsonutils.JSONDict, keys []string) (*jsonutils.JSONDict, error) {
+	for _, key := range keys {
+		obj, err := dict.Get(key)
+		if err != nil {
+			subdict := jsonutils.NewDict()
+			dict.Set(key, subdict)
+			dict = subdict
+			continue
+		}
+		subdict, ok := obj.(*jsonutils.JSONDict)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not a table", key)
+		}
+		dict = subdict
+	}
+	return dict, nil
+}
+
+func (p *sTOMLParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *sTOMLParser) eof() bool {
+	return p.pos >= len(p.str)
+}
+
+func (p *sTOMLParser) peek() byte {
+	return p.str[p.pos]
+}
+
+func (p *sTOMLParser) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(p.str[p.pos:], []byte(prefix))
+}
+
+func (p *sTOMLParser) skipComment() {
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+func (p *sTOMLParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+func (p *sTOMLParser) skipSpacesAndNewlines() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.line++
+			p.pos++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *sTOMLParser) endOfLine() error {
+	p.skipSpaces()
+	if p.eof() {
+		return nil
+	}
+	switch p.peek() {
+	case '#':
+		p.skipComment()
+		return nil
+	case '\r', '\n':
+		return nil
+	}
+	return p.errorf("unexpected character %q", p.peek())
+}
+
+func (p *sTOMLParser) parseKeys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			return nil, p.errorf("expecting key")
+		}
+		var key string
+		var err error
+		switch p.peek() {
+		case '"', '\'':
+			key, err = p.parseString()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			start := p.pos
+			for !p.eof() && isTOMLBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expecting key")
+			}
+			key = string(p.str[start:p.pos])
+		}
+		keys = append(keys, key)
+		p.skipSpaces()
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
+
+func (p *sTOMLParser) parseKeyValue(dict *jsonutils.JSONDict) error {
+	keys, err := p.parseKeys()
+	if err != nil {
+		return err
+	}
+	p.skipSpaces()
+	if p.eof() || p.peek() != '=' {
+		return p.errorf("expecting = after key %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipSpaces()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	dict, err = tomlTable(dict, keys[:len(keys)-1])
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	dict.Set(keys[len(keys)-1], value)
+	return nil
+}
+
+func (p *sTOMLParser) parseValue() (jsonutils.JSONObject, error) {
+	if p.eof() {
+		return nil, p.errorf("expecting value")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return jsonutils.NewString(str), nil
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+	start := p.pos
+	for !p.eof() && strings.IndexByte(",]}#\r\n", p.peek()) < 0 {
+		p.pos++
+	}
+	word := strings.TrimSpace(string(p.str[start:p.pos]))
+	switch word {
+	case "true":
+		return jsonutils.NewBool(true), nil
+	case "false":
+		return jsonutils.NewBool(false), nil
+	case "":
+		return nil, p.errorf("expecting value")
+	}
+	num := strings.Replace(word, "_", "", -1)
+	if i, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return jsonutils.NewInt(i), nil
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return jsonutils.NewFloat64(f), nil
+	}
+	// date time and the like
+	return jsonutils.NewString(word), nil
+}
+
+func (p *sTOMLParser) parseArray() (jsonutils.JSONObject, error) {
+	// skip [
+	p.pos++
+	array := jsonutils.NewArray()
+	for {
+		p.skipSpacesAndNewlines()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return array, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array.Add(value)
+		p.skipSpacesAndNewlines()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expecting , or ] in array")
+		}
+	}
+}
+
+func (p *sTOMLParser) parseInlineTable() (jsonutils.JSONObject, error) {
+	// skip {
+	p.pos++
+	dict := jsonutils.NewDict()
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			return nil, p.errorf("unterminated inline table")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return dict, nil
+		}
+		if err := p.parseKeyValue(dict); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.eof() {
+			return nil, p.errorf("unterminated inline table")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expecting , or } in inline table")
+		}
+	}
+}
+
+func (p *sTOMLParser) parseString() (string, error) {
+	quote := p.peek()
+	multiline := false
+	delim := string([]byte{quote})
+	if p.hasPrefix(strings.Repeat(delim, 3)) {
+		multiline = true
+		delim = strings.Repeat(delim, 3)
+		p.pos += 3
+		// a newline immediately following the opening delimiter is trimmed
+		if p.hasPrefix("\r\n") {
+			p.pos += 2
+			p.line++
+		} else if p.hasPrefix("\n") {
+			p.pos++
+			p.line++
+		}
+	} else {
+		p.pos++
+	}
+	var buf bytes.Buffer
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if p.hasPrefix(delim) {
+			p.pos += len(delim)
+			return buf.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case c == '\n' && !multiline:
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos++
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.peek(); e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case '"', '\\':
+				buf.WriteByte(e)
+			case 'u', 'U':
+				size := 4
+				if e == 'U' {
+					size = 8
+				}
+				if p.pos+size >= len(p.str) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(string(p.str[p.pos+1:p.pos+1+size]), 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				buf.WriteRune(rune(code))
+				p.pos += size
+			case '\n':
+				// line ending backslash trims the following whitespaces
+				p.line++
+				for p.pos+1 < len(p.str) && strings.IndexByte(" \t\r\n", p.str[p.pos+1]) >= 0 {
+					if p.str[p.pos+1] == '\n' {
+						p.line++
+					}
+					p.pos++
+				}
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+			p.pos++
+		default:
+			if c == '\n' {
+				p.line++
+			}
+			buf.WriteByte(c)
+			p.pos++
+		}
+	}
+}
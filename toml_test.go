@@ -0,0 +1,128 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "scalars",
+			content: `
+# comment
+name = "hello # not comment" # comment
+literal = 'C:\path'
+count = 1_000
+ratio = 0.5
+enabled = true
+since = 2023-12-29T00:00:00Z
+`,
+			want: `{"count":1000,"enabled":true,"literal":"C:\\path","name":"hello # not comment","ratio":0.5,"since":"2023-12-29T00:00:00Z"}`,
+		},
+		{
+			name: "tables and arrays",
+			content: `
+hosts = [
+  "h1", # first
+  "h2",
+]
+[database]
+host = "db"
+conn.timeout = 3
+[database.replica]
+host = "replica"
+`,
+			want: `{"database":{"conn":{"timeout":3},"host":"db","replica":{"host":"replica"}},"hosts":["h1","h2"]}`,
+		},
+		{
+			name:    "inline table and multiline string",
+			content: "labels = { k1 = \"v1\", k2 = \"v2\" }\ncert = \"\"\"\nline1\nline2\"\"\"\n",
+			want:    `{"cert":"line1\nline2","labels":{"k1":"v1","k2":"v2"}}`,
+		},
+		{
+			name:    "array of tables",
+			content: "[[disks]]\nsize = 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "garbage after value",
+			content: "name = \"a\" b\n",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dict, err := parseTOML(c.content)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("expecting error, got %s", dict)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			if got := dict.String(); got != c.want {
+				t.Errorf("want %s, got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTOMLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "test.toml")
+	content := `
+region = "region-toml"
+hosts = ["h1", "h2"]
+[database]
+host = "db-host"
+port = 3306
+`
+	if err := ioutil.WriteFile(fpath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := &struct {
+		Region   string
+		Hosts    []string
+		Database struct {
+			Host string
+			Port int
+		}
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseFile(fpath); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if s.Region != "region-toml" || !reflect.DeepEqual(s.Hosts, []string{"h1", "h2"}) ||
+		s.Database.Host != "db-host" || s.Database.Port != 3306 {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+}
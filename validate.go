@@ -0,0 +1,225 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Validator is implemented by targets having invariants across fields, which
+// are checked after ParseArgs and ParseFile
+type Validator interface {
+	Validate() error
+}
+
+// ContextValidator is implemented by targets validating themselves with the
+// context of the parse, see ParseArgsContext.  It is preferred to Validator
+type ContextValidator interface {
+	ValidateContext(ctx context.Context) error
+}
+
+// PostParser is implemented by targets that complete themselves after
+// ParseArgs and ParseFile, e.g. deriving fields from the others
+type PostParser interface {
+	PostParse(parser *ArgumentParser) error
+}
+
+// postParse calls the hooks implemented by the target, Validate first then
+// PostParse.  The hooks of ParseArgs run after defaults are set, thus not by
+// ParseArgs2 without setDefaults, nor when help is requested
+func (this *ArgumentParser) postParse() error {
+	if validator, ok := this.target.(ContextValidator); ok {
+		if err := validator.ValidateContext(this.Context()); err != nil {
+			return err
+		}
+	} else if validator, ok := this.target.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return err
+		}
+	}
+	if postParser, ok := this.target.(PostParser); ok {
+		if err := postParser.PostParse(this); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue validates a parsed value against the constraints of the tags,
+// element-wise for pointers and slices
+func (this *SingleArgument) checkValue(value reflect.Value) error {
+	switch {
+	case this.isScalar(value.Type()):
+	case value.Kind() == reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		return this.checkValue(value.Elem())
+	case value.Kind() == reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if err := this.checkValue(value.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case value.Kind() == reflect.String:
+		return this.checkString(value.String())
+	case isNumberKind(value.Kind()):
+		return this.checkRange(value)
+	}
+	return nil
+}
+
+func (this *SingleArgument) checkString(val string) error {
+	if this.pattern != nil && !this.pattern.MatchString(val) {
+		return &PatternError{Argument: this.Token(), Value: this.shownValue(val), Pattern: this.pattern}
+	}
+	if length := utf8.RuneCountInString(val); length < this.minLen || (this.maxLen > 0 && length > this.maxLen) {
+		return &LengthError{Argument: this.Token(), Length: length, Range: this.lengthString()}
+	}
+	if len(this.file) > 0 {
+		if err := checkPath(val, this.file); err != nil {
+			return &PathError{Argument: this.Token(), Path: val, Err: err}
+		}
+	}
+	return nil
+}
+
+// parseLength parses the limits of minlen and maxlen tags
+func (this *SingleArgument) parseLength(min, max string) error {
+	if len(min) == 0 && len(max) == 0 {
+		return nil
+	}
+	if elemType(this.value.Type()).Kind() != reflect.String {
+		return fmt.Errorf("minlen and maxlen are applicable to string option ONLY")
+	}
+	var err error
+	if len(min) > 0 {
+		if this.minLen, err = strconv.Atoi(min); err != nil || this.minLen < 0 {
+			return fmt.Errorf("invalid minlen %q", min)
+		}
+	}
+	if len(max) > 0 {
+		if this.maxLen, err = strconv.Atoi(max); err != nil || this.maxLen <= 0 {
+			return fmt.Errorf("invalid maxlen %q", max)
+		}
+		if this.minLen > this.maxLen {
+			return fmt.Errorf("minlen %d is greater than maxlen %d", this.minLen, this.maxLen)
+		}
+	}
+	return nil
+}
+
+// lengthString renders the length limits as min..max, >=min or <=max
+func (this *SingleArgument) lengthString() string {
+	switch {
+	case this.minLen > 0 && this.maxLen > 0:
+		return fmt.Sprintf("%d..%d", this.minLen, this.maxLen)
+	case this.minLen > 0:
+		return fmt.Sprintf(">=%d", this.minLen)
+	case this.maxLen > 0:
+		return fmt.Sprintf("<=%d", this.maxLen)
+	}
+	return ""
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64 && kind != reflect.Uintptr
+}
+
+// parseRange parses the bounds of min and max tags as values of the element
+// type of the argument
+func (this *SingleArgument) parseRange(min, max string) error {
+	if len(min) == 0 && len(max) == 0 {
+		return nil
+	}
+	tp := elemType(this.value.Type())
+	if !isNumberKind(tp.Kind()) || this.isScalar(tp) {
+		return fmt.Errorf("min and max are applicable to numeric option ONLY")
+	}
+	var err error
+	if len(min) > 0 {
+		if this.minValue, err = this.parseValue(min, tp); err != nil {
+			return err
+		}
+	}
+	if len(max) > 0 {
+		if this.maxValue, err = this.parseValue(max, tp); err != nil {
+			return err
+		}
+	}
+	if this.minValue.IsValid() && this.maxValue.IsValid() && compareNumber(this.minValue, this.maxValue) > 0 {
+		return fmt.Errorf("min %s is greater than max %s", min, max)
+	}
+	return nil
+}
+
+// compareNumber returns -1, 0 or 1 as a is less than, equal to or greater
+// than b, both of the same kind
+func compareNumber(a, b reflect.Value) int {
+	switch {
+	case a.Kind() >= reflect.Float32:
+		return compareFloat(a.Float(), b.Float())
+	case a.Kind() >= reflect.Uint:
+		av, bv := a.Uint(), b.Uint()
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	default:
+		av, bv := a.Int(), b.Int()
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func (this *SingleArgument) checkRange(value reflect.Value) error {
+	if (this.minValue.IsValid() && compareNumber(value, this.minValue) < 0) ||
+		(this.maxValue.IsValid() && compareNumber(value, this.maxValue) > 0) {
+		return &RangeError{Argument: this.Token(), Value: value.Interface(), Range: this.rangeString()}
+	}
+	return nil
+}
+
+// rangeString renders the range as min..max, >=min or <=max
+func (this *SingleArgument) rangeString() string {
+	switch {
+	case this.minValue.IsValid() && this.maxValue.IsValid():
+		return fmt.Sprintf("%v..%v", this.minValue.Interface(), this.maxValue.Interface())
+	case this.minValue.IsValid():
+		return fmt.Sprintf(">=%v", this.minValue.Interface())
+	case this.maxValue.IsValid():
+		return fmt.Sprintf("<=%v", this.maxValue.Interface())
+	}
+	return ""
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPattern(t *testing.T) {
+	type Opts struct {
+		Name  string   `pattern:"^[a-z0-9-]+$" help:"name of the server"`
+		Tags  []string `pattern:"^\\w+=\\w+$"`
+		Owner *string  `pattern:"^[a-z]+$"`
+	}
+	parse := func(args ...string) (*Opts, error) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		return opts, parser.ParseArgs(args, false)
+	}
+	t.Run("valid", func(t *testing.T) {
+		opts, err := parse("--name", "web-01", "--tags", "a=b", "--owner", "bob")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Name != "web-01" || len(opts.Tags) != 1 || *opts.Owner != "bob" {
+			t.Errorf("unexpected %#v", opts)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--name", "Web_01"},
+			{"--tags", "a=b", "--tags", "a"},
+			{"--owner", "bob1"},
+		} {
+			_, err := parse(args...)
+			if err == nil {
+				t.Errorf("%v: expecting error", args)
+				continue
+			}
+			if !strings.Contains(err.Error(), "must match pattern ^") {
+				t.Errorf("%v: pattern not in error: %v", args, err)
+			}
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		help := parser.HelpString()
+		if !strings.Contains(help, "name of the server (pattern: ^[a-z0-9-]+$)") {
+			t.Errorf("pattern not in help:\n%s", help)
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		if _, err := NewArgumentParser(&struct {
+			Name string `pattern:"[a-"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of invalid pattern")
+		}
+		if _, err := NewArgumentParser(&struct {
+			Num int `pattern:"^1$"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of pattern on int")
+		}
+	})
+}
+
+func TestRange(t *testing.T) {
+	type Opts struct {
+		Port    int           `min:"1" max:"65535" help:"listen port"`
+		Ratio   float64       `min:"0" max:"1"`
+		Workers []uint        `min:"1"`
+		Timeout time.Duration `max:"1m"`
+		Memory  *int64        `unit:"bytes" max:"1G"`
+	}
+	parse := func(args ...string) (*Opts, error) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		return opts, parser.ParseArgs(args, false)
+	}
+	t.Run("valid", func(t *testing.T) {
+		opts, err := parse("--port", "65535", "--ratio", "0.5", "--workers", "1", "--timeout", "30s", "--memory", "512M")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Port != 65535 || opts.Ratio != 0.5 || opts.Timeout != 30*time.Second || *opts.Memory != 512<<20 {
+			t.Errorf("unexpected %#v", opts)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		cases := []struct {
+			args []string
+			msg  string
+		}{
+			{[]string{"--port", "70000"}, "Value 70000 of port out of range 1..65535"},
+			{[]string{"--port", "0"}, "out of range 1..65535"},
+			{[]string{"--ratio", "1.5"}, "out of range 0..1"},
+			{[]string{"--workers", "2", "--workers", "0"}, "out of range >=1"},
+			{[]string{"--timeout", "2m"}, "Value 2m0s of timeout out of range <=1m0s"},
+			{[]string{"--memory", "2G"}, "out of range <=1073741824"},
+		}
+		for _, c := range cases {
+			_, err := parse(c.args...)
+			if err == nil {
+				t.Errorf("%v: expecting error", c.args)
+				continue
+			}
+			if !strings.Contains(err.Error(), c.msg) {
+				t.Errorf("%v: want %q, got %v", c.args, c.msg, err)
+			}
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		help := parser.HelpString()
+		if !strings.Contains(help, "listen port (range: 1..65535)") {
+			t.Errorf("range not in help:\n%s", help)
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		if _, err := NewArgumentParser(&struct {
+			Name string `min:"1"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of min on string")
+		}
+		if _, err := NewArgumentParser(&struct {
+			Num int `min:"10" max:"1"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of min greater than max")
+		}
+		if _, err := NewArgumentParser(&struct {
+			Num int `max:"x"`
+		}{}, "prog", "", ""); err == nil {
+			t.Errorf("expecting error of invalid max")
+		}
+	})
+}
+
+func TestLength(t *testing.T) {
+	type Opts struct {
+		Password string   `minlen:"8" maxlen:"16" help:"login password"`
+		Names    []string `maxlen:"4"`
+		Desc     *string  `minlen:"1"`
+	}
+	parse := func(args ...string) (*Opts, error) {
+		opts := &Opts{}
+		parser := mustNewParser(t, opts)
+		return opts, parser.ParseArgs(args, false)
+	}
+	t.Run("valid", func(t *testing.T) {
+		opts, err := parse("--password", "12345678", "--names", "网络中心", "--names", "a", "--desc", "x")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Password != "12345678" || len(opts.Names) != 2 || *opts.Desc != "x" {
+			t.Errorf("unexpected %#v", opts)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		cases := []struct {
+			args []string
+			msg  string
+		}{
+			{[]string{"--password", "1234567"}, "Length 7 of password out of range 8..16"},
+			{[]string{"--password", strings.Repeat("x", 17)}, "out of range 8..16"},
+			{[]string{"--names", "a", "--names", "abcde"}, "Length 5 of names out of range <=4"},
+			{[]string{"--desc", ""}, "out of range >=1"},
+		}
+		for _, c := range cases {
+			_, err := parse(c.args...)
+			if err == nil {
+				t.Errorf("%v: expecting error", c.args)
+				continue
+			}
+			if !strings.Contains(err.Error(), c.msg) {
+				t.Errorf("%v: want %q, got %v", c.args, c.msg, err)
+			}
+		}
+	})
+	t.Run("help", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		help := parser.HelpString()
+		if !strings.Contains(help, "login password (length: 8..16)") {
+			t.Errorf("length not in help:\n%s", help)
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		for _, opts := range []interface{}{
+			&struct {
+				Num int `maxlen:"1"`
+			}{},
+			&struct {
+				Name string `minlen:"x"`
+			}{},
+			&struct {
+				Name string `maxlen:"0"`
+			}{},
+			&struct {
+				Name string `minlen:"5" maxlen:"1"`
+			}{},
+		} {
+			if _, err := NewArgumentParser(opts, "prog", "", ""); err == nil {
+				t.Errorf("%#v: expecting error", opts)
+			}
+		}
+	})
+}
+
+type testHookOptions struct {
+	Start int
+	End   int `default:"10"`
+	Span  int `help:"derived from start and end"`
+
+	postParsed int
+}
+
+func (opts *testHookOptions) Validate() error {
+	if opts.Start > opts.End {
+		return fmt.Errorf("start %d is after end %d", opts.Start, opts.End)
+	}
+	return nil
+}
+
+func (opts *testHookOptions) PostParse(parser *ArgumentParser) error {
+	opts.postParsed++
+	if !parser.IsSet("span") {
+		opts.Span = opts.End - opts.Start
+	}
+	return nil
+}
+
+func TestPostParseHooks(t *testing.T) {
+	t.Run("args", func(t *testing.T) {
+		opts := &testHookOptions{}
+		parser := mustNewParser(t, opts)
+		if err := parser.ParseArgs([]string{"--start", "3"}, false); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.Span != 7 || opts.postParsed != 1 {
+			t.Errorf("unexpected %#v", opts)
+		}
+		err := parser.ParseArgs([]string{"--start", "11"}, false)
+		if err == nil || err.Error() != "start 11 is after end 10" {
+			t.Errorf("expecting error of Validate, got %v", err)
+		}
+	})
+	t.Run("no defaults", func(t *testing.T) {
+		opts := &testHookOptions{}
+		parser := mustNewParser(t, opts)
+		if err := parser.ParseArgs2([]string{"--start", "11"}, false, false); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if opts.postParsed != 0 {
+			t.Errorf("hooks run without defaults")
+		}
+	})
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "structarg")
+		if err != nil {
+			t.Fatalf("TempDir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		conf := filepath.Join(dir, "conf.yaml")
+		if err := ioutil.WriteFile(conf, []byte("start: 20\nend: 30\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		opts := &testHookOptions{}
+		parser := mustNewParser(t, opts)
+		if err := parser.ParseFile(conf); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+		if opts.Span != 10 || opts.postParsed != 1 {
+			t.Errorf("unexpected %#v", opts)
+		}
+		if err := ioutil.WriteFile(conf, []byte("start: 40\nend: 30\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		parser = mustNewParser(t, &testHookOptions{})
+		if err := parser.ParseFile(conf); err == nil {
+			t.Errorf("expecting error of Validate")
+		}
+	})
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyl1001/pkg/errors"
+	"github.com/nyl1001/pkg/gotypes"
+	"github.com/nyl1001/pkg/utils"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	urlType      = reflect.TypeOf(url.URL{})
+)
+
+var (
+	typeParsers     = make(map[reflect.Type]func(string) (interface{}, error))
+	typeParsersLock sync.RWMutex
+)
+
+func init() {
+	RegisterTypeParser(reflect.TypeOf(net.IP{}), parseIP)
+	RegisterTypeParser(reflect.TypeOf(net.IPNet{}), parseIPNet)
+	RegisterTypeParser(urlType, parseURL)
+}
+
+func parseURL(val string) (interface{}, error) {
+	u, err := url.Parse(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Scheme) == 0 {
+		return nil, fmt.Errorf("invalid URL %q, missing scheme, e.g. https://", val)
+	}
+	return *u, nil
+}
+
+func parseIP(val string) (interface{}, error) {
+	ip := net.ParseIP(val)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", val)
+	}
+	return ip, nil
+}
+
+func parseIPNet(val string) (interface{}, error) {
+	_, ipnet, err := net.ParseCIDR(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q, expecting address/prefix length, e.g. 10.0.0.0/8", val)
+	}
+	return *ipnet, nil
+}
+
+// RegisterTypeParser teaches all parsers to convert strings into values of
+// type tp, e.g. decimal.Decimal.  The handler applies to fields of type tp,
+// pointers and slices of tp.  It must return a value of type tp
+func RegisterTypeParser(tp reflect.Type, parse func(string) (interface{}, error)) {
+	typeParsersLock.Lock()
+	defer typeParsersLock.Unlock()
+	typeParsers[tp] = parse
+	// fields of tp are no longer walked into as structs
+	purgeParserTemplates()
+}
+
+func getTypeParser(tp reflect.Type) func(string) (interface{}, error) {
+	typeParsersLock.RLock()
+	defer typeParsersLock.RUnlock()
+	return typeParsers[tp]
+}
+
+var (
+	parseFuncs     = make(map[string]reflect.Value)
+	parseFuncsLock sync.RWMutex
+)
+
+// RegisterParseFunc registers a function named name for the parser tag.
+// fn must be of signature func(string) (T, error)
+func RegisterParseFunc(name string, fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	if err := checkParseFunc(fv); err != nil {
+		return errors.Wrapf(err, "parse func %s", name)
+	}
+	parseFuncsLock.Lock()
+	defer parseFuncsLock.Unlock()
+	parseFuncs[name] = fv
+	purgeParserTemplates()
+	return nil
+}
+
+func checkParseFunc(fv reflect.Value) error {
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("not a function")
+	}
+	ft := fv.Type()
+	if ft.NumIn() != 1 || ft.In(0).Kind() != reflect.String || ft.NumOut() != 2 || ft.Out(1) != errorType {
+		return fmt.Errorf("expecting func(string) (T, error), got %s", ft)
+	}
+	return nil
+}
+
+// findParseFunc finds the function of parser tag, the method of target
+// first, then the function registered by RegisterParseFunc
+func findParseFunc(target interface{}, name string) (reflect.Value, error) {
+	if method := reflect.ValueOf(target).MethodByName(name); method.IsValid() {
+		return method, checkParseFunc(method)
+	}
+	parseFuncsLock.RLock()
+	defer parseFuncsLock.RUnlock()
+	if fv, ok := parseFuncs[name]; ok {
+		return fv, nil
+	}
+	return reflect.Value{}, fmt.Errorf("no method or registered function %s", name)
+}
+
+// isScalarType tells whether values of tp are parsed as a whole instead of
+// being collected as multiple values, though it is a slice, e.g. net.IP
+func isScalarType(tp reflect.Type) bool {
+	return getTypeParser(tp) != nil
+}
+
+// isScalar tells whether values of tp are parsed as a whole, by the registered
+// type parser or the function of parser tag
+func (this *SingleArgument) isScalar(tp reflect.Type) bool {
+	return isScalarType(tp) || (this.parseFunc.IsValid() && this.parseFunc.Type().Out(0) == tp)
+}
+
+// isPlainString tells whether values of string type tp are taken as they
+// are by parseValue
+func (this *SingleArgument) isPlainString(tp reflect.Type) bool {
+	return len(this.file) == 0 && !this.isScalar(tp)
+}
+
+// parseValue converts a string into a value of type tp.  Names of enum,
+// types registered by RegisterTypeParser and those not known to gotypes,
+// e.g. time.Duration, are handled here and the rest is delegated to
+// gotypes.ParseValue
+func (this *SingleArgument) parseValue(val string, tp reflect.Type) (reflect.Value, error) {
+	if this.enum && tp == this.choiceType() {
+		for i, name := range this.choices {
+			if name == val {
+				return this.choiceValues[i], nil
+			}
+		}
+	}
+	if this.parseFunc.IsValid() && this.parseFunc.Type().Out(0) == tp {
+		out := this.parseFunc.Call([]reflect.Value{reflect.ValueOf(val).Convert(this.parseFunc.Type().In(0))})
+		if !out[1].IsNil() {
+			return reflect.Value{}, fmt.Errorf("Cannot parse %s to %s: %v", val, tp, out[1].Interface())
+		}
+		return out[0], nil
+	}
+	if len(this.file) > 0 && tp.Kind() == reflect.String {
+		path, err := expandHome(val)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(path).Convert(tp), nil
+	}
+	if this.unit == UNIT_BYTES && tp.Kind() >= reflect.Int && tp.Kind() <= reflect.Uint64 {
+		size, err := ParseSize(val)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv := reflect.New(tp).Elem()
+		if tp.Kind() >= reflect.Uint {
+			if size < 0 || rv.OverflowUint(uint64(size)) {
+				return reflect.Value{}, fmt.Errorf("size %q out of range of %s", val, tp)
+			}
+			rv.SetUint(uint64(size))
+		} else {
+			if rv.OverflowInt(size) {
+				return reflect.Value{}, fmt.Errorf("size %q out of range of %s", val, tp)
+			}
+			rv.SetInt(size)
+		}
+		return rv, nil
+	}
+	if parse := getTypeParser(tp); parse != nil {
+		v, err := parse(val)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("Cannot parse %s to %s: %v", val, tp, err)
+		}
+		rv := reflect.ValueOf(v)
+		if !rv.IsValid() || rv.Type() != tp {
+			return reflect.Value{}, fmt.Errorf("Parser of %s returns %T", tp, v)
+		}
+		if tp == urlType && len(this.schemes) > 0 {
+			if scheme := rv.Interface().(url.URL).Scheme; !utils.IsInStringArray(strings.ToLower(scheme), this.schemes) {
+				return reflect.Value{}, fmt.Errorf("Scheme %s of %s is not allowed, accepts %s", scheme, val, quotedChoicesString(this.schemes))
+			}
+		}
+		return rv, nil
+	}
+	switch tp {
+	case durationType:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("Cannot parse %s to %s: %v", val, tp, err)
+		}
+		return reflect.ValueOf(d), nil
+	case gotypes.TimeType:
+		if len(this.layouts) > 0 {
+			return this.parseTime(val)
+		}
+	}
+	switch tp.Kind() {
+	case reflect.Ptr:
+		rv, err := this.parseValue(val, tp.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rvv := reflect.New(tp.Elem())
+		rvv.Elem().Set(rv)
+		return rvv, nil
+	case reflect.Slice:
+		values := utils.FindWords([]byte(val), 0)
+		sliceVal := reflect.MakeSlice(tp, len(values), len(values))
+		for i, vv := range values {
+			vvv, err := this.parseValue(vv, tp.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			sliceVal.Index(i).Set(vvv)
+		}
+		return sliceVal, nil
+	}
+	rv, err := gotypes.ParseValue(val, tp)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if rv.Type() != tp && rv.Type().ConvertibleTo(tp) {
+		// named types, e.g. type Level int
+		rv = rv.Convert(tp)
+	}
+	return rv, nil
+}
+
+// parseTime tries layouts from the layout tag in order, then RFC3339
+func (this *SingleArgument) parseTime(val string) (reflect.Value, error) {
+	for _, layout := range this.layouts {
+		if tm, err := time.Parse(layout, val); err == nil {
+			return reflect.ValueOf(tm), nil
+		}
+	}
+	tm, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		layouts := make([]string, 0, len(this.layouts)+1)
+		layouts = append(layouts, this.layouts...)
+		layouts = append(layouts, time.RFC3339)
+		return reflect.Value{}, fmt.Errorf("Cannot parse %s to time, accepts layout %s", val, quotedChoicesString(layouts))
+	}
+	return reflect.ValueOf(tm), nil
+}
+
+// setValue sets value from string.  Slices are appended to as with
+// gotypes.SetValue
+func (this *SingleArgument) setValue(value reflect.Value, val string) error {
+	if !value.CanSet() {
+		return fmt.Errorf("Value is not settable")
+	}
+	if value.Kind() == reflect.String && this.isPlainString(value.Type()) {
+		// fast path without allocations of parseValue
+		if err := this.checkString(val); err != nil {
+			return err
+		}
+		value.SetString(val)
+		return nil
+	}
+	rv, err := this.parseValue(val, value.Type())
+	if err != nil {
+		return this.conversionError(val, value.Type(), err)
+	}
+	if err := this.checkValue(rv); err != nil {
+		return err
+	}
+	switch {
+	case value.Kind() == reflect.Slice && !this.isScalar(value.Type()):
+		value.Set(reflect.AppendSlice(value, rv))
+	default:
+		value.Set(rv)
+	}
+	return nil
+}
+
+// conversionError reports val not convertible to tp, errors of secret
+// values are replaced since they usually quote the value
+func (this *SingleArgument) conversionError(val string, tp reflect.Type, err error) error {
+	if this.secret {
+		val = SECRET_MASK
+		err = fmt.Errorf("Cannot parse secret value of %s to %s", this.Token(), tp)
+	}
+	return &ConversionError{Argument: this.Token(), Value: val, Type: tp, Err: err}
+}
+
+func (this *SingleArgument) appendValue(value reflect.Value, val string) error {
+	if value.Kind() != reflect.Slice {
+		return fmt.Errorf("Cannot append to non-slice type")
+	}
+	rv, err := this.parseValue(val, value.Type().Elem())
+	if err != nil {
+		return this.conversionError(val, value.Type().Elem(), err)
+	}
+	if err := this.checkValue(rv); err != nil {
+		return err
+	}
+	value.Set(reflect.Append(value, rv))
+	return nil
+}
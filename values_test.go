@@ -0,0 +1,211 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testVersion struct {
+	Major, Minor int
+}
+
+type testBytes []byte
+
+func init() {
+	RegisterTypeParser(reflect.TypeOf(testVersion{}), func(s string) (interface{}, error) {
+		parts := strings.Split(s, ".")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expecting major.minor")
+		}
+		major, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return testVersion{Major: major, Minor: minor}, nil
+	})
+	RegisterTypeParser(reflect.TypeOf(testBytes{}), func(s string) (interface{}, error) {
+		return testBytes(s), nil
+	})
+}
+
+func TestRegisterTypeParser(t *testing.T) {
+	s := &struct {
+		Version  testVersion `default:"1.0"`
+		Min      *testVersion
+		Versions []testVersion
+		Raw      testBytes
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--min", "2.1", "--versions", "3.0", "--versions", "3.1", "--raw", "abc"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Version != (testVersion{1, 0}) || s.Min == nil || *s.Min != (testVersion{2, 1}) ||
+		!reflect.DeepEqual(s.Versions, []testVersion{{3, 0}, {3, 1}}) || string(s.Raw) != "abc" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.ParseArgs([]string{"--version", "x"}, false); err == nil || !strings.Contains(err.Error(), "expecting major.minor") {
+		t.Errorf("expecting parse error, got %v", err)
+	}
+}
+
+type testParserOptions struct {
+	Version testVersion `parser:"ParseVersion"`
+	Ports   []int       `parser:"ParsePort"`
+	Range   []int       `parser:"ParseRange" default:"1-3"`
+	Level   string      `parser:"testUpper"`
+}
+
+func (opts *testParserOptions) ParseVersion(s string) (testVersion, error) {
+	var v testVersion
+	_, err := fmt.Sscanf(s, "v%d.%d", &v.Major, &v.Minor)
+	return v, err
+}
+
+func (opts testParserOptions) ParsePort(s string) (int, error) {
+	if s == "http" {
+		return 80, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func (opts *testParserOptions) ParseRange(s string) ([]int, error) {
+	var from, to int
+	if _, err := fmt.Sscanf(s, "%d-%d", &from, &to); err != nil {
+		return nil, err
+	}
+	var ret []int
+	for i := from; i <= to; i++ {
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+func TestParserTag(t *testing.T) {
+	if err := RegisterParseFunc("testUpper", func(s string) (string, error) {
+		return strings.ToUpper(s), nil
+	}); err != nil {
+		t.Fatalf("RegisterParseFunc: %v", err)
+	}
+	if err := RegisterParseFunc("bad", func(s string) string { return s }); err == nil {
+		t.Errorf("expecting error for bad signature")
+	}
+	s := &testParserOptions{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--version", "v1.2", "--ports", "http", "--ports", "8080", "--level", "debug"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Version != (testVersion{1, 2}) || !reflect.DeepEqual(s.Ports, []int{80, 8080}) || !reflect.DeepEqual(s.Range, []int{1, 2, 3}) || s.Level != "DEBUG" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if err := p.ParseArgs([]string{"--range", "5-6"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if !reflect.DeepEqual(s.Range, []int{5, 6}) {
+		t.Errorf("range: got %v", s.Range)
+	}
+	if err := p.ParseArgs([]string{"--version", "1.2"}, false); err == nil {
+		t.Errorf("expecting parse error")
+	}
+	t.Run("not found", func(t *testing.T) {
+		s := &struct {
+			Level string `parser:"NoSuchFunc"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for unknown parser")
+		}
+	})
+}
+
+func TestNetFields(t *testing.T) {
+	s := &struct {
+		Ip      net.IP
+		Dns     []net.IP
+		Network net.IPNet
+		Routes  []*net.IPNet
+		Gateway *net.IP
+	}{}
+	p := mustNewParser(t, s)
+	args := []string{"--ip", "10.0.0.5", "--dns", "8.8.8.8", "--dns", "2001:db8::1", "--network", "10.0.0.5/24", "--routes", "192.168.0.0/16", "--gateway", "10.0.0.1"}
+	if err := p.ParseArgs(args, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Ip.String() != "10.0.0.5" || len(s.Dns) != 2 || s.Dns[1].String() != "2001:db8::1" ||
+		s.Network.String() != "10.0.0.0/24" || len(s.Routes) != 1 || s.Routes[0].String() != "192.168.0.0/16" ||
+		s.Gateway == nil || s.Gateway.String() != "10.0.0.1" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	want := `{"dns":["8.8.8.8","2001:db8::1"],"gateway":"10.0.0.1","ip":"10.0.0.5","network":"10.0.0.0/24","routes":["192.168.0.0/16"]}`
+	if got := p.JSONDict(true).String(); got != want {
+		t.Errorf("JSONDict: want %s, got %s", want, got)
+	}
+	for _, args := range [][]string{
+		{"--ip", "10.0.0.256"},
+		{"--network", "10.0.0.0"},
+		{"--routes", "10.0.0.0/33"},
+	} {
+		if err := p.ParseArgs(args, false); err == nil {
+			t.Errorf("%q: expecting error", args)
+		} else if !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%q: unclear error %v", args, err)
+		}
+	}
+}
+
+func TestURLField(t *testing.T) {
+	s := &struct {
+		Endpoint *url.URL `schemes:"http|https"`
+		Mirrors  []url.URL
+		Proxy    url.URL
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--endpoint", "HTTPS://host:8080/path", "--mirrors", "ftp://m1", "--proxy", "socks5://p:1080"}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.Endpoint == nil || s.Endpoint.Host != "host:8080" || s.Endpoint.Path != "/path" ||
+		len(s.Mirrors) != 1 || s.Mirrors[0].Scheme != "ftp" || s.Proxy.Port() != "1080" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if got := p.JSONDict(true).String(); got != `{"endpoint":"https://host:8080/path","mirrors":["ftp://m1"],"proxy":"socks5://p:1080"}` {
+		t.Errorf("JSONDict: got %s", got)
+	}
+	for _, args := range [][]string{
+		{"--endpoint", "ftp://host"},
+		{"--endpoint", "host:8080"},
+		{"--proxy", "/no/scheme"},
+	} {
+		if err := p.ParseArgs(args, false); err == nil {
+			t.Errorf("%q: expecting error", args)
+		}
+	}
+	t.Run("bad schemes tag", func(t *testing.T) {
+		s := &struct {
+			Endpoint string `schemes:"http"`
+		}{}
+		if _, err := NewArgumentParser(s, "prog", "", ""); err == nil {
+			t.Errorf("expecting error for schemes on string")
+		}
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// sVersionArg is the --version argument registered by SetVersion
+type sVersionArg struct {
+	sHelpArg
+	parser  *ArgumentParser
+	version string
+}
+
+func (self *sVersionArg) Token() string {
+	return "version"
+}
+
+func (self *sVersionArg) HelpString(indent string) string {
+	return indent + "Print version and exit."
+}
+
+func (self *sVersionArg) ShortToken() string {
+	return ""
+}
+
+func (self *sVersionArg) String() string {
+	return "[--version]"
+}
+
+func (self *sVersionArg) DoAction(nega bool) error {
+	fmt.Fprintln(self.parser.out(), self.version)
+	self.parser.versionSet = true
+	self.parser.help = true
+	return nil
+}
+
+func (self *sVersionArg) cloneArgument(parser *ArgumentParser, value reflect.Value) (Argument, error) {
+	return &sVersionArg{parser: parser, version: self.version}, nil
+}
+
+func (self *sVersionArg) setParser(parser *ArgumentParser) {
+	self.parser = parser
+}
+
+// SetVersion registers a --version argument which prints version and exits.
+// After --version, both IsVersionSet and IsHelpSet report true so that
+// callers exit as they do for --help
+func (this *ArgumentParser) SetVersion(version string) error {
+	for _, arg := range this.optArgs {
+		if varg, ok := arg.(*sVersionArg); ok {
+			varg.version = version
+			return nil
+		}
+	}
+	return this.AddArgument(&sVersionArg{parser: this, version: version})
+}
+
+func (this *ArgumentParser) IsVersionSet() bool {
+	return this.versionSet
+}
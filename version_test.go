@@ -0,0 +1,67 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetVersion(t *testing.T) {
+	type Opts struct {
+		Debug bool
+	}
+	t.Run("version", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		if err := parser.SetVersion("v1.0.0"); err != nil {
+			t.Fatalf("SetVersion: %v", err)
+		}
+		if err := parser.SetVersion("v1.0.1"); err != nil {
+			t.Fatalf("SetVersion again: %v", err)
+		}
+		if err := parser.ParseArgs([]string{"--version"}, false); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if !parser.IsVersionSet() || !parser.IsHelpSet() {
+			t.Errorf("version not signaled")
+		}
+		if err := parser.ParseArgs([]string{"--debug"}, false); err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		if parser.IsVersionSet() || parser.IsHelpSet() {
+			t.Errorf("version signaled without --version")
+		}
+		if help := parser.HelpString(); !strings.Contains(help, "--version") || !strings.Contains(help, "Print version and exit.") {
+			t.Errorf("version not in help:\n%s", help)
+		}
+		if usage := parser.Usage(); !strings.Contains(usage, "[--version]") {
+			t.Errorf("version not in usage: %s", usage)
+		}
+	})
+	t.Run("without", func(t *testing.T) {
+		parser := mustNewParser(t, &Opts{})
+		if err := parser.ParseArgs([]string{"--version"}, false); err == nil {
+			t.Errorf("expecting error of unknown --version")
+		}
+	})
+	t.Run("conflict", func(t *testing.T) {
+		parser := mustNewParser(t, &struct {
+			Version string
+		}{})
+		if err := parser.SetVersion("v1.0.0"); err == nil {
+			t.Errorf("expecting error of duplicate --version")
+		}
+	})
+}